@@ -12,6 +12,24 @@ type AstNode interface {
 	Pos() Pos
 }
 
+// Where the source of the node starts, which is not always the token of the node
+func Start(node AstNode) Pos {
+	switch n := node.(type) {
+	case AssignmentNode:
+		return Start(n.Left)
+	case BinaryNode:
+		return Start(n.Left)
+	case FnCallNode:
+		if n.Dot {
+			return Start(n.Args[0])
+		}
+		return Start(n.Fn)
+	case MemberNode:
+		return Start(n.Module)
+	}
+	return node.Pos()
+}
+
 type IntNode struct {
 	Payload int64
 	Tok     *Token
@@ -115,6 +133,21 @@ func (n BinaryNode) Pos() Pos {
 	return n.Tok.Pos
 }
 
+// A prefix operator: !a or -a
+type UnaryNode struct {
+	Op      TokKind
+	Operand AstNode
+	Tok     *Token
+}
+
+func (n UnaryNode) String() string {
+	opTok := Token{Kind: n.Op}
+	return "(" + opTok.String() + n.Operand.String() + ")"
+}
+func (n UnaryNode) Pos() Pos {
+	return n.Tok.Pos
+}
+
 type BlockNode struct {
 	Exprs []AstNode
 	Tok   *Token
@@ -132,9 +165,32 @@ func (n BlockNode) Pos() Pos {
 	return n.Tok.Pos
 }
 
+// A type used in a parameter: Int, List(Int) or Maybe(a)
+type Type struct {
+	Name   string
+	Params []Type
+}
+
+func (t Type) String() string {
+	if len(t.Params) == 0 {
+		return t.Name
+	}
+	paramStrings := make([]string, len(t.Params))
+	for i, param := range t.Params {
+		paramStrings[i] = param.String()
+	}
+	return t.Name + "(" + strings.Join(paramStrings, ", ") + ")"
+}
+
 type Arg struct {
-	Name  string
-	Alias string // optional
+	Name   string
+	Alias  string // optional
+	Params []Type // optional, the type parameters given to Alias
+}
+
+// The full type of the argument, with its type parameters
+func (a Arg) Type() Type {
+	return Type{Name: a.Alias, Params: a.Params}
 }
 
 // NOTE: why does this not implement fmt.Stringer?
@@ -142,13 +198,14 @@ func (a Arg) String() string {
 	if a.Alias == "" {
 		return a.Name
 	}
-	return fmt.Sprintf("%s:%s", a.Name, a.Alias)
+	return fmt.Sprintf("%s:%s", a.Name, a.Type())
 }
 
 type FnNode struct {
-	Args []Arg
-	Body AstNode
-	Tok  *Token
+	Args   []Arg
+	Return *Type // optional: (a:Int) -> Str => ...
+	Body   AstNode
+	Tok    *Token
 }
 
 func (n FnNode) String() string {
@@ -158,6 +215,9 @@ func (n FnNode) String() string {
 	for _, v := range n.Args {
 		args = append(args, v)
 	}
+	if n.Return != nil {
+		return fmt.Sprintf("(%s) -> %s => %s", util.StringsJoin(args, ", "), n.Return, n.Body.String())
+	}
 	return fmt.Sprintf("(%s) => %s", util.StringsJoin(args, ", "), n.Body.String())
 }
 
@@ -168,6 +228,7 @@ func (n FnNode) Pos() Pos {
 type FnCallNode struct {
 	Fn   AstNode
 	Args []AstNode
+	Dot  bool // called as a.f(b), which is f(a, b)
 	Tok  *Token
 }
 
@@ -190,6 +251,20 @@ func (n FnCallNode) FirstArgName() string {
 	return n.Args[0].String()
 }
 
+// A top-level binding of a module, that is not called: math.pi
+type MemberNode struct {
+	Module AstNode
+	Name   string
+	Tok    *Token // the name
+}
+
+func (n MemberNode) String() string {
+	return n.Module.String() + "." + n.Name
+}
+func (n MemberNode) Pos() Pos {
+	return n.Tok.Pos
+}
+
 type ListNode struct {
 	Elems []AstNode
 	Tok   *Token
@@ -206,13 +281,52 @@ func (n ListNode) Pos() Pos {
 	return n.Tok.Pos
 }
 
+// {"a": 1, "b": 2}
+type MapNode struct {
+	Keys   []AstNode
+	Values []AstNode
+	Tok    *Token
+}
+
+func (n MapNode) String() string {
+	entryStrings := make([]string, len(n.Keys))
+	for i, key := range n.Keys {
+		entryStrings[i] = key.String() + ": " + n.Values[i].String()
+	}
+	return "{" + strings.Join(entryStrings, ", ") + "}"
+}
+func (n MapNode) Pos() Pos {
+	return n.Tok.Pos
+}
+
+// Only valid as the last element of a list pattern in a match branch:
+// [head, ..tail]
+type RestNode struct {
+	Name string // empty for .._
+	Tok  *Token
+}
+
+func (n RestNode) String() string {
+	if n.Name == "" {
+		return ".._"
+	}
+	return ".." + n.Name
+}
+func (n RestNode) Pos() Pos {
+	return n.Tok.Pos
+}
+
 // Special in the sense that it is not a node.
 type MatchBranch struct {
 	Target AstNode // the "pattern" to match. Maybe I should do something fancy here later
+	Guard  AstNode // optional: pattern if guard -> body
 	Body   AstNode
 }
 
 func (n MatchBranch) String() string {
+	if n.Guard != nil {
+		return n.Target.String() + " if " + n.Guard.String() + " -> " + n.Body.String()
+	}
 	return n.Target.String() + " -> " + n.Body.String()
 }
 
@@ -234,8 +348,10 @@ func (n MatchNode) Pos() Pos {
 	return n.Tok.Pos
 }
 
+// alias Maybe(a) = Maybe::Some(a) | Maybe::None
 type AliasNode struct {
 	Name    string
+	Params  []string // optional type parameters
 	Targets []AstNode
 	Tok     *Token
 }
@@ -245,7 +361,11 @@ func (t AliasNode) String() string {
 	for i, target := range t.Targets {
 		targetStrings[i] = target.String()
 	}
-	return "alias " + t.Name + " = " + strings.Join(targetStrings, " | ")
+	name := t.Name
+	if len(t.Params) > 0 {
+		name += "(" + strings.Join(t.Params, ", ") + ")"
+	}
+	return "alias " + name + " = " + strings.Join(targetStrings, " | ")
 }
 
 func (t AliasNode) Pos() Pos {
@@ -274,3 +394,22 @@ func (e EnumNode) String() string {
 func (e EnumNode) Pos() Pos {
 	return e.Tok.Pos
 }
+
+// Either: import "path/to/file.raja"
+// or:     import name
+type ImportNode struct {
+	Path string
+	Name string // only set when importing by name
+	Tok  *Token
+}
+
+func (n ImportNode) String() string {
+	if n.Name != "" {
+		return "import " + n.Name
+	}
+	return "import " + strconv.Quote(n.Path)
+}
+
+func (n ImportNode) Pos() Pos {
+	return n.Tok.Pos
+}
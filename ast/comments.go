@@ -0,0 +1,90 @@
+package ast
+
+// Comments are not part of the AST. The parser attaches each one to a node instead,
+// so that the formatter can print it where it was.
+
+// The comments attached to a node
+type Comments struct {
+	// At the end of the line before the node, after the code there: [ # comment
+	Previous []Token
+	// On their own lines before the node, and the ones inside it that no node inside took
+	Leading []Token
+	// At the end of the last line of the node
+	Trailing []Token
+}
+
+// Where the comments of a file are attached
+type CommentMap struct {
+	// By the start of the node, see Start. A call in a pipeline, .f(a), is found by the
+	// start of f, a match branch by the start of its first target, and a map entry by its key.
+	//
+	// These are the nodes that get comments: the expressions of the file and of blocks,
+	// elements of lists, entries of maps, arguments of calls, match branches, the calls of
+	// a pipeline and alias targets. Comments anywhere else go to the node around them.
+	Nodes map[Pos]Comments
+	// The comments after the last element of a list, map, block, match or the arguments of
+	// a call, before it is closed. By the position of the token of the node.
+	Closing map[Pos][]Token
+	// The comments after the last expression of the file
+	End []Token
+}
+
+func (p *parser) Comments() CommentMap {
+	comments := p.attached
+	comments.End = []Token{}
+	for i, c := range p.comments {
+		if !p.claimed[i] {
+			comments.End = append(comments.End, c)
+		}
+	}
+	return comments
+}
+
+// Attaches the comments from tokens[first] up to the current token to the node starting at key.
+// When trailing, the ones after the node on the line it ends are taken too, past a comma.
+func (p *parser) attach(key Pos, first int, trailing bool) {
+	c := Comments{}
+	for i := p.before[first]; i < p.before[p.index]; i++ {
+		if p.claimed[i] {
+			continue
+		}
+		p.claimed[i] = true
+		if first > 0 && i < p.before[first+1] && p.comments[i].Line() == p.tokens[first-1].Line() {
+			c.Previous = append(c.Previous, p.comments[i])
+		} else {
+			c.Leading = append(c.Leading, p.comments[i])
+		}
+	}
+	if trailing && p.index > 0 {
+		end := p.index + 1
+		if !p.isEOF() && p.peek().Kind == Comma {
+			end++
+		}
+		for i := p.before[p.index]; i < p.before[end]; i++ {
+			if !p.claimed[i] && p.comments[i].Line() == p.tokens[p.index-1].Line() {
+				p.claimed[i] = true
+				c.Trailing = append(c.Trailing, p.comments[i])
+			}
+		}
+	}
+	if len(c.Previous)+len(c.Leading)+len(c.Trailing) == 0 {
+		return
+	}
+	attached := p.attached.Nodes[key]
+	p.attached.Nodes[key] = Comments{
+		Previous: append(attached.Previous, c.Previous...),
+		Leading:  append(attached.Leading, c.Leading...),
+		Trailing: append(attached.Trailing, c.Trailing...),
+	}
+}
+
+// Attaches the comments left in the node opened by tokens[open] to it,
+// when the current token is the one closing it
+func (p *parser) attachClosing(key Pos, open int) {
+	for i := p.before[open+1]; i < p.before[p.index+1]; i++ {
+		if !p.claimed[i] {
+			p.claimed[i] = true
+			p.attached.Closing[key] = append(p.attached.Closing[key], p.comments[i])
+		}
+	}
+}
@@ -5,18 +5,42 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 )
 
 type parser struct {
 	tokens []Token
 	index  int
+	// Set while parsing the body of a match branch, where a
+	// minus starting a line begins the next branch, like -1 -> ...
+	branchBody bool
+
+	// Comments are kept apart from the code, to attach them to the nodes around them.
+	// The ones between tokens[i-1] and tokens[i] are comments[before[i]:before[i+1]].
+	comments []Token
+	before   []int
+	claimed  []bool
+	attached CommentMap
 }
 
 func NewParser(tokens []Token) parser {
-	return parser{
-		tokens: tokens,
-		index:  0,
+	p := parser{
+		tokens:   make([]Token, 0, len(tokens)),
+		index:    0,
+		before:   []int{0},
+		attached: CommentMap{Nodes: map[Pos]Comments{}, Closing: map[Pos][]Token{}},
+	}
+	for _, tok := range tokens {
+		if tok.Kind == Comment {
+			p.comments = append(p.comments, tok)
+			continue
+		}
+		p.before = append(p.before, len(p.comments))
+		p.tokens = append(p.tokens, tok)
 	}
+	p.before = append(p.before, len(p.comments))
+	p.claimed = make([]bool, len(p.comments))
+	return p
 }
 
 type parseError struct {
@@ -32,18 +56,28 @@ func (p *parser) isEOF() bool {
 	return p.index == len(p.tokens)
 }
 
+// Reading past the last token panics with this, and Parse turns it into a parseError.
+// Unfinished programs are common in an editor.
+type unexpectedEOF struct{}
+
 func (p *parser) peek() Token {
+	if p.isEOF() {
+		panic(unexpectedEOF{})
+	}
 	return p.tokens[p.index]
 }
 
 func (p *parser) peekAhead(n int) Token {
-	if p.index+n > len(p.tokens) {
+	if p.index+n >= len(p.tokens) {
 		return Token{Kind: IndentEndStatment}
 	}
 	return p.tokens[p.index+n]
 }
 
 func (p *parser) next() Token {
+	if p.isEOF() {
+		panic(unexpectedEOF{})
+	}
 	tok := p.tokens[p.index]
 	if p.index < len(p.tokens) {
 		p.index++
@@ -59,6 +93,7 @@ func (p *parser) back() {
 
 // When we find '(', it might be the start of a function:
 // (a) => {}
+// (a) -> Int => {}
 // or it might be
 // (1 + 2)
 // just grouping an expression.
@@ -67,16 +102,36 @@ func (p *parser) back() {
 // are in a function or just '(1 + 2)'
 func (p *parser) isStartOfFunction() bool {
 	i := 0
+	// Type parameters are wrapped in parens: (l:List(Int)) => ...
+	depth := 0
 	for {
-		if p.index+i > len(p.tokens) {
+		if p.index+i >= len(p.tokens) {
 			return false
 		}
 
 		tok := p.tokens[p.index+i]
 		switch tok.Kind {
 		case RightParen:
+			if depth > 0 {
+				depth--
+				i++
+				continue
+			}
+			if p.index+i+1 >= len(p.tokens) {
+				return false
+			}
 			next := p.tokens[p.index+i+1]
+			if next.Kind == BranchArrow {
+				return p.isReturnType(i + 2)
+			}
 			return next.Kind == FnArrow
+		case LeftParen:
+			if i == 0 || p.tokens[p.index+i-1].Kind != Identifier {
+				return false
+			}
+			depth++
+			i++
+			continue
 		case Identifier, Comma, Colon:
 			i++
 			continue
@@ -86,13 +141,19 @@ func (p *parser) isStartOfFunction() bool {
 	}
 }
 
-func (p *parser) readUntilTokenKind(kind TokKind) []Token {
-	tokens := []Token{}
-	for !p.isEOF() && p.peek().Kind != kind {
-		t := p.next()
-		tokens = append(tokens, t)
+// Looks ahead from the token start after the current one, to see if it is a return type followed by =>
+func (p *parser) isReturnType(start int) bool {
+	for i := start; p.index+i < len(p.tokens); i++ {
+		switch p.tokens[p.index+i].Kind {
+		case FnArrow:
+			return i > start
+		case Identifier, LeftParen, RightParen, Comma:
+			continue
+		default:
+			return false
+		}
 	}
-	return tokens
+	return false
 }
 
 func (p *parser) expect(kind TokKind) (Token, error) {
@@ -134,23 +195,44 @@ func (p *parser) parseAssignment(left AstNode) (AstNode, error) {
 	return node, nil
 }
 
-// Does NOT recursively parse the right tree.
+// How tightly a binary operator binds, 0 if kind is not one.
+// Arithmetic and comparisons share a level and are read from left to right,
+// so that 1 + 2 * 3 is (1 + 2) * 3, while a > 1 && b > 1 || c is ((a > 1) && (b > 1)) || c.
+func Precedence(kind TokKind) int {
+	switch kind {
+	case Or:
+		return 1
+	case And:
+		return 2
+	case Plus, Minus, Times, Divide, PlusOther, Eq, Neq, Greater, Less, Modulus, Geq, Leq:
+		return 3
+	case Dot:
+		// dot has the 'ultimate' precedence...
+		return 4
+	}
+	return 0
+}
+
+// Parses the right side of the operator, and only takes the operators after it
+// that bind tighter. parseNode takes the rest, from left to right.
 func (p *parser) parseBinaryOP(left AstNode) (AstNode, error) {
-	// NOTE: maybe add a double check for op actually being a BinaryToken
 	op := p.next()
 	node := BinaryNode{
 		Left: left,
 		Op:   op.Kind,
 		Tok:  &op,
 	}
-	right, err := p.parseUnit()
+	right, err := p.parseSubNode()
 	if err != nil {
 		return nil, err
 	}
 
-	// dot has the 'ultimate' precedence...
-	if !p.isEOF() && p.peek().Kind == Dot {
-		right, err = p.parseBinaryDot(right)
+	for !p.isEOF() && Precedence(p.peek().Kind) > Precedence(op.Kind) {
+		if p.peek().Kind == Dot {
+			right, err = p.parseBinaryDot(right)
+		} else {
+			right, err = p.parseBinaryOP(right)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -160,12 +242,41 @@ func (p *parser) parseBinaryOP(left AstNode) (AstNode, error) {
 	return node, nil
 }
 
+// Parses !a or -a. The operand is a unit with its calls, so that -a.abs() is -(a.abs())
+// and -a * b is (-a) * b. A minus before a number is part of the number: -1
+func (p *parser) parseUnary(op Token) (AstNode, error) {
+	if op.Kind == Minus && !p.isEOF() && p.peek().Kind == NumberLiteral && p.peekAhead(1).Kind != Dot {
+		num := p.next()
+		num.Payload = "-" + num.Payload
+		num.Pos = op.Pos
+		return p.parseNumberLiteral(num)
+	}
+	operand, err := p.parseSubNode()
+	if err != nil {
+		return nil, err
+	}
+	for !p.isEOF() && p.peek().Kind == Dot {
+		operand, err = p.parseBinaryDot(operand)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return UnaryNode{
+		Op:      op.Kind,
+		Operand: operand,
+		Tok:     &op,
+	}, nil
+}
+
 // Syntactic sugar for piping functions together
 //
 // res = one.add(1)
 // turns into
 // res = add(one, 1)
+//
+// Without the call, math.pi is a binding of a module.
 func (p *parser) parseBinaryDot(left AstNode) (AstNode, error) {
+	first := p.index
 	next := p.next() // eat the dot
 
 	subNode, err := p.parseSubNode()
@@ -181,8 +292,19 @@ func (p *parser) parseBinaryDot(left AstNode) (AstNode, error) {
 			args = append(args, callNode.Args...)
 		}
 		callNode.Args = args
+		callNode.Dot = true
+		// A comment after the last call of a pipeline is left to the node around it
+		p.attach(Start(callNode.Fn), first, !p.isEOF() && p.peek().Kind == Dot)
 		return callNode, nil
 
+	case IdentifierNode:
+		// Not called, so it can only be a binding of a module: math.pi
+		return MemberNode{
+			Module: left,
+			Name:   callNode.Payload,
+			Tok:    callNode.Tok,
+		}, nil
+
 	default:
 		return nil, parseError{
 			reason: fmt.Sprintf("Expected a callNode, got: %s", callNode),
@@ -260,36 +382,77 @@ func SplitTokensBy(tokens []Token, kind TokKind) [][]Token {
 	return newtokens
 }
 
-func (p *parser) parseFunction(tok Token) (AstNode, error) {
-	tokens := p.readUntilTokenKind(RightParen)
-	p.next() // eat right paren
-	if p.peek().Kind != FnArrow {
-		return nil, parseError{
-			reason: fmt.Sprintf("Expected =>, got %s", p.peek()),
-			Pos:    tok.Pos,
+// Parses a type given to a parameter, with its optional type parameters:
+// Int, List(Int) or Map(Str, List(a))
+func (p *parser) parseType() (Type, error) {
+	name, err := p.expect(Identifier)
+	if err != nil {
+		return Type{}, err
+	}
+	t := Type{Name: name.Payload}
+	if p.isEOF() || p.peek().Kind != LeftParen {
+		return t, nil
+	}
+	p.next() // eat left paren
+	for {
+		param, err := p.parseType()
+		if err != nil {
+			return Type{}, err
+		}
+		t.Params = append(t.Params, param)
+		if p.isEOF() || p.peek().Kind != Comma {
+			break
 		}
+		p.next() // eat comma
 	}
-	p.next() // eat arrow
+	if _, err := p.expect(RightParen); err != nil {
+		return Type{}, err
+	}
+	return t, nil
+}
 
+func (p *parser) parseFunction(tok Token) (AstNode, error) {
 	args := []Arg{}
-	groupedTokens := SplitTokensBy(tokens, Comma)
-	for _, paramToken := range groupedTokens {
-		if len(paramToken) == 2 { // makes no sense..
-			return nil, parseError{
-				reason: "Check your parameters..",
-				Pos:    tok.Pos,
+	for !p.isEOF() && p.peek().Kind != RightParen {
+		name, err := p.expect(Identifier)
+		if err != nil {
+			return nil, err
+		}
+		arg := Arg{Name: name.Payload}
+		if !p.isEOF() && p.peek().Kind == Colon {
+			p.next() // eat colon
+			t, err := p.parseType()
+			if err != nil {
+				return nil, err
 			}
+			arg.Alias = t.Name
+			arg.Params = t.Params
 		}
-		if len(paramToken) == 1 { // No type given
-			args = append(args, Arg{Name: paramToken[0].Payload})
-			continue
+		args = append(args, arg)
+		if p.isEOF() || p.peek().Kind != Comma {
+			break
+		}
+		p.next() // eat comma
+	}
+	if _, err := p.expect(RightParen); err != nil {
+		return nil, err
+	}
+	var returnType *Type
+	if !p.isEOF() && p.peek().Kind == BranchArrow {
+		p.next() // eat arrow
+		t, err := p.parseType()
+		if err != nil {
+			return nil, err
+		}
+		returnType = &t
+	}
+	if p.peek().Kind != FnArrow {
+		return nil, parseError{
+			reason: fmt.Sprintf("Expected =>, got %s", p.peek()),
+			Pos:    tok.Pos,
 		}
-		// type/alias given
-		args = append(args, Arg{
-			Name:  paramToken[0].Payload,
-			Alias: paramToken[2].Payload,
-		})
 	}
+	p.next() // eat arrow
 
 	body, err := p.parseNode()
 	if err != nil {
@@ -297,12 +460,51 @@ func (p *parser) parseFunction(tok Token) (AstNode, error) {
 	}
 
 	return FnNode{
-		Args: args,
-		Body: body,
-		Tok:  &tok,
+		Args:   args,
+		Return: returnType,
+		Body:   body,
+		Tok:    &tok,
 	}, nil
 }
 
+// Parses the rest of a map literal, after its first key:
+// {"a": 1, "b": 2}
+func (p *parser) parseMap(tok Token, open int, firstKey AstNode) (AstNode, error) {
+	node := MapNode{Tok: &tok}
+	key := firstKey
+	first := open + 1
+	for {
+		if _, err := p.expect(Colon); err != nil {
+			return nil, err
+		}
+		value, err := p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+		node.Keys = append(node.Keys, key)
+		node.Values = append(node.Values, value)
+		p.attach(Start(key), first, true)
+		if p.isEOF() || p.peek().Kind != Comma {
+			break
+		}
+		p.next() // eat comma
+		// Allow a trailing comma
+		if !p.isEOF() && p.peek().Kind == RightBrace {
+			break
+		}
+		first = p.index
+		key, err = p.parseNode()
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.attachClosing(tok.Pos, open)
+	if _, err := p.expect(RightBrace); err != nil {
+		return nil, err
+	}
+	return node, nil
+}
+
 func (p *parser) parseUnit() (AstNode, error) {
 	tok := p.next()
 	switch tok.Kind {
@@ -345,6 +547,27 @@ func (p *parser) parseUnit() (AstNode, error) {
 					} else {
 						_ = payloadBuilder.WriteByte('x')
 					}
+				case 'u':
+					// \u{1F600}: a unicode character, by its code point in hex
+					end := i + 1
+					for end < len(runes) && runes[end] != '}' {
+						end++
+					}
+					if i+1 >= len(runes) || runes[i+1] != '{' || end >= len(runes) {
+						return nil, parseError{
+							reason: "Expected a unicode escape like \\u{1F600}",
+							Pos:    tok.Pos,
+						}
+					}
+					code, err := strconv.ParseUint(string(runes[i+2:end]), 16, 32)
+					if err != nil || !utf8.ValidRune(rune(code)) {
+						return nil, parseError{
+							reason: fmt.Sprintf("Invalid unicode escape \\u{%s}", string(runes[i+2:end])),
+							Pos:    tok.Pos,
+						}
+					}
+					i = end
+					_, _ = payloadBuilder.WriteRune(rune(code))
 				default:
 					_, _ = payloadBuilder.WriteRune(c)
 				}
@@ -356,6 +579,8 @@ func (p *parser) parseUnit() (AstNode, error) {
 		// return stringNode{payload: tok.payload, tok: &tok}, nil
 	case FalseLiteral:
 		return BoolNode{Payload: false, Tok: &tok}, nil
+	case Minus, Not:
+		return p.parseUnary(tok)
 	case Underscore:
 		return UnderscoreNode{tok: &tok}, nil
 	case Identifier:
@@ -382,30 +607,74 @@ func (p *parser) parseUnit() (AstNode, error) {
 		if err != nil {
 			return nil, err
 		}
+		params := []string{}
+		if !p.isEOF() && p.peek().Kind == LeftParen {
+			p.next() // eat left paren
+			for {
+				param, err := p.expect(Identifier)
+				if err != nil {
+					return nil, err
+				}
+				params = append(params, param.Payload)
+				if p.isEOF() || p.peek().Kind != Comma {
+					break
+				}
+				p.next() // eat comma
+			}
+			if _, err := p.expect(RightParen); err != nil {
+				return nil, err
+			}
+		}
 		_, err = p.expect(Assign)
 		if err != nil {
 			return nil, err
 		}
-		body, err := p.parseUnit()
+		// parseSubNode, as a target might be an alias given type parameters: List(Int)
+		first := p.index
+		body, err := p.parseSubNode()
 		if err != nil {
 			return nil, err
 		}
+		p.attach(Start(body), first, !p.isEOF() && p.peek().Kind == Pipe)
 		// ...
 		targets := []AstNode{body}
-		for !p.isEOF() && p.peek().Kind == Or {
-			_ = p.next() // eat or
-			b, err := p.parseUnit()
+		for !p.isEOF() && p.peek().Kind == Pipe {
+			first := p.index
+			_ = p.next() // eat pipe
+			b, err := p.parseSubNode()
 			if err != nil {
 				return nil, err
 			}
+			p.attach(Start(b), first, !p.isEOF() && p.peek().Kind == Pipe)
 			targets = append(targets, b)
 		}
 		return AliasNode{
 			Name:    name.Payload,
+			Params:  params,
 			Targets: targets,
 			Tok:     &tok,
 		}, nil
 
+	case ImportKeyword:
+		if p.isEOF() {
+			return nil, parseError{
+				reason: "Unexpected end of input, expected a path or a name to import",
+				Pos:    tok.Pos,
+			}
+		}
+		target := p.next()
+		switch target.Kind {
+		case StringLiteral:
+			return ImportNode{Path: target.Payload, Tok: &tok}, nil
+		case Identifier:
+			return ImportNode{Name: target.Payload, Tok: &tok}, nil
+		default:
+			return nil, parseError{
+				reason: fmt.Sprintf("Unexpected token %s, expected a path or a name to import", target),
+				Pos:    target.Pos,
+			}
+		}
+
 	case MatchKeyword:
 		var cond AstNode
 		branches := []MatchBranch{}
@@ -429,8 +698,10 @@ func (p *parser) parseUnit() (AstNode, error) {
 		if err != nil {
 			return nil, err
 		}
+		open := p.index - 1
 
 		for !p.isEOF() && p.peek().Kind != RightBrace {
+			first := p.index
 			targets := []AstNode{}
 			for !p.isEOF() && p.peek().Kind != BranchArrow {
 				// You can separatte multiple targets "within" a branch.
@@ -440,17 +711,28 @@ func (p *parser) parseUnit() (AstNode, error) {
 					return nil, err
 				}
 				targets = append(targets, target)
-				if p.peek().Kind == Comma {
+				if !p.isEOF() && p.peek().Kind == Comma {
 					p.next()
 				} else {
 					break
 				}
 			}
 
+			// A guard applies to every target of the branch
+			var guard AstNode
+			if !p.isEOF() && p.peek().Kind == IfKeyword {
+				p.next() // eat if
+				guard, err = p.parseNode()
+				if err != nil {
+					return nil, err
+				}
+			}
+
 			if _, err := p.expect(BranchArrow); err != nil {
 				return nil, err
 			}
 
+			p.branchBody = true
 			body, err := p.parseNode()
 			if err != nil {
 				return nil, err
@@ -459,11 +741,16 @@ func (p *parser) parseUnit() (AstNode, error) {
 			for _, target := range targets {
 				branches = append(branches, MatchBranch{
 					Target: target,
+					Guard:  guard,
 					Body:   body,
 				})
 			}
+			if len(targets) > 0 {
+				p.attach(Start(targets[0]), first, true)
+			}
 		}
 
+		p.attachClosing(tok.Pos, open)
 		if _, err := p.expect(RightBrace); err != nil {
 			return nil, err
 		}
@@ -475,31 +762,67 @@ func (p *parser) parseUnit() (AstNode, error) {
 		}, nil
 
 	case LeftBrace:
+		// {} is an empty map, not an empty block
+		if !p.isEOF() && p.peek().Kind == RightBrace {
+			p.next() // eat rightBrace
+			return MapNode{Tok: &tok}, nil
+		}
+		open := p.index - 1
 		firstExpr, err := p.parseNode()
 		if err != nil {
 			return nil, err
 		}
+		if !p.isEOF() && p.peek().Kind == Colon {
+			return p.parseMap(tok, open, firstExpr)
+		}
+		p.attach(Start(firstExpr), open+1, true)
 		nodes := []AstNode{firstExpr}
 		for !p.isEOF() && p.peek().Kind != RightBrace {
+			first := p.index
 			node, err := p.parseNode()
 			if err != nil {
 				return nil, err
 			}
+			p.attach(Start(node), first, true)
 			nodes = append(nodes, node)
 		}
+		p.attachClosing(tok.Pos, open)
 		p.next() // eat rightBrace
 		return BlockNode{
 			Exprs: nodes,
 			Tok:   &tok,
 		}, nil
 
+	case DotDot:
+		if p.isEOF() {
+			return nil, parseError{
+				reason: "Unexpected end of input, expected a name after ..",
+				Pos:    tok.Pos,
+			}
+		}
+		name := p.next()
+		switch name.Kind {
+		case Identifier:
+			return RestNode{Name: name.Payload, Tok: &tok}, nil
+		case Underscore:
+			return RestNode{Tok: &tok}, nil
+		default:
+			return nil, parseError{
+				reason: fmt.Sprintf("Unexpected token %s, expected a name after ..", name),
+				Pos:    name.Pos,
+			}
+		}
+
 	case LeftBracket:
+		open := p.index - 1
 		nodes := []AstNode{}
 		for !p.isEOF() && p.peek().Kind != RightBracket {
+			first := p.index
 			node, err := p.parseNode()
 			if err != nil {
 				return nil, err
 			}
+			p.attach(Start(node), first, true)
 			nodes = append(nodes, node)
 			if p.peek().Kind == Comma {
 				p.next()
@@ -507,6 +830,7 @@ func (p *parser) parseUnit() (AstNode, error) {
 				break
 			}
 		}
+		p.attachClosing(tok.Pos, open)
 		p.next() // eat rightBracket
 		return ListNode{
 			Elems: nodes,
@@ -533,12 +857,15 @@ func (p *parser) parseSubNode() (AstNode, error) {
 		switch p.peek().Kind {
 		case LeftParen: // Function call
 			next := p.next() // eat the leftParen
+			open := p.index - 1
 			args := []AstNode{}
 			for !p.isEOF() && p.peek().Kind != RightParen {
+				first := p.index
 				arg, err := p.parseNode()
 				if err != nil {
 					return nil, err
 				}
+				p.attach(Start(arg), first, true)
 				args = append(args, arg)
 				if p.peek().Kind == Comma {
 					p.next()
@@ -546,6 +873,7 @@ func (p *parser) parseSubNode() (AstNode, error) {
 					break
 				}
 			}
+			p.attachClosing(next.Pos, open)
 			if _, err := p.expect(RightParen); err != nil {
 				return nil, err
 			}
@@ -565,6 +893,9 @@ func (p *parser) parseSubNode() (AstNode, error) {
 
 // parseNode returns the next top-level astNode from the parser
 func (p *parser) parseNode() (AstNode, error) {
+	// Only the outermost expression of a branch body ends at such a minus
+	branchBody := p.branchBody
+	p.branchBody = false
 	node, err := p.parseSubNode()
 	if err != nil {
 		return nil, err
@@ -574,9 +905,11 @@ func (p *parser) parseNode() (AstNode, error) {
 		switch p.peek().Kind {
 		case Assign:
 			return p.parseAssignment(node)
-		case Plus, Minus, Times, Divide, PlusOther, Eq, Neq, Greater, Less, Modulus, Geq, Leq:
-			// TODO: add: and, or:
-			//
+		case Plus, Minus, Times, Divide, PlusOther, Eq, Neq, Greater, Less, Modulus, Geq, Leq, And, Or:
+			// A minus starting a line after a branch body is the next branch
+			if branchBody && p.peek().Kind == Minus && p.peek().Line() > p.tokens[p.index-1].Line() {
+				return node, nil
+			}
 			// We keep looping here because we want to adhere to order of operations.
 			// Which means that there might be more binary operations coming, and we need to catch them here.
 			node, err = p.parseBinaryOP(node)
@@ -597,13 +930,30 @@ func (p *parser) parseNode() (AstNode, error) {
 	return node, nil
 }
 
-func (p *parser) Parse() ([]AstNode, error) {
-	nodes := []AstNode{}
+func (p *parser) Parse() (nodes []AstNode, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(unexpectedEOF); !ok {
+				panic(r)
+			}
+			pos := Pos{}
+			if len(p.tokens) > 0 {
+				pos = p.tokens[len(p.tokens)-1].Pos
+			}
+			err = parseError{
+				reason: "Unexpected end of file",
+				Pos:    pos,
+			}
+		}
+	}()
+	nodes = []AstNode{}
 	for !p.isEOF() {
+		first := p.index
 		node, err := p.parseNode()
 		if err != nil {
 			return nodes, err
 		}
+		p.attach(Start(node), first, true)
 		// _ = p.next()
 		// if _, err = p.expect(comma); err != nil {
 		// 	return nodes, err
@@ -22,6 +22,25 @@ type Pos struct {
 	col      int
 }
 
+func (p Pos) FileName() string {
+	return p.fileName
+}
+
+// Starts at 1
+func (p Pos) Line() int {
+	return p.line
+}
+
+// Starts at 1, counted in runes
+func (p Pos) Col() int {
+	return p.col
+}
+
+// Lets errors that embed a Pos tell where they happened
+func (p Pos) Position() Pos {
+	return p
+}
+
 func (p Pos) String() string {
 	return fmt.Sprintf("%s[%d:%d]", p.fileName, p.line, p.col)
 }
@@ -35,6 +54,7 @@ const (
 	EmptyToken // Used as: nothing here
 	Comma
 	Dot
+	DotDot
 	LeftParen
 	RightParen
 	LeftBracket
@@ -46,6 +66,8 @@ const (
 	BranchArrow
 	Colon
 	DoubleColon
+	Pipe // between the targets of an alias
+	Not
 
 	// binary operators
 	Plus
@@ -68,6 +90,8 @@ const (
 	// keywords
 	MatchKeyword
 	AliasKeyword
+	ImportKeyword
+	IfKeyword
 	SinglePipeArrow
 	DoublePipeArrow
 
@@ -92,6 +116,8 @@ func (t Token) String() string {
 		return fmt.Sprintf("#(%s)", t.Payload)
 	case Comma:
 		return ","
+	case DotDot:
+		return ".."
 	case Dot:
 		return "."
 	case LeftParen:
@@ -116,6 +142,10 @@ func (t Token) String() string {
 		return ":"
 	case DoubleColon:
 		return "::"
+	case Pipe:
+		return "|"
+	case Not:
+		return "!"
 	case Plus:
 		return "+"
 	case Modulus:
@@ -129,9 +159,9 @@ func (t Token) String() string {
 	case Divide:
 		return "/"
 	case And:
-		return "&"
+		return "&&"
 	case Or:
-		return "|"
+		return "||"
 	case Greater:
 		return ">"
 	case Less:
@@ -148,6 +178,10 @@ func (t Token) String() string {
 		return "match"
 	case AliasKeyword:
 		return "alias"
+	case ImportKeyword:
+		return "import"
+	case IfKeyword:
+		return "if"
 	case Underscore:
 		return "_"
 	case Identifier:
@@ -298,9 +332,29 @@ func (t *tokenizer) nextToken() Token {
 	case ',':
 		return Token{Kind: Comma, Pos: t.currentPos()}
 	case '.':
+		if !t.isEOF() && t.peek() == '.' {
+			t.next()
+			return Token{Kind: DotDot, Pos: t.currentPos()}
+		}
 		return Token{Kind: Dot, Pos: t.currentPos()}
 	case '|':
-		return Token{Kind: Or, Pos: t.currentPos()}
+		if !t.isEOF() && t.peek() == '|' {
+			t.next()
+			return Token{Kind: Or, Pos: t.currentPos()}
+		}
+		return Token{Kind: Pipe, Pos: t.currentPos()}
+	case '&':
+		if !t.isEOF() && t.peek() == '&' {
+			t.next()
+			return Token{Kind: And, Pos: t.currentPos()}
+		}
+		return Token{Kind: Unknown, Pos: t.currentPos()}
+	case '!':
+		if !t.isEOF() && t.peek() == '=' {
+			t.next()
+			return Token{Kind: Neq, Pos: t.currentPos()}
+		}
+		return Token{Kind: Not, Pos: t.currentPos()}
 	case '(':
 		return Token{Kind: LeftParen, Pos: t.currentPos()}
 	case ')':
@@ -373,12 +427,12 @@ func (t *tokenizer) nextToken() Token {
 		return Token{Kind: Divide, Pos: t.currentPos()}
 	case '#':
 		pos := t.currentPos()
-		t.next()
-		commentString := strings.TrimSpace(t.readUntilRune('\n'))
+		// Only the space after # is dropped, to keep indentation within comments
+		commentString := strings.TrimRight(t.readUntilRune('\n'), " \t\r")
 		return Token{
 			Kind:    Comment,
 			Pos:     pos,
-			Payload: commentString,
+			Payload: strings.TrimPrefix(commentString, " "),
 		}
 
 	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
@@ -395,12 +449,14 @@ func (t *tokenizer) nextToken() Token {
 		switch payload {
 		case "_":
 			return Token{Kind: Underscore, Pos: pos}
-		case "!=":
-			return Token{Kind: Neq, Pos: pos}
 		case "match":
 			return Token{Kind: MatchKeyword, Pos: pos}
 		case "alias":
 			return Token{Kind: AliasKeyword, Pos: pos}
+		case "import":
+			return Token{Kind: ImportKeyword, Pos: pos}
+		case "if":
+			return Token{Kind: IfKeyword, Pos: pos}
 		case "true":
 			return Token{Kind: TrueLiteral, Pos: pos}
 		case "false":
@@ -432,8 +488,8 @@ func (t *tokenizer) Tokenize() []Token {
 	for !t.isEOF() {
 		next := t.nextToken()
 
-		// Dont include comments (yet)
-		if !(next.Kind == EmptyToken || next.Kind == Comment) {
+		// Comments are kept for the formatter, but skipped by the parser
+		if next.Kind != EmptyToken {
 			tokens = append(tokens, next)
 		}
 
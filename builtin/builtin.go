@@ -0,0 +1,193 @@
+// Package builtin holds the builtins that the interpreter in eval and the runtime
+// of built programs share. Each has its own Value types, and gives the builtins
+// a Values to make and take them apart. Only the builtins that call functions or
+// change variables, like __try and update, and __index, which depends on how a Str
+// is stored, are their own.
+//
+// It only depends on the standard library, as codegen copies its source into
+// every program it builds.
+package builtin
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+)
+
+// A value of an interpreter
+type Value[V any] interface {
+	String() string
+	Eq(V) bool
+}
+
+// How the builtins make the values of an interpreter, and take them apart
+type Values[V Value[V]] interface {
+	Int(n int64) V
+	// Only given numbers that do not fit in an int64
+	BigInt(n *big.Int) V
+	Float(f float64) V
+	Bool(b bool) V
+	Str(s string) V
+	Char(r rune) V
+	List(elems []V) V
+	Enum(parent string, name string, args []V) V
+	Map(m *Map[V]) V
+
+	AsInt(v V) (int64, bool)
+	// Only the numbers that do not fit in an int64
+	AsBigInt(v V) (*big.Int, bool)
+	AsFloat(v V) (float64, bool)
+	AsBool(v V) (bool, bool)
+	AsStr(v V) (string, bool)
+	AsChar(v V) (rune, bool)
+	AsList(v V) ([]V, bool)
+	AsEnum(v V) (parent string, name string, args []V, ok bool)
+	AsMap(v V) (*Map[V], bool)
+	IsUnderscore(v V) bool
+}
+
+// What the IO builtins use
+type IO struct {
+	// Read line by line, and all at once, so the reads need to share the buffer
+	Stdin  *bufio.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// What __args gives, where the first argument is the program
+	Args []string
+}
+
+// How a program ends early, by exit or panic
+type Exit struct {
+	Code    int
+	Panic   bool   // panic, and not exit
+	Message string // what the program panicked with
+}
+
+func (e *Exit) Error() string {
+	if !e.Panic {
+		return fmt.Sprintf("Exited with code %d", e.Code)
+	}
+	return "Panic: " + e.Message
+}
+
+type Builtin[V any] struct {
+	Name string
+	// How many arguments it needs at least
+	Args int
+	fn   func(args []V) V
+}
+
+// A builtin failing, with an error for the interpreter to report.
+// The builtins panic with it, so that taking arguments apart stays short.
+type failure struct {
+	err error
+}
+
+func fail(format string, a ...any) {
+	panic(failure{err: fmt.Errorf(format, a...)})
+}
+
+// Calls the builtin. Returns an *Exit when it exits or panics, and an error when it fails
+func (b Builtin[V]) Call(args []V) (v V, err error) {
+	if len(args) < b.Args {
+		return v, fmt.Errorf("%s requires %d arguments, got %d", b.Name, b.Args, len(args))
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			f, ok := r.(failure)
+			if !ok {
+				panic(r)
+			}
+			err = f.err
+		}
+	}()
+	return b.fn(args), nil
+}
+
+type builtins[V Value[V]] struct {
+	vs Values[V]
+	io IO
+}
+
+// Every shared builtin, making values with vs
+func All[V Value[V]](vs Values[V], io IO) []Builtin[V] {
+	b := &builtins[V]{vs: vs, io: io}
+	all := []Builtin[V]{
+		{Name: "__print", Args: 1, fn: b.print},
+		{Name: "__eprint", Args: 1, fn: b.eprint},
+		{Name: "__string", Args: 1, fn: b.toStr},
+		{Name: "__int", Args: 1, fn: b.parseInt},
+		{Name: "__args", Args: 0, fn: b.programArgs},
+		{Name: "__exit", Args: 1, fn: b.exit},
+		{Name: "__fail", Args: 1, fn: b.failWith},
+		{Name: "__panic", Args: 1, fn: b.panicWith},
+		{Name: "__read_file", Args: 1, fn: b.readFile},
+		{Name: "__read_line", Args: 0, fn: b.readLine},
+		{Name: "__read_all_stdin", Args: 0, fn: b.readAllStdin},
+		{Name: "__length", Args: 1, fn: b.length},
+	}
+	all = append(all, b.fileBuiltins()...)
+	all = append(all, b.stringBuiltins()...)
+	all = append(all, b.mathBuiltins()...)
+	return append(all, b.mapBuiltins()...)
+}
+
+func toSome[V Value[V]](vs Values[V], v V) V {
+	return vs.Enum("Maybe", "Some", []V{v})
+}
+
+func toNone[V Value[V]](vs Values[V]) V {
+	return vs.Enum("Maybe", "None", []V{})
+}
+
+func toOk[V Value[V]](vs Values[V], v V) V {
+	return vs.Enum("Result", "Ok", []V{v})
+}
+
+func toErr[V Value[V]](vs Values[V], message string) V {
+	return vs.Enum("Result", "Err", []V{vs.Str(message)})
+}
+
+// The Int holding n, which is only a big one when it does not fit in an int64
+func (b *builtins[V]) bigInt(n *big.Int) V {
+	if n.IsInt64() {
+		return b.vs.Int(n.Int64())
+	}
+	return b.vs.BigInt(n)
+}
+
+// Int values, small or big, as a big.Int
+func (b *builtins[V]) toBig(v V) (*big.Int, bool) {
+	if n, ok := b.vs.AsInt(v); ok {
+		return big.NewInt(n), true
+	}
+	return b.vs.AsBigInt(v)
+}
+
+func (b *builtins[V]) strArg(name string, args []V, i int) string {
+	s, ok := b.vs.AsStr(args[i])
+	if !ok {
+		fail("Unexpected argument to %s: %s. Expected a string.", name, args[i])
+	}
+	return s
+}
+
+func (b *builtins[V]) intArg(name string, args []V, i int) int {
+	n, ok := b.vs.AsInt(args[i])
+	if _, isBig := b.vs.AsBigInt(args[i]); isBig {
+		fail("Unexpected argument to %s: %s is too large.", name, args[i])
+	}
+	if !ok {
+		fail("Unexpected argument to %s: %s. Expected an int.", name, args[i])
+	}
+	return int(n)
+}
+
+func (b *builtins[V]) strList(strs []string) V {
+	list := make([]V, len(strs))
+	for i, s := range strs {
+		list[i] = b.vs.Str(s)
+	}
+	return b.vs.List(list)
+}
@@ -0,0 +1,241 @@
+package builtin
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+func (b *builtins[V]) print(args []V) V {
+	s, ok := b.vs.AsStr(args[0])
+	if !ok {
+		fail("Unexpected argument to print: %s", args[0])
+	}
+	n, _ := io.WriteString(b.io.Stdout, s)
+	return b.vs.Int(int64(n))
+}
+
+func (b *builtins[V]) eprint(args []V) V {
+	s, ok := b.vs.AsStr(args[0])
+	if !ok {
+		fail("Unexpected argument to eprint: %s", args[0])
+	}
+	n, _ := io.WriteString(b.io.Stderr, s)
+	return b.vs.Int(int64(n))
+}
+
+func (b *builtins[V]) toStr(args []V) V {
+	if _, ok := b.vs.AsStr(args[0]); ok {
+		return args[0]
+	}
+	return b.vs.Str(args[0].String())
+}
+
+func (b *builtins[V]) parseInt(args []V) V {
+	s, ok := b.vs.AsStr(args[0])
+	if !ok {
+		return toErr(b.vs, fmt.Sprintf("Cannot cast %s to int", args[0]))
+	}
+	i, err := strconv.Atoi(s)
+	if errors.Is(err, strconv.ErrRange) {
+		if n, ok := new(big.Int).SetString(s, 10); ok {
+			return toOk(b.vs, b.bigInt(n))
+		}
+	}
+	if err != nil {
+		return toErr(b.vs, err.Error())
+	}
+	return toOk(b.vs, b.vs.Int(int64(i)))
+}
+
+func (b *builtins[V]) length(args []V) V {
+	if s, ok := b.vs.AsStr(args[0]); ok {
+		return b.vs.Int(int64(utf8.RuneCountInString(s)))
+	}
+	if list, ok := b.vs.AsList(args[0]); ok {
+		return b.vs.Int(int64(len(list)))
+	}
+	if m, ok := b.vs.AsMap(args[0]); ok {
+		return b.vs.Int(int64(m.Len()))
+	}
+	return toErr(b.vs, fmt.Sprintf("Cannot get length of %s", args[0]))
+}
+
+func (b *builtins[V]) programArgs(_ []V) V {
+	return b.strList(b.io.Args)
+}
+
+func (b *builtins[V]) exit(args []V) V {
+	code, ok := b.vs.AsInt(args[0])
+	if !ok {
+		fail("Mismatched types in call exit(%s)", args[0])
+	}
+	panic(failure{err: &Exit{Code: int(code)}})
+}
+
+// Fails with the given message, which raja test reports as a failed test
+func (b *builtins[V]) failWith(args []V) V {
+	message, ok := b.vs.AsStr(args[0])
+	if !ok {
+		fail("Mismatched types in call fail(%s)", args[0])
+	}
+	panic(failure{err: errors.New(message)})
+}
+
+func (b *builtins[V]) panicWith(args []V) V {
+	message, ok := b.vs.AsStr(args[0])
+	if !ok {
+		fail("Mismatched types in call panic(%s)", args[0])
+	}
+	panic(failure{err: &Exit{Code: 1, Panic: true, Message: message}})
+}
+
+func (b *builtins[V]) readFile(args []V) V {
+	s, ok := b.vs.AsStr(args[0])
+	if !ok {
+		return toErr(b.vs, fmt.Sprintf("Unexpected argument to print: %s", args[0]))
+	}
+	bs, err := os.ReadFile(s)
+	if err != nil {
+		return toErr(b.vs, err.Error())
+	}
+	return toOk(b.vs, b.vs.Str(string(bs)))
+}
+
+// Reads the next line from stdin, without the line ending.
+// Returns Maybe::None when there is nothing left to read.
+func (b *builtins[V]) readLine(_ []V) V {
+	line, ok, err := ReadLine(b.io.Stdin)
+	if err != nil {
+		fail("%s", err)
+	}
+	if !ok {
+		return toNone(b.vs)
+	}
+	return toSome(b.vs, b.vs.Str(line))
+}
+
+// The next line of r, without its line ending. Not ok at the end of r
+func ReadLine(r *bufio.Reader) (string, bool, error) {
+	line, err := r.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, fmt.Errorf("Could not read from stdin: %s", err)
+	}
+	if err == io.EOF && line == "" {
+		return "", false, nil
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return line, true, nil
+}
+
+func (b *builtins[V]) readAllStdin(_ []V) V {
+	bs, err := io.ReadAll(b.io.Stdin)
+	if err != nil {
+		return toErr(b.vs, err.Error())
+	}
+	return toOk(b.vs, b.vs.Str(string(bs)))
+}
+
+// The builtins behind the fs stdlib
+
+func (b *builtins[V]) fileBuiltins() []Builtin[V] {
+	return []Builtin[V]{
+		{Name: "__write_file", Args: 2, fn: b.writeFile("__write_file", os.O_WRONLY|os.O_CREATE|os.O_TRUNC)},
+		{Name: "__append_file", Args: 2, fn: b.writeFile("__append_file", os.O_WRONLY|os.O_CREATE|os.O_APPEND)},
+		{Name: "__list_dir", Args: 1, fn: b.listDir},
+		{Name: "__exists", Args: 1, fn: b.exists},
+		{Name: "__remove", Args: 1, fn: b.remove},
+		{Name: "__mkdir_all", Args: 1, fn: b.mkdirAll},
+		{Name: "__file_info", Args: 1, fn: b.fileInfo},
+	}
+}
+
+// The path argument of the file system builtins
+func (b *builtins[V]) pathArg(name string, args []V) string {
+	path, ok := b.vs.AsStr(args[0])
+	if !ok {
+		fail("Mismatched types in call %s(%s)", name, args[0])
+	}
+	return path
+}
+
+// Writes content to the file with the given flags, for write_file and append_file
+func (b *builtins[V]) writeFile(name string, flag int) func([]V) V {
+	return func(args []V) V {
+		path := b.pathArg(name, args)
+		content, ok := b.vs.AsStr(args[1])
+		if !ok {
+			fail("Mismatched types in call %s(%s, %s)", name, args[0], args[1])
+		}
+		file, err := os.OpenFile(path, flag, 0o644)
+		if err != nil {
+			return toErr(b.vs, err.Error())
+		}
+		defer file.Close()
+		if _, err := file.WriteString(content); err != nil {
+			return toErr(b.vs, err.Error())
+		}
+		return toOk(b.vs, b.vs.Str(path))
+	}
+}
+
+// The names in the directory, sorted
+func (b *builtins[V]) listDir(args []V) V {
+	entries, err := os.ReadDir(b.pathArg("__list_dir", args))
+	if err != nil {
+		return toErr(b.vs, err.Error())
+	}
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return toOk(b.vs, b.strList(names))
+}
+
+func (b *builtins[V]) exists(args []V) V {
+	_, err := os.Stat(b.pathArg("__exists", args))
+	if errors.Is(err, fs.ErrNotExist) {
+		return toOk(b.vs, b.vs.Bool(false))
+	}
+	if err != nil {
+		return toErr(b.vs, err.Error())
+	}
+	return toOk(b.vs, b.vs.Bool(true))
+}
+
+func (b *builtins[V]) remove(args []V) V {
+	path := b.pathArg("__remove", args)
+	if err := os.Remove(path); err != nil {
+		return toErr(b.vs, err.Error())
+	}
+	return toOk(b.vs, b.vs.Str(path))
+}
+
+func (b *builtins[V]) mkdirAll(args []V) V {
+	path := b.pathArg("__mkdir_all", args)
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return toErr(b.vs, err.Error())
+	}
+	return toOk(b.vs, b.vs.Str(path))
+}
+
+// A map with size in bytes, modtime in seconds since the Unix epoch, and is_dir
+func (b *builtins[V]) fileInfo(args []V) V {
+	info, err := os.Stat(b.pathArg("__file_info", args))
+	if err != nil {
+		return toErr(b.vs, err.Error())
+	}
+	m := NewMap(b.vs)
+	m.Set(b.vs.Str("size"), b.vs.Int(info.Size()))
+	m.Set(b.vs.Str("modtime"), b.vs.Int(info.ModTime().Unix()))
+	m.Set(b.vs.Str("is_dir"), b.vs.Bool(info.IsDir()))
+	return toOk(b.vs, b.vs.Map(m))
+}
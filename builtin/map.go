@@ -0,0 +1,240 @@
+package builtin
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"sort"
+	"strings"
+)
+
+// Keys are compared by value, and entries are kept in insertion order.
+// A Map is never changed after it is created; Insert and Remove return a new map.
+type Map[V Value[V]] struct {
+	vs      Values[V]
+	keys    []string // in insertion order
+	entries map[string]mapEntry[V]
+}
+
+type mapEntry[V any] struct {
+	key   V
+	value V
+}
+
+func NewMap[V Value[V]](vs Values[V]) *Map[V] {
+	return &Map[V]{
+		vs:      vs,
+		keys:    []string{},
+		entries: map[string]mapEntry[V]{},
+	}
+}
+
+// Values that are Eq gives the same hash key.
+// The key follows the structure of the value, and strings in it are prefixed with
+// their length, so that ["a, b"] and ["a", "b"] get different keys.
+func (m *Map[V]) mapKey(v V) string {
+	var b strings.Builder
+	m.writeMapKey(&b, v)
+	return b.String()
+}
+
+func (m *Map[V]) writeMapKey(b *strings.Builder, v V) {
+	vs := m.vs
+	if n, ok := vs.AsInt(v); ok {
+		fmt.Fprintf(b, "i%d;", n)
+	} else if n, ok := vs.AsBigInt(v); ok {
+		fmt.Fprintf(b, "i%s;", n)
+	} else if f, ok := vs.AsFloat(v); ok {
+		// Floats that are Eq to an Int get the key of the Int
+		if f == float64(int64(f)) {
+			fmt.Fprintf(b, "i%d;", int64(f))
+		} else if f == math.Trunc(f) && !math.IsInf(f, 0) {
+			n, _ := big.NewFloat(f).Int(nil)
+			fmt.Fprintf(b, "i%s;", n)
+		} else {
+			fmt.Fprintf(b, "f%s;", v)
+		}
+	} else if s, ok := vs.AsStr(v); ok {
+		fmt.Fprintf(b, "s%d:%s", len(s), s)
+	} else if r, ok := vs.AsChar(v); ok {
+		fmt.Fprintf(b, "c%d;", r)
+	} else if t, ok := vs.AsBool(v); ok {
+		fmt.Fprintf(b, "b%t;", t)
+	} else if list, ok := vs.AsList(v); ok {
+		fmt.Fprintf(b, "l%d[", len(list))
+		for _, elem := range list {
+			m.writeMapKey(b, elem)
+		}
+		b.WriteString("]")
+	} else if parent, name, args, ok := vs.AsEnum(v); ok {
+		fmt.Fprintf(b, "e%d:%s%d:%s%d(", len(parent), parent, len(name), name, len(args))
+		for _, arg := range args {
+			m.writeMapKey(b, arg)
+		}
+		b.WriteString(")")
+	} else if k, ok := vs.AsMap(v); ok {
+		// Maps are Eq regardless of the order of their entries
+		keys := append([]string{}, k.keys...)
+		sort.Strings(keys)
+		fmt.Fprintf(b, "m%d{", len(keys))
+		for _, key := range keys {
+			fmt.Fprintf(b, "%d:%s", len(key), key)
+			m.writeMapKey(b, k.entries[key].value)
+		}
+		b.WriteString("}")
+	} else {
+		s := fmt.Sprintf("%T:%s", v, v)
+		fmt.Fprintf(b, "%d:%s", len(s), s)
+	}
+}
+
+func (m *Map[V]) Get(key V) (V, bool) {
+	entry, ok := m.entries[m.mapKey(key)]
+	return entry.value, ok
+}
+
+func (m *Map[V]) Len() int {
+	return len(m.keys)
+}
+
+func (m *Map[V]) copy() *Map[V] {
+	n := &Map[V]{
+		vs:      m.vs,
+		keys:    make([]string, len(m.keys)),
+		entries: make(map[string]mapEntry[V], len(m.entries)),
+	}
+	copy(n.keys, m.keys)
+	for k, entry := range m.entries {
+		n.entries[k] = entry
+	}
+	return n
+}
+
+// Only used while creating a map
+func (m *Map[V]) Set(key V, value V) {
+	k := m.mapKey(key)
+	if _, exist := m.entries[k]; !exist {
+		m.keys = append(m.keys, k)
+	}
+	m.entries[k] = mapEntry[V]{key: key, value: value}
+}
+
+func (m *Map[V]) Insert(key V, value V) *Map[V] {
+	n := m.copy()
+	n.Set(key, value)
+	return n
+}
+
+func (m *Map[V]) Remove(key V) *Map[V] {
+	k := m.mapKey(key)
+	if _, exist := m.entries[k]; !exist {
+		return m
+	}
+	n := m.copy()
+	delete(n.entries, k)
+	n.keys = n.keys[:0]
+	for _, key := range m.keys {
+		if key != k {
+			n.keys = append(n.keys, key)
+		}
+	}
+	return n
+}
+
+// The entries of the map, in the order they were inserted
+func (m *Map[V]) Entries() (keys []V, values []V) {
+	keys, values = make([]V, len(m.keys)), make([]V, len(m.keys))
+	for i, k := range m.keys {
+		keys[i], values[i] = m.entries[k].key, m.entries[k].value
+	}
+	return keys, values
+}
+
+func (m *Map[V]) String() string {
+	stringValues := make([]string, len(m.keys))
+	for i, k := range m.keys {
+		entry := m.entries[k]
+		stringValues[i] = entry.key.String() + ": " + entry.value.String()
+	}
+	return fmt.Sprintf("{%s}", strings.Join(stringValues, ", "))
+}
+
+func (m *Map[V]) Eq(u V) bool {
+	if m.vs.IsUnderscore(u) {
+		return true
+	}
+	w, ok := m.vs.AsMap(u)
+	if !ok || len(m.keys) != len(w.keys) {
+		return false
+	}
+	for k, entry := range m.entries {
+		other, ok := w.entries[k]
+		if !ok || !entry.value.Eq(other.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// The builtins behind the map stdlib
+
+func (b *builtins[V]) mapBuiltins() []Builtin[V] {
+	return []Builtin[V]{
+		{Name: "__map_get", Args: 2, fn: b.mapGet},
+		{Name: "__map_has", Args: 2, fn: b.mapHas},
+		{Name: "__map_insert", Args: 3, fn: b.mapInsert},
+		{Name: "__map_remove", Args: 2, fn: b.mapRemove},
+		{Name: "__map_keys", Args: 1, fn: b.mapKeys},
+		{Name: "__map_values", Args: 1, fn: b.mapValues},
+		{Name: "__map_entries", Args: 1, fn: b.mapEntries},
+	}
+}
+
+func (b *builtins[V]) mapArg(name string, args []V) *Map[V] {
+	m, ok := b.vs.AsMap(args[0])
+	if !ok {
+		fail("Unexpected argument to %s: %s. Expected a Map.", name, args[0])
+	}
+	return m
+}
+
+// Returns a Maybe
+func (b *builtins[V]) mapGet(args []V) V {
+	if v, ok := b.mapArg("__map_get", args).Get(args[1]); ok {
+		return toSome(b.vs, v)
+	}
+	return toNone(b.vs)
+}
+
+func (b *builtins[V]) mapHas(args []V) V {
+	_, ok := b.mapArg("__map_has", args).Get(args[1])
+	return b.vs.Bool(ok)
+}
+
+func (b *builtins[V]) mapInsert(args []V) V {
+	return b.vs.Map(b.mapArg("__map_insert", args).Insert(args[1], args[2]))
+}
+
+func (b *builtins[V]) mapRemove(args []V) V {
+	return b.vs.Map(b.mapArg("__map_remove", args).Remove(args[1]))
+}
+
+func (b *builtins[V]) mapKeys(args []V) V {
+	keys, _ := b.mapArg("__map_keys", args).Entries()
+	return b.vs.List(keys)
+}
+
+func (b *builtins[V]) mapValues(args []V) V {
+	_, values := b.mapArg("__map_values", args).Entries()
+	return b.vs.List(values)
+}
+
+// Returns a list of [key, value] tuples
+func (b *builtins[V]) mapEntries(args []V) V {
+	keys, values := b.mapArg("__map_entries", args).Entries()
+	entries := make([]V, len(keys))
+	for i := range keys {
+		entries[i] = b.vs.List([]V{keys[i], values[i]})
+	}
+	return b.vs.List(entries)
+}
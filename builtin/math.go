@@ -0,0 +1,218 @@
+package builtin
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+// The builtins behind the math stdlib, and the number conversions in base.raja
+
+func (b *builtins[V]) mathBuiltins() []Builtin[V] {
+	return []Builtin[V]{
+		{Name: "__float", Args: 1, fn: b.parseFloat},
+		{Name: "__to_float", Args: 1, fn: b.toFloat},
+		{Name: "__trunc", Args: 1, fn: b.floatToInt("__trunc", math.Trunc)},
+		{Name: "__round", Args: 1, fn: b.floatToInt("__round", math.Round)},
+		{Name: "__floor", Args: 1, fn: b.floatToInt("__floor", math.Floor)},
+		{Name: "__ceil", Args: 1, fn: b.floatToInt("__ceil", math.Ceil)},
+		{Name: "__abs", Args: 1, fn: b.abs},
+		{Name: "__min", Args: 2, fn: b.min},
+		{Name: "__max", Args: 2, fn: b.max},
+		{Name: "__int_pow", Args: 2, fn: b.intPow},
+		{Name: "__pow", Args: 2, fn: b.floatFn2("__pow", math.Pow)},
+		{Name: "__atan2", Args: 2, fn: b.floatFn2("__atan2", math.Atan2)},
+		{Name: "__sqrt", Args: 1, fn: b.floatFn("__sqrt", math.Sqrt)},
+		{Name: "__exp", Args: 1, fn: b.floatFn("__exp", math.Exp)},
+		{Name: "__log", Args: 1, fn: b.floatFn("__log", math.Log)},
+		{Name: "__log2", Args: 1, fn: b.floatFn("__log2", math.Log2)},
+		{Name: "__log10", Args: 1, fn: b.floatFn("__log10", math.Log10)},
+		{Name: "__sin", Args: 1, fn: b.floatFn("__sin", math.Sin)},
+		{Name: "__cos", Args: 1, fn: b.floatFn("__cos", math.Cos)},
+		{Name: "__tan", Args: 1, fn: b.floatFn("__tan", math.Tan)},
+		{Name: "__asin", Args: 1, fn: b.floatFn("__asin", math.Asin)},
+		{Name: "__acos", Args: 1, fn: b.floatFn("__acos", math.Acos)},
+		{Name: "__atan", Args: 1, fn: b.floatFn("__atan", math.Atan)},
+		{Name: "__div", Args: 2, fn: b.div},
+		{Name: "__mod", Args: 2, fn: b.mod},
+		{Name: "__gcd", Args: 2, fn: b.gcd},
+		{Name: "__lcm", Args: 2, fn: b.lcm},
+	}
+}
+
+// An Int or a Float argument, as a float64
+func (b *builtins[V]) numArg(name string, args []V, i int) float64 {
+	if n, ok := b.vs.AsInt(args[i]); ok {
+		return float64(n)
+	}
+	if n, ok := b.vs.AsBigInt(args[i]); ok {
+		return bigToFloat(n)
+	}
+	if f, ok := b.vs.AsFloat(args[i]); ok {
+		return f
+	}
+	fail("Unexpected argument to %s: %s. Expected a number.", name, args[i])
+	return 0
+}
+
+// An Int argument, small or big, as a big.Int
+func (b *builtins[V]) bigArg(name string, args []V, i int) *big.Int {
+	n, ok := b.toBig(args[i])
+	if !ok {
+		fail("Unexpected argument to %s: %s. Expected an int.", name, args[i])
+	}
+	return n
+}
+
+// The closest float64 to n
+func bigToFloat(n *big.Int) float64 {
+	f, _ := new(big.Float).SetInt(n).Float64()
+	return f
+}
+
+// A builtin taking one number and giving a Float
+func (b *builtins[V]) floatFn(name string, fn func(float64) float64) func([]V) V {
+	return func(args []V) V {
+		return b.vs.Float(fn(b.numArg(name, args, 0)))
+	}
+}
+
+// A builtin taking two numbers and giving a Float
+func (b *builtins[V]) floatFn2(name string, fn func(float64, float64) float64) func([]V) V {
+	return func(args []V) V {
+		return b.vs.Float(fn(b.numArg(name, args, 0), b.numArg(name, args, 1)))
+	}
+}
+
+// A builtin rounding a Float to an Int with fn
+func (b *builtins[V]) floatToInt(name string, fn func(float64) float64) func([]V) V {
+	return func(args []V) V {
+		x := b.numArg(name, args, 0)
+		rounded := fn(x)
+		if math.IsNaN(rounded) || math.IsInf(rounded, 0) {
+			fail("Cannot convert %s to an int", b.vs.Float(x))
+		}
+		// -2^63 is exact as a float64, 2^63 is the first float64 that does not fit
+		if rounded < math.MinInt64 || rounded >= math.MaxInt64 {
+			n, _ := big.NewFloat(rounded).Int(nil)
+			return b.bigInt(n)
+		}
+		return b.vs.Int(int64(rounded))
+	}
+}
+
+func (b *builtins[V]) parseFloat(args []V) V {
+	s, ok := b.vs.AsStr(args[0])
+	if !ok {
+		return toErr(b.vs, fmt.Sprintf("Cannot cast %s to float", args[0]))
+	}
+	f, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil {
+		return toErr(b.vs, err.Error())
+	}
+	return toOk(b.vs, b.vs.Float(f))
+}
+
+func (b *builtins[V]) toFloat(args []V) V {
+	return b.vs.Float(b.numArg("__to_float", args, 0))
+}
+
+func (b *builtins[V]) abs(args []V) V {
+	if n, ok := b.vs.AsInt(args[0]); ok {
+		if n == math.MinInt64 {
+			return b.bigInt(new(big.Int).Neg(big.NewInt(n)))
+		}
+		if n < 0 {
+			return b.vs.Int(-n)
+		}
+		return args[0]
+	}
+	if n, ok := b.vs.AsBigInt(args[0]); ok {
+		return b.bigInt(new(big.Int).Abs(n))
+	}
+	if f, ok := b.vs.AsFloat(args[0]); ok {
+		return b.vs.Float(math.Abs(f))
+	}
+	fail("Unexpected argument to __abs: %s. Expected a number.", args[0])
+	return args[0]
+}
+
+// Compares two numbers, -1 if a < b, 0 if a == b, 1 if a > b.
+// Ints are compared exactly, even when they are too large to be a float64.
+func (b *builtins[V]) compareNums(name string, args []V) int {
+	if x, ok := b.toBig(args[0]); ok {
+		if y, ok := b.toBig(args[1]); ok {
+			return x.Cmp(y)
+		}
+	}
+	return big.NewFloat(b.numArg(name, args, 0)).Cmp(big.NewFloat(b.numArg(name, args, 1)))
+}
+
+// The smaller of the two numbers, as it was given, so that min(1, 2.5) is the Int 1
+func (b *builtins[V]) min(args []V) V {
+	if b.compareNums("__min", args) > 0 {
+		return args[1]
+	}
+	return args[0]
+}
+
+func (b *builtins[V]) max(args []V) V {
+	if b.compareNums("__max", args) < 0 {
+		return args[1]
+	}
+	return args[0]
+}
+
+func (b *builtins[V]) intPow(args []V) V {
+	base, exp := b.bigArg("__int_pow", args, 0), b.intArg("__int_pow", args, 1)
+	if exp < 0 {
+		fail("Cannot raise the int %s to the negative power %d, use a float", args[0], exp)
+	}
+	return b.bigInt(new(big.Int).Exp(base, big.NewInt(int64(exp)), nil))
+}
+
+// Division rounding towards negative infinity, so that div(-7, 2) is -4
+func (b *builtins[V]) div(args []V) V {
+	x, y := b.intArg("__div", args, 0), b.intArg("__div", args, 1)
+	if y == 0 {
+		fail("Division by zero")
+	}
+	// The only quotient that does not fit
+	if x == math.MinInt64 && y == -1 {
+		return b.bigInt(new(big.Int).Neg(big.NewInt(int64(x))))
+	}
+	q := x / y
+	if x%y != 0 && (x < 0) != (y < 0) {
+		q--
+	}
+	return b.vs.Int(int64(q))
+}
+
+// The remainder of div, which has the sign of the divisor, so that mod(-7, 2) is 1
+func (b *builtins[V]) mod(args []V) V {
+	x, y := b.intArg("__mod", args, 0), b.intArg("__mod", args, 1)
+	if y == 0 {
+		fail("Division by zero")
+	}
+	m := x % y
+	if m != 0 && (m < 0) != (y < 0) {
+		m += y
+	}
+	return b.vs.Int(int64(m))
+}
+
+func (b *builtins[V]) gcd(args []V) V {
+	return b.bigInt(new(big.Int).GCD(nil, nil, b.bigArg("__gcd", args, 0), b.bigArg("__gcd", args, 1)))
+}
+
+func (b *builtins[V]) lcm(args []V) V {
+	x, y := b.bigArg("__lcm", args, 0), b.bigArg("__lcm", args, 1)
+	if x.Sign() == 0 || y.Sign() == 0 {
+		return b.vs.Int(0)
+	}
+	lcm := new(big.Int).Quo(x, new(big.Int).GCD(nil, nil, x, y))
+	lcm.Mul(lcm, y)
+	return b.bigInt(lcm.Abs(lcm))
+}
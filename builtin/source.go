@@ -0,0 +1,8 @@
+package builtin
+
+import "embed"
+
+// The source of this package, which codegen copies next to the runtime of the programs it builds
+//
+//go:embed *.go
+var Source embed.FS
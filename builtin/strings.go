@@ -0,0 +1,182 @@
+package builtin
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// The builtins behind the strings stdlib, and the Str functions in base.raja
+
+func (b *builtins[V]) stringBuiltins() []Builtin[V] {
+	return []Builtin[V]{
+		{Name: "__str_split", Args: 2, fn: b.strSplit},
+		{Name: "__str_join", Args: 2, fn: b.strJoin},
+		{Name: "__str_replace", Args: 3, fn: b.strReplace},
+		{Name: "__str_contains", Args: 2, fn: b.strToBool2("__str_contains", strings.Contains)},
+		{Name: "__str_index_of", Args: 2, fn: b.strIndexOf},
+		{Name: "__str_to_upper", Args: 1, fn: b.strFn("__str_to_upper", strings.ToUpper)},
+		{Name: "__str_to_lower", Args: 1, fn: b.strFn("__str_to_lower", strings.ToLower)},
+		// Removes the characters in the second argument from both ends
+		{Name: "__str_trim", Args: 2, fn: b.strToStr2("__str_trim", strings.Trim)},
+		{Name: "__str_trim_left", Args: 2, fn: b.strToStr2("__str_trim_left", strings.TrimLeft)},
+		{Name: "__str_trim_right", Args: 2, fn: b.strToStr2("__str_trim_right", strings.TrimRight)},
+		{Name: "__str_trim_prefix", Args: 2, fn: b.strToStr2("__str_trim_prefix", strings.TrimPrefix)},
+		{Name: "__str_trim_suffix", Args: 2, fn: b.strToStr2("__str_trim_suffix", strings.TrimSuffix)},
+		{Name: "__str_repeat", Args: 2, fn: b.strRepeat},
+		{Name: "__str_pad_left", Args: 3, fn: b.strPad("__str_pad_left", true)},
+		{Name: "__str_pad_right", Args: 3, fn: b.strPad("__str_pad_right", false)},
+		{Name: "__str_lines", Args: 1, fn: b.strLines},
+		{Name: "__str_chars", Args: 1, fn: b.strChars},
+		{Name: "__str_starts_with", Args: 2, fn: b.strToBool2("__str_starts_with", strings.HasPrefix)},
+		{Name: "__str_ends_with", Args: 2, fn: b.strToBool2("__str_ends_with", strings.HasSuffix)},
+		{Name: "__ord", Args: 1, fn: b.ord},
+		{Name: "__chr", Args: 1, fn: b.chr},
+		{Name: "__char", Args: 1, fn: b.char},
+		{Name: "__bytes", Args: 1, fn: b.bytes},
+		{Name: "__byte_length", Args: 1, fn: b.byteLength},
+	}
+}
+
+// A builtin taking one Str and giving a Str
+func (b *builtins[V]) strFn(name string, fn func(string) string) func([]V) V {
+	return func(args []V) V {
+		return b.vs.Str(fn(b.strArg(name, args, 0)))
+	}
+}
+
+// A builtin taking two Strs and giving a Str
+func (b *builtins[V]) strToStr2(name string, fn func(string, string) string) func([]V) V {
+	return func(args []V) V {
+		return b.vs.Str(fn(b.strArg(name, args, 0), b.strArg(name, args, 1)))
+	}
+}
+
+// A builtin taking two Strs and giving a Bool
+func (b *builtins[V]) strToBool2(name string, fn func(string, string) bool) func([]V) V {
+	return func(args []V) V {
+		return b.vs.Bool(fn(b.strArg(name, args, 0), b.strArg(name, args, 1)))
+	}
+}
+
+func (b *builtins[V]) strSplit(args []V) V {
+	return b.strList(strings.Split(b.strArg("__str_split", args, 0), b.strArg("__str_split", args, 1)))
+}
+
+func (b *builtins[V]) strJoin(args []V) V {
+	list, ok := b.vs.AsList(args[0])
+	if !ok {
+		fail("Unexpected argument to __str_join: %s. Expected a list.", args[0])
+	}
+	strs := make([]string, len(list))
+	for i := range list {
+		strs[i] = b.strArg("__str_join", list, i)
+	}
+	return b.vs.Str(strings.Join(strs, b.strArg("__str_join", args, 1)))
+}
+
+func (b *builtins[V]) strReplace(args []V) V {
+	s := b.strArg("__str_replace", args, 0)
+	return b.vs.Str(strings.ReplaceAll(s, b.strArg("__str_replace", args, 1), b.strArg("__str_replace", args, 2)))
+}
+
+// The character index of the first match, so it can be given to get
+func (b *builtins[V]) strIndexOf(args []V) V {
+	s := b.strArg("__str_index_of", args, 0)
+	i := strings.Index(s, b.strArg("__str_index_of", args, 1))
+	if i < 0 {
+		return toNone(b.vs)
+	}
+	return toSome(b.vs, b.vs.Int(int64(utf8.RuneCountInString(s[:i]))))
+}
+
+func (b *builtins[V]) strRepeat(args []V) V {
+	s := b.strArg("__str_repeat", args, 0)
+	n := b.intArg("__str_repeat", args, 1)
+	if n < 0 {
+		fail("Cannot repeat a string %d times", n)
+	}
+	return b.vs.Str(strings.Repeat(s, n))
+}
+
+// The padding that makes s width characters wide, made by repeating pad
+func padding(s string, width int, pad string) string {
+	missing := width - utf8.RuneCountInString(s)
+	if missing <= 0 || pad == "" {
+		return ""
+	}
+	padRunes := []rune(strings.Repeat(pad, missing/utf8.RuneCountInString(pad)+1))
+	return string(padRunes[:missing])
+}
+
+// A builtin padding a Str to a width by repeating a Str, on the left or the right
+func (b *builtins[V]) strPad(name string, left bool) func([]V) V {
+	return func(args []V) V {
+		width := b.intArg(name, args, 1)
+		s, pad := b.strArg(name, args, 0), b.strArg(name, args, 2)
+		if left {
+			return b.vs.Str(padding(s, width, pad) + s)
+		}
+		return b.vs.Str(s + padding(s, width, pad))
+	}
+}
+
+// Splits on \n and \r\n. A line ending at the end does not give an empty last line.
+func (b *builtins[V]) strLines(args []V) V {
+	text := strings.TrimSuffix(b.strArg("__str_lines", args, 0), "\n")
+	if text == "" {
+		return b.vs.List([]V{})
+	}
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimSuffix(line, "\r")
+	}
+	return b.strList(lines)
+}
+
+// Every character, as a Str of its own
+func (b *builtins[V]) strChars(args []V) V {
+	chars := []V{}
+	for _, r := range b.strArg("__str_chars", args, 0) {
+		chars = append(chars, b.vs.Str(string(r)))
+	}
+	return b.vs.List(chars)
+}
+
+func (b *builtins[V]) ord(args []V) V {
+	char, ok := b.vs.AsChar(args[0])
+	if !ok {
+		fail("Unexpected argument to __ord: %s. Expected a char.", args[0])
+	}
+	return b.vs.Int(int64(char))
+}
+
+func (b *builtins[V]) chr(args []V) V {
+	n := b.intArg("__chr", args, 0)
+	if n > utf8.MaxRune || !utf8.ValidRune(rune(n)) {
+		fail("%d is not a valid character", n)
+	}
+	return b.vs.Char(rune(n))
+}
+
+// The Char in a Str of one character
+func (b *builtins[V]) char(args []V) V {
+	s := b.strArg("__char", args, 0)
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) {
+		fail("Cannot make a char from %q, it has to be a single character", s)
+	}
+	return b.vs.Char(r)
+}
+
+func (b *builtins[V]) bytes(args []V) V {
+	s := b.strArg("__bytes", args, 0)
+	list := make([]V, len(s))
+	for i := 0; i < len(s); i++ {
+		list[i] = b.vs.Int(int64(s[i]))
+	}
+	return b.vs.List(list)
+}
+
+func (b *builtins[V]) byteLength(args []V) V {
+	return b.vs.Int(int64(len(b.strArg("__byte_length", args, 0))))
+}
@@ -1,24 +1,56 @@
 package codegen
 
 import (
+	"bytes"
+	"dghaehre/raja/ast"
+	"dghaehre/raja/builtin"
+	"dghaehre/raja/lib"
 	"dghaehre/raja/typecheck"
 	"dghaehre/raja/util"
+	"embed"
 	"fmt"
-	"io"
+	"go/format"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
 	"strings"
 )
 
+// The runtime linked into the built program. Its source is copied next to the generated code,
+// with the source of the builtins it shares with the interpreter.
+//
+//go:embed rt/*.go
+var runtimeSource embed.FS
+
+// Name of the go module the program is built in. It is the name of this module,
+// so that the runtime imports the builtins by the same path in both.
+const moduleName = "dghaehre/raja"
+
 type Env struct {
 	imports []string
+
+	// Generated top-level Go functions, besides main
+	funcs []string
+
+	// Generated module functions, by lib.Module.Key
+	modules map[string]string
+
+	// Modules currently being generated, used to detect cyclic imports
+	importing []lib.Module
+
+	// What the typechecker knows about the expressions, see generateTyped
+	kinds typecheck.Kinds
 }
 
-func NewEnv() *Env {
-	return &Env{}
+func NewEnv(kinds typecheck.Kinds) *Env {
+	return &Env{
+		modules: map[string]string{},
+		kinds:   kinds,
+	}
 }
 
-// TODO: check if it exists already
 func (e *Env) addImport(path string) {
 	if !util.Exists(e.imports, path) {
 		e.imports = append(e.imports, path)
@@ -35,23 +67,371 @@ func (e *Env) GenerateHeader() string {
 		return main + `import "` + e.imports[0] + `"`
 	}
 	return main + `import (
-  ` + strings.Join(e.imports, "\n") + `
+  "` + strings.Join(e.imports, "\"\n  \"") + `"
   )`
 }
 
-// I need payload in TypedAstNode to be able to do anything useful!
-func (e *Env) GenerateBody(tast typecheck.TypedAstNode) (string, error) {
-	e.addImport("fmt")
+type generateError struct {
+	reason string
+	ast.Pos
+}
 
-	return `
-	func main() {
-		fmt.Println("heyja")
+func (e generateError) Error() string {
+	return fmt.Sprintf("Build error at %s: %s", e.Pos, e.reason)
+}
+
+func generateTypes(types []ast.Type) string {
+	if len(types) == 0 {
+		return "nil"
+	}
+	generated := make([]string, len(types))
+	for i, t := range types {
+		generated[i] = fmt.Sprintf("{Name: %s, Params: %s}", quote(t.Name), generateTypes(t.Params))
+	}
+	return "[]rt.Type{" + strings.Join(generated, ", ") + "}"
+}
+
+func quote(s string) string {
+	return strconv.Quote(s)
+}
+
+func callSite(n ast.FnCallNode) string {
+	name := ""
+	if ident, ok := n.Fn.(ast.IdentifierNode); ok {
+		name = ident.Payload
+	}
+	return fmt.Sprintf("rt.CallSite{Name: %s, FirstArg: %s, Dot: %t, Pos: %s}",
+		quote(name), quote(n.FirstArgName()), n.Dot, quote(n.Pos().String()))
+}
+
+// Generates a Go expression of type rt.Value, evaluated in the *rt.Scope named sc.
+//
+// If tail is true, the expression is in tail position of a function body,
+// and function calls are generated as tail calls.
+func (e *Env) generateExpr(node ast.AstNode, tail bool) (string, error) {
+	switch n := node.(type) {
+	case ast.IntNode:
+		return fmt.Sprintf("rt.Int(%d)", n.Payload), nil
+	case ast.FloatNode:
+		return fmt.Sprintf("rt.Float(%s)", strconv.FormatFloat(n.Payload, 'g', -1, 64)), nil
+	case ast.BoolNode:
+		return fmt.Sprintf("rt.Bool(%t)", n.Payload), nil
+	case ast.StringNode:
+		return fmt.Sprintf("rt.Str(%s)", quote(string(n.Payload))), nil
+	case ast.UnderscoreNode:
+		return "rt.Underscore{}", nil
+	case ast.IdentifierNode:
+		return fmt.Sprintf("sc.Get(%s, %s)", quote(n.Payload), quote(n.Pos().String())), nil
+	case ast.BinaryNode:
+		binary, err := e.generateBinary(n)
+		return binary.value, err
+	case ast.UnaryNode:
+		unary, err := e.generateUnary(n)
+		return unary.value, err
+	case ast.AssignmentNode:
+		left, ok := n.Left.(ast.IdentifierNode)
+		if !ok {
+			return "", generateError{
+				reason: fmt.Sprintf("Invalid assignment target %s", n.Left),
+				Pos:    n.Pos(),
+			}
+		}
+		right, err := e.generateExpr(n.Right, false)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("sc.Put(%s, %s, %s)", quote(left.Payload), right, quote(n.Pos().String())), nil
+	case ast.FnCallNode:
+		args, err := e.generateExprs(n.Args)
+		if err != nil {
+			return "", err
+		}
+		if _, ok := n.Fn.(ast.IdentifierNode); ok {
+			call := "rt.CallNamed"
+			if tail {
+				call = "rt.TailCallNamed"
+			}
+			return fmt.Sprintf("%s(sc, %s, []rt.Value{%s})", call, callSite(n), args), nil
+		}
+		fn, err := e.generateExpr(n.Fn, false)
+		if err != nil {
+			return "", err
+		}
+		call := "rt.Call"
+		if tail {
+			call = "rt.TailCall"
+		}
+		return fmt.Sprintf("%s(%s, %s, []rt.Value{%s})", call, callSite(n), fn, args), nil
+	case ast.MemberNode:
+		module, err := e.generateExpr(n.Module, false)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("rt.Member(%s, %s, %s)", module, quote(n.Name), quote(n.Pos().String())), nil
+	case ast.BlockNode:
+		body, err := e.generateBody(n.Exprs, tail)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("func(sc *rt.Scope) rt.Value {\n%s\n}(rt.NewScope(sc))", body), nil
+	case ast.ListNode:
+		elems, err := e.generateExprs(n.Elems)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("rt.List{%s}", elems), nil
+	case ast.MapNode:
+		keys, err := e.generateExprs(n.Keys)
+		if err != nil {
+			return "", err
+		}
+		values, err := e.generateExprs(n.Values)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("rt.NewMap([]rt.Value{%s}, []rt.Value{%s})", keys, values), nil
+	case ast.EnumNode:
+		args, err := e.generateExprs(n.Args)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("rt.Enum{Parent: %s, Name: %s, Args: []rt.Value{%s}}", quote(n.Parent), quote(n.Name), args), nil
+	case ast.AliasNode:
+		targets, err := e.generateExprs(n.Targets)
+		if err != nil {
+			return "", err
+		}
+		params := make([]string, len(n.Params))
+		for i, param := range n.Params {
+			params[i] = quote(param)
+		}
+		return fmt.Sprintf("sc.Put(%s, rt.NewAlias(sc, %s, []string{%s}, func(sc *rt.Scope) []rt.Value {\nreturn []rt.Value{%s}\n}), %s)",
+			quote(n.Name), quote(n.Name), strings.Join(params, ", "), targets, quote(n.Pos().String())), nil
+	case ast.FnNode:
+		params := make([]string, len(n.Args))
+		for i, a := range n.Args {
+			params[i] = fmt.Sprintf("{Name: %s, Alias: %s, Params: %s}", quote(a.Name), quote(a.Alias), generateTypes(a.Params))
+		}
+		body, err := e.generateExpr(n.Body, true)
+		if err != nil {
+			return "", err
+		}
+		ret := "nil"
+		if n.Return != nil {
+			ret = fmt.Sprintf("&rt.Type{Name: %s, Params: %s}", quote(n.Return.Name), generateTypes(n.Return.Params))
+		}
+		return fmt.Sprintf("rt.NewFn(sc, []rt.Param{%s}, %s, %s, func(sc *rt.Scope) rt.Value {\nreturn %s\n})",
+			strings.Join(params, ", "), ret, quote(n.String()), body), nil
+	case ast.MatchNode:
+		return e.generateMatch(n, tail)
+	case ast.ImportNode:
+		return e.generateImport(n)
+	case ast.RestNode:
+		return "", generateError{
+			reason: fmt.Sprintf("%s can only be used in a list pattern in a match branch", n),
+			Pos:    n.Pos(),
+		}
+	}
+	return "", generateError{
+		reason: fmt.Sprintf("%s is not supported by --build", node),
+		Pos:    node.Pos(),
+	}
+}
+
+func (e *Env) generateExprs(nodes []ast.AstNode) (string, error) {
+	exprs := make([]string, len(nodes))
+	for i, node := range nodes {
+		expr, err := e.generateExpr(node, false)
+		if err != nil {
+			return "", err
+		}
+		exprs[i] = expr
 	}
-	`, nil
+	return strings.Join(exprs, ", "), nil
 }
 
-func (e *Env) Generate(tast typecheck.TypedAstNode) (string, error) {
-	body, err := e.GenerateBody(tast)
+// Generates the statements of a block, returning the value of the last expression
+func (e *Env) generateBody(nodes []ast.AstNode, tail bool) (string, error) {
+	lines := []string{}
+	last := len(nodes) - 1
+	for _, node := range nodes[:last] {
+		expr, err := e.generateExpr(node, false)
+		if err != nil {
+			return "", err
+		}
+		lines = append(lines, "_ = "+expr)
+	}
+	expr, err := e.generateExpr(nodes[last], tail)
+	if err != nil {
+		return "", err
+	}
+	lines = append(lines, "return "+expr)
+	return strings.Join(lines, "\n"), nil
+}
+
+// Mirrors how the interpreter matches branch targets, see matchPattern in eval
+func (e *Env) generatePattern(node ast.AstNode) (string, error) {
+	switch n := node.(type) {
+	case ast.IdentifierNode:
+		return fmt.Sprintf("rt.PBind(%s)", quote(n.Payload)), nil
+	case ast.UnderscoreNode:
+		return "rt.PAny()", nil
+	case ast.ListNode:
+		elems := []string{}
+		rest := ""
+		for i, elem := range n.Elems {
+			if r, ok := elem.(ast.RestNode); ok && i == len(n.Elems)-1 {
+				rest = r.Name
+				continue
+			}
+			p, err := e.generatePattern(elem)
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, p)
+		}
+		if len(n.Elems) > 0 && len(elems) < len(n.Elems) {
+			return fmt.Sprintf("rt.PListRest(%s, %s)", quote(rest), strings.Join(elems, ", ")), nil
+		}
+		return fmt.Sprintf("rt.PList(%s)", strings.Join(elems, ", ")), nil
+	case ast.EnumNode:
+		args := []string{quote(n.Parent), quote(n.Name)}
+		for _, arg := range n.Args {
+			p, err := e.generatePattern(arg)
+			if err != nil {
+				return "", err
+			}
+			args = append(args, p)
+		}
+		return fmt.Sprintf("rt.PEnum(%s)", strings.Join(args, ", ")), nil
+	}
+	v, err := e.generateExpr(node, false)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("rt.PValue(%s)", v), nil
+}
+
+func (e *Env) generateMatch(n ast.MatchNode, tail bool) (string, error) {
+	if match, ok, err := e.generateBoolMatch(n, tail); err != nil || ok {
+		return match, err
+	}
+	cond, err := e.generateExpr(n.Cond, false)
+	if err != nil {
+		return "", err
+	}
+	pos := quote(n.Pos().String())
+	lines := []string{"cond := " + cond}
+	for _, branch := range n.Branches {
+		pattern, err := e.generatePattern(branch.Target)
+		if err != nil {
+			return "", err
+		}
+		body, err := e.generateExpr(branch.Body, tail)
+		if err != nil {
+			return "", err
+		}
+		guard := ""
+		if branch.Guard != nil {
+			g, err := e.generateExpr(branch.Guard, false)
+			if err != nil {
+				return "", err
+			}
+			guard = fmt.Sprintf(" && rt.Guard(bodyScope, func(sc *rt.Scope) rt.Value {\nreturn %s\n}, %s)",
+				g, quote(branch.Guard.Pos().String()))
+		}
+		lines = append(lines, fmt.Sprintf(`if bodyScope, ok := rt.Match(sc, cond, %s, %s); ok%s {
+return func(sc *rt.Scope) rt.Value {
+return %s
+}(bodyScope)
+}`, pattern, pos, guard, body))
+	}
+	lines = append(lines, fmt.Sprintf("return rt.Fail(%s, %s, %s)",
+		pos, quote("No patterns matched in match expression: %s"), quote(n.String())))
+	return fmt.Sprintf("func(sc *rt.Scope) rt.Value {\n%s\n}(sc)", strings.Join(lines, "\n")), nil
+}
+
+func (e *Env) generateImport(n ast.ImportNode) (string, error) {
+	mod, err := lib.ResolveModule(n.Name, n.Path, n.Pos().FileName())
+	if err != nil {
+		return "", generateError{reason: err.Error(), Pos: n.Pos()}
+	}
+	fnName, ok := e.modules[mod.Key]
+	if !ok {
+		for _, importing := range e.importing {
+			if importing.Key == mod.Key {
+				return "", generateError{
+					reason: fmt.Sprintf("Cyclic import of %s", mod.FileName),
+					Pos:    n.Pos(),
+				}
+			}
+		}
+		e.importing = append(e.importing, mod)
+		defer func() {
+			e.importing = e.importing[:len(e.importing)-1]
+		}()
+
+		tokenizer := ast.NewTokenizer(mod.Source, mod.FileName)
+		parser := ast.NewParser(tokenizer.Tokenize())
+		nodes, err := parser.Parse()
+		if err != nil {
+			return "", err
+		}
+		fnName = fmt.Sprintf("module%d", len(e.modules))
+		e.modules[mod.Key] = fnName
+		if err := e.generateFunc(fnName, nodes); err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("rt.Import(sc, %s, %s, %s, %s, %s)",
+		quote(mod.Key), quote(mod.FileName), quote(n.Name), quote(n.Pos().String()), fnName), nil
+}
+
+// Generates a top-level Go function evaluating the nodes in the given scope
+func (e *Env) generateFunc(name string, nodes []ast.AstNode) error {
+	lines := []string{}
+	for _, node := range nodes {
+		expr, err := e.generateExpr(node, false)
+		if err != nil {
+			return err
+		}
+		lines = append(lines, "_ = "+expr)
+	}
+	e.funcs = append(e.funcs, fmt.Sprintf("func %s(sc *rt.Scope) {\n%s\n}", name, strings.Join(lines, "\n")))
+	return nil
+}
+
+func (e *Env) GenerateBody(program typecheck.TypedProgram) (string, error) {
+	e.addImport(moduleName + "/rt")
+
+	tokenizer := ast.NewTokenizer(lib.Stdlibs["base"], "base")
+	parser := ast.NewParser(tokenizer.Tokenize())
+	base, err := parser.Parse()
+	if err != nil {
+		return "", err
+	}
+	if err := e.generateFunc("base", base); err != nil {
+		return "", err
+	}
+
+	nodes := make([]ast.AstNode, len(program))
+	for i, typed := range program {
+		nodes[i] = typed.Node()
+	}
+	if err := e.generateFunc("program", nodes); err != nil {
+		return "", err
+	}
+
+	return `
+func main() {
+	rt.Run(base, program)
+}
+
+` + strings.Join(e.funcs, "\n\n"), nil
+}
+
+func (e *Env) Generate(program typecheck.TypedProgram) (string, error) {
+	body, err := e.GenerateBody(program)
 	if err != nil {
 		return "", err
 	}
@@ -59,29 +439,102 @@ func (e *Env) Generate(tast typecheck.TypedAstNode) (string, error) {
 	// Do this after body generation
 	header := e.GenerateHeader()
 
-	return header + body, nil
+	source, err := format.Source([]byte(header + body))
+	if err != nil {
+		return "", fmt.Errorf("Generated invalid Go code: %s", err)
+	}
+	return string(source), nil
 }
 
-func Build(tast typecheck.TypedAstNode, filePath string) error {
-	env := NewEnv()
-	s, err := env.Generate(tast)
+// Writes the generated program and the runtime as a Go module in dir
+func writeModule(dir string, source string) error {
+	goMod := fmt.Sprintf("module %s\n\ngo 1.20\n", moduleName)
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(goMod), 0o644); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "main.go"), []byte(source), 0o644); err != nil {
+		return err
+	}
+	if err := copySource(runtimeSource, "rt", filepath.Join(dir, "rt")); err != nil {
+		return err
+	}
+	return copySource(builtin.Source, ".", filepath.Join(dir, "builtin"))
+}
+
+// Copies the Go files of the package in dir of source to the directory to
+func copySource(source embed.FS, dir string, to string) error {
+	if err := os.Mkdir(to, 0o755); err != nil {
+		return err
+	}
+	files, err := source.ReadDir(dir)
 	if err != nil {
 		return err
 	}
-	tmpFile, err := os.CreateTemp("", "raja-build-*.go")
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), "_test.go") {
+			continue
+		}
+		content, err := source.ReadFile(path.Join(dir, f.Name()))
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(to, f.Name()), content, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type BuildOptions struct {
+	// Path of the built binary
+	Output string
+
+	// If set, the generated Go source is also written to this file
+	EmitGo string
+
+	// Target of the binary. Empty means the host, or GOOS/GOARCH from the environment.
+	GOOS   string
+	GOARCH string
+}
+
+// Builds the program typechecked with the given kinds, see TypecheckContext.Kinds
+func Build(program typecheck.TypedProgram, kinds typecheck.Kinds, opts BuildOptions) error {
+	env := NewEnv(kinds)
+	s, err := env.Generate(program)
 	if err != nil {
 		return err
 	}
-	_, err = io.WriteString(tmpFile, s)
+	if opts.EmitGo != "" {
+		if err := os.WriteFile(opts.EmitGo, []byte(s), 0o644); err != nil {
+			return err
+		}
+	}
+	tmpDir, err := os.MkdirTemp("", "raja-build-*")
 	if err != nil {
 		return err
 	}
-	cmd := exec.Command("go", "build", "-o", filePath, tmpFile.Name())
-	out, err := cmd.Output()
+	defer os.RemoveAll(tmpDir)
+	if err := writeModule(tmpDir, s); err != nil {
+		return err
+	}
+	output, err := filepath.Abs(opts.Output)
 	if err != nil {
-		fmt.Println(tmpFile.Name())
-		fmt.Println(string(out))
 		return err
 	}
+	cmd := exec.Command("go", "build", "-o", output, ".")
+	cmd.Dir = tmpDir
+	// The generated module is self-contained, so we do not want the go flags of the caller
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+	if opts.GOOS != "" {
+		cmd.Env = append(cmd.Env, "GOOS="+opts.GOOS)
+	}
+	if opts.GOARCH != "" {
+		cmd.Env = append(cmd.Env, "GOARCH="+opts.GOARCH)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go build failed: %s\n%s", err, stderr.String())
+	}
 	return nil
 }
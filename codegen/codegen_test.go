@@ -0,0 +1,130 @@
+package codegen
+
+import (
+	"dghaehre/raja/eval"
+	"dghaehre/raja/typecheck"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Runs the program with the interpreter, and returns what it printed to stdout
+func interpret(t *testing.T, filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var out strings.Builder
+	c := eval.NewContext(eval.WithStdout(&out))
+	c.LoadBuiltins()
+	_, err = c.Eval(file, filePath)
+	return out.String(), err
+}
+
+func build(t *testing.T, filePath string) string {
+	file, err := os.Open(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	c := typecheck.NewTypecheckContext()
+	c.LoadBuiltins()
+	c.LoadLibs()
+	program, err := c.TypecheckProgram(file, filePath)
+	if err != nil {
+		t.Fatalf("%s does not typecheck: %s", filePath, err)
+	}
+	binary := filepath.Join(t.TempDir(), "program")
+	if err := Build(program, c.Kinds(), BuildOptions{Output: binary}); err != nil {
+		t.Fatalf("Could not build %s: %s", filePath, err)
+	}
+	return binary
+}
+
+// Examples that are not programs of their own, and why. Every other example is built.
+var notPrograms = map[string]string{
+	// Loaded by a Go program, which defines config, __word_count and __shout for it
+	"examples/embed/script.raja": "is a script for examples/embed/main.go, which gives it builtins",
+}
+
+func TestExamplesBuiltAndInterpretedPrintTheSame(t *testing.T) {
+	if testing.Short() {
+		t.Skip("building examples is slow")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go is needed to build examples")
+	}
+
+	// Examples use paths relative to the root of the repository
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(".."); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	examples, err := filepath.Glob("examples/*.raja")
+	if err != nil {
+		t.Fatal(err)
+	}
+	nested, err := filepath.Glob("examples/*/*.raja")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, example := range append(examples, nested...) {
+		t.Run(example, func(t *testing.T) {
+			if reason, ok := notPrograms[example]; ok {
+				t.Skipf("%s %s", example, reason)
+			}
+			expected, err := interpret(t, example)
+			if err != nil {
+				t.Fatalf("%s does not run in the interpreter: %s", example, err)
+			}
+			binary := build(t, example)
+			out, err := exec.Command(binary).Output()
+			if err != nil {
+				t.Fatalf("Running built %s failed: %s", example, err)
+			}
+			if string(out) != expected {
+				t.Errorf("Built %s printed:\n%s\nbut the interpreter printed:\n%s", example, out, expected)
+			}
+		})
+	}
+}
+
+func TestKnownTypesAreGeneratedAsGoValues(t *testing.T) {
+	source := `big? = (x:Float) => x * 2.5 > 1.0 && !(x == 3.0)
+greet = (name:Str) => match big?(2.0) {
+  true -> "hello " ++ name
+  false -> name
+}
+`
+	c := typecheck.NewTypecheckContext()
+	c.LoadBuiltins()
+	c.LoadLibs()
+	program, err := c.TypecheckProgram(strings.NewReader(source), "known.raja")
+	if err != nil {
+		t.Fatalf("does not typecheck: %s", err)
+	}
+	generated, err := NewEnv(c.Kinds()).Generate(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		`((rt.AsFloat(sc.Get("x", "known.raja[1:21]"), "known.raja[1:21]") * float64(2.5)) > float64(1))`,
+		`&& !(rt.AsFloat(sc.Get("x", "known.raja[1:40]"), "known.raja[1:40]") == float64(3))`,
+		`cond := rt.AsBool(`,
+		`rt.Str(("hello " + rt.AsStr(sc.Get("name",`,
+	} {
+		if !strings.Contains(generated, expected) {
+			t.Errorf("Expected the generated Go to contain\n%s\ngot:\n%s", expected, generated[strings.Index(generated, "func program"):])
+		}
+	}
+}
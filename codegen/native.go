@@ -0,0 +1,224 @@
+package codegen
+
+import (
+	"dghaehre/raja/ast"
+	"dghaehre/raja/typecheck"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Expressions the typechecker knows to be a Bool, Float or Str are also generated as a Go
+// bool, float64 or string, so that operators on them are Go operators instead of rt.Binary.
+
+// A generated expression, as an rt.Value, and as a Go value when its kind is known
+type typedExpr struct {
+	value string
+
+	// Empty when the kind is not known
+	native string
+	kind   typecheck.Kind
+
+	// The native expression is a Go constant. Go evaluates constants when it builds the program,
+	// so float arithmetic on them is left to the runtime, where it cannot fail the build.
+	constant bool
+}
+
+// Wraps a Go value of the given kind as an rt.Value
+func fromNative(native string, kind typecheck.Kind, constant bool) typedExpr {
+	value := ""
+	switch kind {
+	case typecheck.BoolKind:
+		value = fmt.Sprintf("rt.Bool(%s)", native)
+	case typecheck.FloatKind:
+		value = fmt.Sprintf("rt.Float(%s)", native)
+	case typecheck.StrKind:
+		value = fmt.Sprintf("rt.Str(%s)", native)
+	}
+	return typedExpr{value: value, native: native, kind: kind, constant: constant}
+}
+
+// The generated value of node, unwrapped to a Go value when the typechecker knows its kind
+func (e *Env) known(node ast.AstNode, value string) typedExpr {
+	t := typedExpr{value: value, kind: e.kinds.Of(node)}
+	pos := quote(node.Pos().String())
+	switch t.kind {
+	case typecheck.BoolKind:
+		t.native = fmt.Sprintf("rt.AsBool(%s, %s)", value, pos)
+	case typecheck.FloatKind:
+		t.native = fmt.Sprintf("rt.AsFloat(%s, %s)", value, pos)
+	case typecheck.StrKind:
+		t.native = fmt.Sprintf("rt.AsStr(%s, %s)", value, pos)
+	}
+	return t
+}
+
+// Generates node both as an rt.Value and, when its kind is known, as a Go value
+func (e *Env) generateTyped(node ast.AstNode) (typedExpr, error) {
+	switch n := node.(type) {
+	case ast.BoolNode:
+		return fromNative(strconv.FormatBool(n.Payload), typecheck.BoolKind, true), nil
+	case ast.FloatNode:
+		return fromNative(fmt.Sprintf("float64(%s)", strconv.FormatFloat(n.Payload, 'g', -1, 64)), typecheck.FloatKind, true), nil
+	case ast.StringNode:
+		return fromNative(quote(string(n.Payload)), typecheck.StrKind, true), nil
+	case ast.BinaryNode:
+		return e.generateBinary(n)
+	case ast.UnaryNode:
+		return e.generateUnary(n)
+	}
+	value, err := e.generateExpr(node, false)
+	if err != nil {
+		return typedExpr{}, err
+	}
+	return e.known(node, value), nil
+}
+
+func (e *Env) generateBinary(n ast.BinaryNode) (typedExpr, error) {
+	left, err := e.generateTyped(n.Left)
+	if err != nil {
+		return typedExpr{}, err
+	}
+	right, err := e.generateTyped(n.Right)
+	if err != nil {
+		return typedExpr{}, err
+	}
+	if native, kind, ok := nativeBinary(n, left, right); ok {
+		return fromNative(native, kind, left.constant && right.constant), nil
+	}
+	op := ast.Token{Kind: n.Op}
+	if n.Op == ast.And || n.Op == ast.Or {
+		// The right side is only evaluated when it is needed
+		return e.known(n, fmt.Sprintf("rt.Logical(%s, %s, func() rt.Value {\nreturn %s\n}, %s)",
+			quote(op.String()), left.value, right.value, quote(n.Pos().String()))), nil
+	}
+	return e.known(n, fmt.Sprintf("rt.Binary(%s, %s, %s, %s)",
+		quote(op.String()), left.value, right.value, quote(n.Pos().String()))), nil
+}
+
+// The Go expression for the operator, when both sides are Go values it works on as rt.Binary does
+func nativeBinary(n ast.BinaryNode, left, right typedExpr) (string, typecheck.Kind, bool) {
+	if left.native == "" || right.native == "" || left.kind != right.kind {
+		return "", typecheck.UnknownKind, false
+	}
+	pos := quote(n.Pos().String())
+	infix := func(op string, kind typecheck.Kind) (string, typecheck.Kind, bool) {
+		return fmt.Sprintf("(%s %s %s)", left.native, op, right.native), kind, true
+	}
+	switch left.kind {
+	case typecheck.BoolKind:
+		switch n.Op {
+		case ast.And:
+			return infix("&&", typecheck.BoolKind)
+		case ast.Or:
+			return infix("||", typecheck.BoolKind)
+		case ast.Eq:
+			return infix("==", typecheck.BoolKind)
+		}
+	case typecheck.FloatKind:
+		if left.constant && right.constant {
+			break
+		}
+		switch n.Op {
+		case ast.Plus:
+			return infix("+", typecheck.FloatKind)
+		case ast.Minus:
+			return infix("-", typecheck.FloatKind)
+		case ast.Times:
+			return infix("*", typecheck.FloatKind)
+		case ast.Divide:
+			return fmt.Sprintf("rt.FloatDiv(%s, %s, %s)", left.native, right.native, pos), typecheck.FloatKind, true
+		case ast.Modulus:
+			return fmt.Sprintf("rt.FloatMod(%s, %s, %s)", left.native, right.native, pos), typecheck.FloatKind, true
+		case ast.Eq:
+			return infix("==", typecheck.BoolKind)
+		case ast.Neq:
+			return infix("!=", typecheck.BoolKind)
+		case ast.Greater:
+			return infix(">", typecheck.BoolKind)
+		case ast.Less:
+			return infix("<", typecheck.BoolKind)
+		case ast.Geq:
+			return infix(">=", typecheck.BoolKind)
+		case ast.Leq:
+			return infix("<=", typecheck.BoolKind)
+		}
+	case typecheck.StrKind:
+		switch n.Op {
+		case ast.PlusOther:
+			return infix("+", typecheck.StrKind)
+		case ast.Eq:
+			return infix("==", typecheck.BoolKind)
+		case ast.Neq:
+			return infix("!=", typecheck.BoolKind)
+		}
+	}
+	return "", typecheck.UnknownKind, false
+}
+
+func (e *Env) generateUnary(n ast.UnaryNode) (typedExpr, error) {
+	operand, err := e.generateTyped(n.Operand)
+	if err != nil {
+		return typedExpr{}, err
+	}
+	switch {
+	case n.Op == ast.Not && operand.kind == typecheck.BoolKind && operand.native != "":
+		return fromNative("!"+operand.native, typecheck.BoolKind, operand.constant), nil
+	case n.Op == ast.Minus && operand.kind == typecheck.FloatKind && operand.native != "":
+		return fromNative(fmt.Sprintf("(-%s)", operand.native), typecheck.FloatKind, operand.constant), nil
+	}
+	op := ast.Token{Kind: n.Op}
+	return e.known(n, fmt.Sprintf("rt.Unary(%s, %s, %s)", quote(op.String()), operand.value, quote(n.Pos().String()))), nil
+}
+
+// A match on a Bool with only true, false and _ as patterns, generated as Go ifs.
+// Not ok for any other match.
+func (e *Env) generateBoolMatch(n ast.MatchNode, tail bool) (string, bool, error) {
+	for i, branch := range n.Branches {
+		if branch.Guard != nil {
+			return "", false, nil
+		}
+		switch branch.Target.(type) {
+		case ast.BoolNode:
+		case ast.UnderscoreNode:
+			// The condition has to be used by a branch before it
+			if i == 0 {
+				return "", false, nil
+			}
+		default:
+			return "", false, nil
+		}
+	}
+	if e.kinds.Of(n.Cond) != typecheck.BoolKind {
+		return "", false, nil
+	}
+	cond, err := e.generateTyped(n.Cond)
+	if err != nil {
+		return "", false, err
+	}
+	lines := []string{"cond := " + cond.native}
+	exhaustive := false
+	for _, branch := range n.Branches {
+		body, err := e.generateExpr(branch.Body, tail)
+		if err != nil {
+			return "", false, err
+		}
+		body = fmt.Sprintf("return func(sc *rt.Scope) rt.Value {\nreturn %s\n}(rt.NewScope(sc))", body)
+		target, ok := branch.Target.(ast.BoolNode)
+		if !ok {
+			lines = append(lines, body)
+			exhaustive = true
+			break
+		}
+		test := "cond"
+		if !target.Payload {
+			test = "!cond"
+		}
+		lines = append(lines, fmt.Sprintf("if %s {\n%s\n}", test, body))
+	}
+	if !exhaustive {
+		lines = append(lines, fmt.Sprintf("return rt.Fail(%s, %s, %s)",
+			quote(n.Pos().String()), quote("No patterns matched in match expression: %s"), quote(n.String())))
+	}
+	return fmt.Sprintf("func(sc *rt.Scope) rt.Value {\n%s\n}(sc)", strings.Join(lines, "\n")), true, nil
+}
@@ -0,0 +1,106 @@
+package rt
+
+import (
+	"math"
+	"math/big"
+)
+
+// An Int too large for int64, which Int operations give instead of overflowing.
+// A BigInt that fits in int64 is always turned back into an Int.
+type BigInt struct {
+	value *big.Int
+}
+
+// The Int holding n, as an Int when it fits
+func NewBigInt(n *big.Int) Value {
+	if n.IsInt64() {
+		return Int(n.Int64())
+	}
+	return BigInt{value: n}
+}
+
+func (v BigInt) String() string {
+	return v.value.String()
+}
+
+func (v BigInt) Eq(u Value) bool {
+	if _, ok := u.(Underscore); ok {
+		return true
+	}
+	if w, ok := toBig(u); ok {
+		return v.value.Cmp(w) == 0
+	}
+	if w, ok := u.(Float); ok {
+		return bigToFloat(v.value) == w
+	}
+	return false
+}
+
+// Int values, small or big, as a big.Int
+func toBig(v Value) (*big.Int, bool) {
+	switch n := v.(type) {
+	case Int:
+		return big.NewInt(int64(n)), true
+	case BigInt:
+		return n.value, true
+	}
+	return nil, false
+}
+
+func bigToFloat(n *big.Int) Float {
+	f, _ := new(big.Float).SetInt(n).Float64()
+	return Float(f)
+}
+
+// Whether left op right overflows int64, for the operators that can
+func intOverflows(op string, left, right Int) bool {
+	switch op {
+	case "+":
+		sum := left + right
+		return (left > 0 && right > 0 && sum < 0) || (left < 0 && right < 0 && sum >= 0)
+	case "-":
+		diff := left - right
+		return (left >= 0 && right < 0 && diff < 0) || (left < 0 && right > 0 && diff >= 0)
+	case "*":
+		if left == 0 || right == 0 {
+			return false
+		}
+		product := left * right
+		return product/right != left || (left == -1 && right == math.MinInt64) || (right == -1 && left == math.MinInt64)
+	case "/":
+		return left == math.MinInt64 && right == -1
+	}
+	return false
+}
+
+func bigOp(op string, left, right *big.Int, pos string) Value {
+	switch op {
+	case "-":
+		return NewBigInt(new(big.Int).Sub(left, right))
+	case "+":
+		return NewBigInt(new(big.Int).Add(left, right))
+	case "*":
+		return NewBigInt(new(big.Int).Mul(left, right))
+	case "/":
+		if right.Sign() == 0 {
+			return Fail(pos, "Division by zero")
+		}
+		return NewBigInt(new(big.Int).Quo(left, right))
+	case "%":
+		if right.Sign() == 0 {
+			return Fail(pos, "Division by zero")
+		}
+		return NewBigInt(new(big.Int).Rem(left, right))
+	case ">":
+		return Bool(left.Cmp(right) > 0)
+	case "<":
+		return Bool(left.Cmp(right) < 0)
+	case ">=":
+		return Bool(left.Cmp(right) >= 0)
+	case "<=":
+		return Bool(left.Cmp(right) <= 0)
+	case "!=":
+		return Bool(left.Cmp(right) != 0)
+	}
+	return incompatible(op, NewBigInt(left), NewBigInt(right), pos)
+}
@@ -0,0 +1,326 @@
+package rt
+
+import (
+	"bufio"
+	"dghaehre/raja/builtin"
+	"math/big"
+	"os"
+	"strings"
+)
+
+func toSome(v Value) Value {
+	return Enum{Parent: "Maybe", Name: "Some", Args: []Value{v}}
+}
+
+func toNone() Value {
+	return Enum{Parent: "Maybe", Name: "None", Args: []Value{}}
+}
+
+func toOk(v Value) Value {
+	return Enum{Parent: "Result", Name: "Ok", Args: []Value{v}}
+}
+
+func toErr(v Value) Value {
+	return Enum{Parent: "Result", Name: "Err", Args: []Value{v}}
+}
+
+// How the shared builtins make and take apart Values
+type builtinValues struct{}
+
+func (builtinValues) Int(n int64) Value {
+	return Int(n)
+}
+
+func (builtinValues) BigInt(n *big.Int) Value {
+	return BigInt{value: n}
+}
+
+func (builtinValues) Float(f float64) Value {
+	return Float(f)
+}
+
+func (builtinValues) Bool(b bool) Value {
+	return Bool(b)
+}
+
+func (builtinValues) Str(s string) Value {
+	return Str(s)
+}
+
+func (builtinValues) Char(r rune) Value {
+	return Char(r)
+}
+
+func (builtinValues) List(elems []Value) Value {
+	return List(elems)
+}
+
+func (builtinValues) Enum(parent string, name string, args []Value) Value {
+	return Enum{Parent: parent, Name: name, Args: args}
+}
+
+func (builtinValues) Map(m *Map) Value {
+	return m
+}
+
+func (builtinValues) AsInt(v Value) (int64, bool) {
+	n, ok := v.(Int)
+	return int64(n), ok
+}
+
+func (builtinValues) AsBigInt(v Value) (*big.Int, bool) {
+	n, ok := v.(BigInt)
+	return n.value, ok
+}
+
+func (builtinValues) AsFloat(v Value) (float64, bool) {
+	f, ok := v.(Float)
+	return float64(f), ok
+}
+
+func (builtinValues) AsBool(v Value) (bool, bool) {
+	b, ok := v.(Bool)
+	return bool(b), ok
+}
+
+func (builtinValues) AsStr(v Value) (string, bool) {
+	s, ok := v.(Str)
+	return string(s), ok
+}
+
+func (builtinValues) AsChar(v Value) (rune, bool) {
+	r, ok := v.(Char)
+	return rune(r), ok
+}
+
+func (builtinValues) AsList(v Value) ([]Value, bool) {
+	list, ok := v.(List)
+	return list, ok
+}
+
+func (builtinValues) AsEnum(v Value) (string, string, []Value, bool) {
+	e, ok := v.(Enum)
+	return e.Parent, e.Name, e.Args, ok
+}
+
+func (builtinValues) AsMap(v Value) (*Map, bool) {
+	m, ok := v.(*Map)
+	return m, ok
+}
+
+func (builtinValues) IsUnderscore(v Value) bool {
+	_, ok := v.(Underscore)
+	return ok
+}
+
+func loadBuiltins(sc *Scope) {
+	loadAlias(sc, "Int", func(u Value) bool {
+		switch u.(type) {
+		case Int, BigInt:
+			return true
+		}
+		return false
+	})
+	loadAlias(sc, "BigInt", func(u Value) bool { _, ok := u.(BigInt); return ok })
+	loadAlias(sc, "Float", func(u Value) bool { _, ok := u.(Float); return ok })
+	loadAlias(sc, "Str", func(u Value) bool { _, ok := u.(Str); return ok })
+	loadAlias(sc, "Char", func(u Value) bool { _, ok := u.(Char); return ok })
+	loadAlias(sc, "List", func(u Value) bool { _, ok := u.(List); return ok })
+	loadAlias(sc, "Enum", func(u Value) bool { _, ok := u.(Enum); return ok })
+	loadAlias(sc, "Map", func(u Value) bool { _, ok := u.(*Map); return ok })
+	loadAlias(sc, "Fn", func(u Value) bool {
+		switch u.(type) {
+		case *Fn, Fns, Builtin:
+			return true
+		}
+		return false
+	})
+
+	io := builtin.IO{Stdin: stdin, Stdout: os.Stdout, Stderr: os.Stderr, Args: os.Args}
+	for _, b := range builtin.All[Value](builtinValues{}, io) {
+		loadShared(sc, b)
+	}
+	loadFunc(sc, "__index", 3, builtinIndex)
+	loadFunc(sc, "__try", 1, builtinTry)
+	loadFunc(sc, "__lines", 1, builtinLines)
+	loadFunc(sc, "update", 2, builtinUpdate)
+}
+
+// Loads one of the builtins shared with the interpreter
+func loadShared(sc *Scope, b builtin.Builtin[Value]) {
+	sc.vars[b.Name] = Builtin{
+		Name: b.Name,
+		Call: func(_ *Scope, site CallSite, args []Value) Value {
+			v, err := b.Call(args)
+			if exit, ok := err.(*Exit); ok {
+				panic(exit)
+			}
+			if err != nil {
+				return Fail(site.Pos, "%s", err)
+			}
+			return v
+		},
+	}
+}
+
+func loadAlias(sc *Scope, name string, match func(Value) bool) {
+	sc.vars[name] = BuiltinAlias{Name: name, Match: match}
+}
+
+// The name of a type parameter, as it is written
+func typeName(v Value) string {
+	switch t := v.(type) {
+	case BuiltinAlias:
+		return t.Name
+	case Alias:
+		if t.Name != "" {
+			return t.Name
+		}
+	}
+	return v.String()
+}
+
+// List(a) and Map(k, v) take type parameters, which every element must match.
+func (a BuiltinAlias) instantiate(pos string, params []Value) Value {
+	expected := map[string]int{"List": 1, "Map": 2}[a.Name]
+	if expected == 0 {
+		return Fail(pos, "%s does not take type parameters", a.Name)
+	}
+	if len(params) != expected {
+		return Fail(pos, "%s takes %d type parameter(s), got %d", a.Name, expected, len(params))
+	}
+
+	// Avoid looking at every element when the parameters match anything
+	if allUnderscore(params) {
+		return a
+	}
+	names := make([]string, len(params))
+	for i, param := range params {
+		names[i] = typeName(param)
+	}
+
+	instance := BuiltinAlias{Name: a.Name + "(" + strings.Join(names, ", ") + ")"}
+	switch a.Name {
+	case "List":
+		instance.Match = func(u Value) bool {
+			list, ok := u.(List)
+			if !ok {
+				return false
+			}
+			for _, elem := range list {
+				if !params[0].Eq(elem) {
+					return false
+				}
+			}
+			return true
+		}
+	case "Map":
+		instance.Match = func(u Value) bool {
+			m, ok := u.(*Map)
+			if !ok {
+				return false
+			}
+			keys, values := m.Entries()
+			for i := range keys {
+				if !params[0].Eq(keys[i]) || !params[1].Eq(values[i]) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return instance
+}
+
+func loadFunc(sc *Scope, name string, argCount int, fn func(sc *Scope, site CallSite, args []Value) Value) {
+	sc.vars[name] = Builtin{
+		Name: name,
+		Call: func(sc *Scope, site CallSite, args []Value) Value {
+			if len(args) < argCount {
+				return Fail(site.Pos, "%s requires %d arguments, got %d", name, argCount, len(args))
+			}
+			return fn(sc, site, args)
+		},
+	}
+}
+
+// Read line by line, and all at once, so the reads need to share the buffer
+var stdin = bufio.NewReader(os.Stdin)
+
+// Calls the given function with every line of stdin, as it is read,
+// and gives a list of what it returned
+func builtinLines(_ *Scope, site CallSite, args []Value) Value {
+	site.Name = "lines"
+	site.FirstArg = ""
+	list := List{}
+	for {
+		line, ok, err := builtin.ReadLine(stdin)
+		if err != nil {
+			return Fail(site.Pos, "%s", err)
+		}
+		if !ok {
+			return list
+		}
+		list = append(list, Call(site, args[0], []Value{Str(line)}))
+	}
+}
+
+func builtinTry(_ *Scope, site CallSite, args []Value) (v Value) {
+	defer func() {
+		if r := recover(); r != nil {
+			exit, ok := r.(*Exit)
+			if !ok || !exit.Panic {
+				panic(r)
+			}
+			v = toErr(Str(exit.Message))
+		}
+	}()
+	site.Name = "try"
+	site.FirstArg = ""
+	return toOk(Call(site, args[0], []Value{}))
+}
+
+func builtinUpdate(sc *Scope, site CallSite, args []Value) Value {
+	sc.update(site.FirstArg, args[1], site.Pos)
+	return args[1]
+}
+
+func builtinIndex(_ *Scope, site CallSite, args []Value) Value {
+	unsafeArg, ok := args[2].(Bool)
+	unsafe := bool(unsafeArg)
+	if !ok {
+		return Fail(site.Pos, "Unexpected argument to __index: %s. Expected a bool as the third argument.", args[2])
+	}
+	i, ok := args[1].(Int)
+	if !ok {
+		return Fail(site.Pos, "Unexpected argument to __index: %s. Expected an int as index.", args[1])
+	}
+
+	var elem Value
+	found := false
+	switch v := args[0].(type) {
+	case List:
+		if found = i >= 0 && int(i) < len(v); found {
+			elem = v[i]
+		}
+	case Enum:
+		if found = i >= 0 && int(i) < len(v.Args); found {
+			elem = v.Args[i]
+		}
+	case Str:
+		elem, found = runeAt(v, int(i))
+	default:
+		return Fail(site.Pos, "Unexpected argument to __index: %s. Expected an Iterator.", args[0])
+	}
+
+	switch {
+	case found && unsafe:
+		return elem
+	case found:
+		return toSome(elem)
+	case unsafe:
+		return Fail(site.Pos, "Index %d out of range for %s", i, args[0])
+	default:
+		return toNone()
+	}
+}
@@ -0,0 +1,15 @@
+package rt
+
+import "dghaehre/raja/builtin"
+
+// Keys are compared by value, and entries are kept in insertion order.
+// A Map is never changed after it is created; insert and remove return a new map.
+type Map = builtin.Map[Value]
+
+func NewMap(keys []Value, values []Value) *Map {
+	m := builtin.NewMap[Value](builtinValues{})
+	for i, key := range keys {
+		m.Set(key, values[i])
+	}
+	return m
+}
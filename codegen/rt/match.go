@@ -0,0 +1,118 @@
+package rt
+
+// A pattern in a match branch
+type Pattern interface {
+	// Binds identifiers into sc, and returns whether the value matches
+	match(sc *Scope, v Value, pos string) bool
+}
+
+type bindPattern struct {
+	name string
+}
+
+func (p bindPattern) match(sc *Scope, v Value, pos string) bool {
+	sc.Put(p.name, v, pos)
+	return true
+}
+
+// Matches anything, and binds the value to name
+func PBind(name string) Pattern {
+	return bindPattern{name: name}
+}
+
+type valuePattern struct {
+	value Value
+}
+
+func (p valuePattern) match(sc *Scope, v Value, pos string) bool {
+	return v.Eq(p.value)
+}
+
+// Matches values equal to the given value
+func PValue(v Value) Pattern {
+	return valuePattern{value: v}
+}
+
+type anyPattern struct{}
+
+func (p anyPattern) match(sc *Scope, v Value, pos string) bool {
+	return true
+}
+
+// Matches anything, without binding it
+func PAny() Pattern {
+	return anyPattern{}
+}
+
+type listPattern struct {
+	elems   []Pattern
+	hasRest bool
+	rest    string // name the remaining elements are bound to, empty for .._
+}
+
+func (p listPattern) match(sc *Scope, v Value, pos string) bool {
+	l, ok := v.(List)
+	if !ok || len(l) < len(p.elems) || (!p.hasRest && len(l) != len(p.elems)) {
+		return false
+	}
+	for i, elem := range p.elems {
+		if !elem.match(sc, l[i], pos) {
+			return false
+		}
+	}
+	if p.rest != "" {
+		remaining := make(List, len(l)-len(p.elems))
+		copy(remaining, l[len(p.elems):])
+		sc.Put(p.rest, remaining, pos)
+	}
+	return true
+}
+
+// Matches a list of the same length
+func PList(elems ...Pattern) Pattern {
+	return listPattern{elems: elems}
+}
+
+// Matches a list with at least as many elements: [head, ..tail]
+func PListRest(rest string, elems ...Pattern) Pattern {
+	return listPattern{elems: elems, hasRest: true, rest: rest}
+}
+
+type enumPattern struct {
+	parent string
+	name   string
+	args   []Pattern
+}
+
+func (p enumPattern) match(sc *Scope, v Value, pos string) bool {
+	e, ok := v.(Enum)
+	if !ok || e.Parent != p.parent || e.Name != p.name || len(e.Args) != len(p.args) {
+		return false
+	}
+	for i, arg := range p.args {
+		if !arg.match(sc, e.Args[i], pos) {
+			return false
+		}
+	}
+	return true
+}
+
+func PEnum(parent string, name string, args ...Pattern) Pattern {
+	return enumPattern{parent: parent, name: name, args: args}
+}
+
+// Returns the scope for the body of the branch, if the value matches the pattern
+func Match(sc *Scope, v Value, p Pattern, pos string) (*Scope, bool) {
+	bodyScope := NewScope(sc)
+	return bodyScope, p.match(bodyScope, v, pos)
+}
+
+// Evaluates the guard of a branch in the scope of the branch body
+func Guard(bodyScope *Scope, guard func(*Scope) Value, pos string) bool {
+	v := guard(bodyScope)
+	passed, ok := v.(Bool)
+	if !ok {
+		Fail(pos, "Match guard must be a bool, got %s", v)
+	}
+	return bool(passed)
+}
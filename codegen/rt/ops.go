@@ -0,0 +1,240 @@
+package rt
+
+import (
+	"math"
+	"math/big"
+)
+
+func incompatible(op string, left, right Value, pos string) Value {
+	return Fail(pos, "Cannot %s incompatible values %s, %s", op, left, right)
+}
+
+func floatOp(op string, left, right Float, pos string) Value {
+	switch op {
+	case "-":
+		return left - right
+	case "+":
+		return left + right
+	case "*":
+		return left * right
+	case "/":
+		return Float(FloatDiv(float64(left), float64(right), pos))
+	case "%":
+		return Float(FloatMod(float64(left), float64(right), pos))
+	case ">":
+		return Bool(left > right)
+	case "<":
+		return Bool(left < right)
+	case ">=":
+		return Bool(left >= right)
+	case "<=":
+		return Bool(left <= right)
+	case "!=":
+		return Bool(left != right)
+	}
+	return incompatible(op, left, right, pos)
+}
+
+// Division of floats, as generated for floats the typechecker knows of
+func FloatDiv(left, right float64, pos string) float64 {
+	if right == 0 {
+		Fail(pos, "Division by zero")
+	}
+	return left / right
+}
+
+func FloatMod(left, right float64, pos string) float64 {
+	if right == 0 {
+		Fail(pos, "Division by zero")
+	}
+	return math.Mod(left, right)
+}
+
+// The Go value of a value the typechecker knows to be a Bool
+func AsBool(v Value, pos string) bool {
+	b, ok := v.(Bool)
+	if !ok {
+		Fail(pos, "Expected a Bool, got %s", v)
+	}
+	return bool(b)
+}
+
+func AsFloat(v Value, pos string) float64 {
+	f, ok := v.(Float)
+	if !ok {
+		Fail(pos, "Expected a Float, got %s", v)
+	}
+	return float64(f)
+}
+
+func AsStr(v Value, pos string) string {
+	s, ok := v.(Str)
+	if !ok {
+		Fail(pos, "Expected a Str, got %s", v)
+	}
+	return string(s)
+}
+
+func intOp(op string, left, right Int, pos string) Value {
+	if intOverflows(op, left, right) {
+		return bigOp(op, big.NewInt(int64(left)), big.NewInt(int64(right)), pos)
+	}
+	switch op {
+	case "-":
+		return left - right
+	case "+":
+		return left + right
+	case "*":
+		return left * right
+	case "/":
+		if right == 0 {
+			return Fail(pos, "Division by zero")
+		}
+		return left / right
+	case "%":
+		if right == 0 {
+			return Fail(pos, "Division by zero")
+		}
+		return left % right
+	case ">":
+		return Bool(left > right)
+	case "<":
+		return Bool(left < right)
+	case ">=":
+		return Bool(left >= right)
+	case "<=":
+		return Bool(left <= right)
+	case "!=":
+		return Bool(left != right)
+	}
+	return incompatible(op, left, right, pos)
+}
+
+// Evaluates && or ||, calling right only when left does not decide the result
+func Logical(op string, left Value, right func() Value, pos string) Value {
+	for _, side := range []func() Value{func() Value { return left }, right} {
+		v := side()
+		b, ok := v.(Bool)
+		if !ok {
+			return Fail(pos, "Cannot %s non-bool value %s", op, v)
+		}
+		if bool(b) == (op == "||") {
+			return b
+		}
+	}
+	return Bool(op == "&&")
+}
+
+// Evaluates the prefix operator op, ! or -
+func Unary(op string, operand Value, pos string) Value {
+	switch v := operand.(type) {
+	case Bool:
+		if op == "!" {
+			return !v
+		}
+	case Int:
+		if op == "-" {
+			if v == math.MinInt64 {
+				return NewBigInt(new(big.Int).Neg(big.NewInt(int64(v))))
+			}
+			return -v
+		}
+	case BigInt:
+		if op == "-" {
+			return NewBigInt(new(big.Int).Neg(v.value))
+		}
+	case Float:
+		if op == "-" {
+			return -v
+		}
+	}
+	return Fail(pos, "Unary operator %s is not defined for value %s", op, operand)
+}
+
+// Evaluates the binary operator op, as the interpreter does
+func Binary(op string, left, right Value, pos string) Value {
+	if op == "==" {
+		return Bool(left.Eq(right))
+	}
+	switch l := left.(type) {
+	case List:
+		r, ok := right.(List)
+		if !ok {
+			switch right.(type) {
+			case Int, Float, Str, Char:
+				r = List{right}
+			default:
+				return incompatible(op, left, right, pos)
+			}
+		}
+		if op != "++" {
+			return incompatible(op, left, right, pos)
+		}
+		joined := make(List, 0, len(l)+len(r))
+		return append(append(joined, l...), r...)
+	case Float:
+		switch r := right.(type) {
+		case Float:
+			return floatOp(op, l, r, pos)
+		case Int:
+			return floatOp(op, l, Float(r), pos)
+		case BigInt:
+			return floatOp(op, l, bigToFloat(r.value), pos)
+		}
+		return incompatible(op, left, right, pos)
+	case Int:
+		switch r := right.(type) {
+		case Int:
+			return intOp(op, l, r, pos)
+		case BigInt:
+			return bigOp(op, big.NewInt(int64(l)), r.value, pos)
+		case Float:
+			return floatOp(op, Float(l), r, pos)
+		}
+		return incompatible(op, left, right, pos)
+	case BigInt:
+		switch r := right.(type) {
+		case Int:
+			return bigOp(op, l.value, big.NewInt(int64(r)), pos)
+		case BigInt:
+			return bigOp(op, l.value, r.value, pos)
+		case Float:
+			return floatOp(op, bigToFloat(l.value), r, pos)
+		}
+		return incompatible(op, left, right, pos)
+	case Str:
+		r, ok := right.(Str)
+		if char, isChar := right.(Char); isChar && op == "++" {
+			r, ok = Str(string(char)), true
+		}
+		if !ok {
+			return incompatible(op, left, right, pos)
+		}
+		switch op {
+		case "++":
+			return l + r
+		case "!=":
+			return Bool(l != r)
+		}
+		return incompatible(op, left, right, pos)
+	case Char:
+		r, ok := right.(Char)
+		if !ok {
+			return incompatible(op, left, right, pos)
+		}
+		switch op {
+		case ">":
+			return Bool(l > r)
+		case "<":
+			return Bool(l < r)
+		case ">=":
+			return Bool(l >= r)
+		case "<=":
+			return Bool(l <= r)
+		case "!=":
+			return Bool(l != r)
+		}
+		return incompatible(op, left, right, pos)
+	}
+	return Fail(pos, "Binary operator %s is not defined for values %s, %s", op, left, right)
+}
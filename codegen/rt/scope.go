@@ -0,0 +1,421 @@
+package rt
+
+import (
+	"dghaehre/raja/builtin"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Raised with panic, and recovered in Run
+type Error struct {
+	Reason string
+	Pos    string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("Runtime error at %s:\n\n%s\n", e.Pos, e.Reason)
+}
+
+func Fail(pos string, format string, args ...any) Value {
+	panic(&Error{
+		Reason: fmt.Sprintf(format, args...),
+		Pos:    pos,
+	})
+}
+
+// Raised with panic by exit and panic, and recovered in Run, or in try for a panic
+type Exit = builtin.Exit
+
+type Scope struct {
+	parent *Scope
+	vars   map[string]Value
+}
+
+func NewScope(parent *Scope) *Scope {
+	return &Scope{
+		parent: parent,
+		vars:   map[string]Value{},
+	}
+}
+
+// A copy of the variables of sc, without its parent
+func (sc *Scope) snapshot() *Scope {
+	copied := NewScope(nil)
+	for name, v := range sc.vars {
+		copied.vars[name] = v
+	}
+	return copied
+}
+
+func isMutable(name string) bool {
+	return strings.HasPrefix(name, "mut_")
+}
+
+// Put variable into scope, and return it
+func (sc *Scope) Put(name string, v Value, pos string) Value {
+	if fn, ok := v.(*Fn); ok {
+		existing, ok := sc.vars[name]
+		if !ok {
+			sc.vars[name] = Fns{Values: []*Fn{fn}}
+			return v
+		}
+		fns, ok := existing.(Fns)
+		if !ok {
+			return Fail(pos, "Should never happen. expected fnValue, got %s.", existing)
+		}
+		fns.Values = append(fns.Values, fn)
+		sc.vars[name] = fns
+		return v
+	}
+	if _, exist := sc.vars[name]; exist {
+		if isMutable(name) {
+			return Fail(pos, "To update a variable, use the update function.\nExample: %s.update(%s)", name, v)
+		}
+		return Fail(pos, "%s is not mutable.\nTry renaming the variable to mut_%s and use the update function\nExample: %s.update(%s)", name, name, name, name)
+	}
+	sc.vars[name] = v
+	return v
+}
+
+func (sc *Scope) lookup(name string) (Value, bool) {
+	for s := sc; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func (sc *Scope) Get(name string, pos string) Value {
+	v, ok := sc.lookup(name)
+	if !ok {
+		return Fail(pos, "%s is undefined", name)
+	}
+	return v
+}
+
+func (sc *Scope) update(name string, v Value, pos string) {
+	for s := sc; s != nil; s = s.parent {
+		if _, exist := s.vars[name]; exist {
+			if !isMutable(name) {
+				Fail(pos, "%s is not mutable.\nTry renaming the variable to mut_%s", name, name)
+			}
+			s.vars[name] = v
+			return
+		}
+	}
+	Fail(pos, "Cannot find variable %s to update.\nMake sure you have already created the variable before calling update", name)
+}
+
+// Where a function is called from
+type CallSite struct {
+	Name     string // name of the function, empty if it is not called by name
+	FirstArg string // source of the first argument, used by update
+	Dot      bool   // called as a.f(b), which is f(a, b)
+	Pos      string
+}
+
+// A call in tail position, evaluated by the trampoline in Call
+type tailCall struct {
+	site CallSite
+	fn   Value
+	args []Value
+}
+
+func (t *tailCall) String() string {
+	return fmt.Sprintf("<tail call %s>", t.site.Name)
+}
+
+func (t *tailCall) Eq(u Value) bool {
+	return false
+}
+
+// A function with a return type, waiting for the value of a call
+type returnCheck struct {
+	site CallSite
+	fn   *Fn
+}
+
+// Calls fn with args.
+func Call(site CallSite, fn Value, args []Value) Value {
+	// A tail call hands its value to every function before it
+	var checks []returnCheck
+	for {
+		v, called := call(site, fn, args)
+		if called != nil && called.Return != nil && !hasReturnCheck(checks, called) {
+			checks = append(checks, returnCheck{site: site, fn: called})
+		}
+		tc, ok := v.(*tailCall)
+		if !ok {
+			for _, check := range checks {
+				checkReturn(check, v)
+			}
+			return v
+		}
+		site, fn, args = tc.site, tc.fn, tc.args
+	}
+}
+
+func hasReturnCheck(checks []returnCheck, f *Fn) bool {
+	for _, check := range checks {
+		if check.fn == f {
+			return true
+		}
+	}
+	return false
+}
+
+func checkReturn(check returnCheck, v Value) {
+	if !resolveType(check.fn.Scope, *check.fn.Return, check.site.Pos).Eq(v) {
+		Fail(check.site.Pos, "Function %s returned %s, which is not %s as annotated.", check.site.Name, v, check.fn.Return)
+	}
+}
+
+// Calls the function named site.Name in scope.
+// A call on a module, mod.fn(a), looks up fn in the module instead.
+func CallNamed(sc *Scope, site CallSite, args []Value) Value {
+	site, fn, args := resolve(sc, site, args)
+	return Call(site, fn, args)
+}
+
+// Used for calls in tail position. The call is evaluated by the caller of the current function.
+func TailCall(site CallSite, fn Value, args []Value) Value {
+	return &tailCall{site: site, fn: fn, args: args}
+}
+
+// Used for calls by name in tail position. The call is evaluated by the caller of the current function.
+func TailCallNamed(sc *Scope, site CallSite, args []Value) Value {
+	site, fn, args := resolve(sc, site, args)
+	return TailCall(site, fn, args)
+}
+
+func resolve(sc *Scope, site CallSite, args []Value) (CallSite, Value, []Value) {
+	if site.Dot && len(args) > 0 {
+		if module, ok := args[0].(*Module); ok {
+			fn, ok := module.Scope.vars[site.Name]
+			if !ok {
+				Fail(site.Pos, "%s is not defined in module %s", site.Name, module.Name)
+			}
+			site.FirstArg = ""
+			return site, fn, args[1:]
+		}
+	}
+	return site, sc.Get(site.Name, site.Pos), args
+}
+
+// A top-level binding of a module that is not called: math.pi
+func Member(module Value, name string, pos string) Value {
+	m, ok := module.(*Module)
+	if !ok {
+		return Fail(pos, "Cannot get %s from %s, which is not a module", name, module)
+	}
+	v, ok := m.Scope.vars[name]
+	if !ok {
+		return Fail(pos, "%s is not defined in module %s", name, m.Name)
+	}
+	return v
+}
+
+// Also gives back the raja function that was called, which is nil for builtins
+func call(site CallSite, fn Value, args []Value) (Value, *Fn) {
+	switch f := fn.(type) {
+	case Builtin:
+		return f.Call(global, site, args), nil
+	case Fns:
+		called := dispatch(site, f, args)
+		return invoke(site, called, args), called
+	case Alias, BuiltinAlias:
+		// A type given its type parameters, like List(Int) in an alias
+		return instantiate(site.Pos, f, args), nil
+	case *Fn:
+		if len(f.Params) != len(args) {
+			return Fail(site.Pos, "%s expected %d arguments, got %d", f, len(f.Params), len(args)), nil
+		}
+		return invoke(site, f, args), f
+	default:
+		return Fail(site.Pos, "Cannot call function from %s.", fn), nil
+	}
+}
+
+// The body is evaluated in tail position, so it might return a tailCall
+func invoke(site CallSite, f *Fn, args []Value) Value {
+	fnScope := NewScope(f.Scope)
+	for i, p := range f.Params {
+		if p.Name != "" {
+			fnScope.Put(p.Name, args[i], site.Pos)
+		}
+	}
+	return f.Body(fnScope)
+}
+
+// Counts every type given to the parameters, including type parameters.
+// Type variables match anything, and are not counted.
+func aliasCount(f *Fn) int {
+	n := 0
+	for _, p := range f.Params {
+		if p.Alias != "" {
+			n += typeCount(Type{Name: p.Alias, Params: p.Params})
+		}
+	}
+	return n
+}
+
+func typeCount(t Type) int {
+	if isTypeVariable(t.Name) {
+		return 0
+	}
+	n := 1
+	for _, param := range t.Params {
+		n += typeCount(param)
+	}
+	return n
+}
+
+// Type variables are single lowercase letters, like a in List(a). They are never looked up, and match anything.
+func isTypeVariable(name string) bool {
+	r, size := utf8.DecodeRuneInString(name)
+	return size == len(name) && unicode.IsLower(r)
+}
+
+// Looks up the alias of a parameter, and gives it its type parameters
+func resolveType(sc *Scope, t Type, pos string) Value {
+	if isTypeVariable(t.Name) {
+		if len(t.Params) > 0 {
+			return Fail(pos, "Type variable %s cannot take type parameters", t.Name)
+		}
+		return Underscore{}
+	}
+	if _, ok := sc.lookup(t.Name); !ok {
+		if r, _ := utf8.DecodeRuneInString(t.Name); unicode.IsLower(r) {
+			return Fail(pos, "Unknown type %s. Type variables are single lowercase letters, like a in List(a)", t.Name)
+		}
+	}
+	alias := sc.Get(t.Name, pos)
+	if len(t.Params) == 0 {
+		return alias
+	}
+	params := make([]Value, len(t.Params))
+	for i, param := range t.Params {
+		params[i] = resolveType(sc, param, pos)
+	}
+	return instantiate(pos, alias, params)
+}
+
+func allUnderscore(values []Value) bool {
+	for _, v := range values {
+		if _, ok := v.(Underscore); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Gives an alias its type parameters: Maybe(Int) or List(Str)
+func instantiate(pos string, alias Value, params []Value) Value {
+	switch a := alias.(type) {
+	case BuiltinAlias:
+		return a.instantiate(pos, params)
+	case Alias:
+		if len(a.Params) != len(params) {
+			return Fail(pos, "%s takes %d type parameter(s), got %d", a.Name, len(a.Params), len(params))
+		}
+		// The targets were evaluated with every type parameter bound to _
+		if allUnderscore(params) {
+			return a
+		}
+		aliasScope := NewScope(a.Scope)
+		for i, name := range a.Params {
+			aliasScope.vars[name] = params[i]
+		}
+		return Alias{Name: a.Name, Targets: a.evalTargets(aliasScope)}
+	default:
+		return Fail(pos, "%s is not a type, and cannot be given type parameters", alias)
+	}
+}
+
+// Find the most specific function that matches the arguments
+func dispatch(site CallSite, fns Fns, args []Value) *Fn {
+	relevant := []*Fn{}
+	for _, f := range fns.Values {
+		if len(f.Params) != len(args) {
+			continue
+		}
+		matches := true
+		for i, p := range f.Params {
+			if p.Alias == "" {
+				continue
+			}
+			if !resolveType(f.Scope, Type{Name: p.Alias, Params: p.Params}, site.Pos).Eq(args[i]) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			relevant = append(relevant, f)
+		}
+	}
+	if len(relevant) == 0 {
+		Fail(site.Pos, "Cannot call function %s with the supplied args.\nThere are %d function(s) named %s in scope, but none matched the parameters used.", site.Name, len(fns.Values), site.Name)
+	}
+	sort.SliceStable(relevant, func(i, j int) bool {
+		return aliasCount(relevant[i]) > aliasCount(relevant[j])
+	})
+	return relevant[0]
+}
+
+// The global scope, holding builtins, the base lib and the program
+var global *Scope
+
+// Builtins and the base lib, which is all that modules see of the global scope
+var prelude *Scope
+
+var modules = map[string]*Module{}
+
+// Evaluates a module once, and binds it to name in sc if name is given.
+func Import(sc *Scope, key string, moduleName string, name string, pos string, load func(*Scope)) Value {
+	module, ok := modules[key]
+	if !ok {
+		module = &Module{
+			Name:  moduleName,
+			Scope: NewScope(prelude),
+		}
+		load(module.Scope)
+		modules[key] = module
+	}
+	if name != "" {
+		if existing, ok := sc.vars[name]; !ok || !existing.Eq(module) {
+			sc.Put(name, module, pos)
+		}
+	}
+	return module
+}
+
+// Runs the base lib and then the program in the global scope.
+// Runtime errors and panics are printed, like the interpreter does.
+func Run(base func(*Scope), program func(*Scope)) {
+	global = NewScope(nil)
+	loadBuiltins(global)
+	defer func() {
+		if r := recover(); r != nil {
+			switch err := r.(type) {
+			case *Error:
+				fmt.Fprintln(os.Stdout, err.Error())
+				os.Exit(1)
+			case *Exit:
+				if err.Panic {
+					fmt.Fprintln(os.Stdout, "Panic: "+err.Message)
+				}
+				os.Exit(err.Code)
+			default:
+				panic(r)
+			}
+		}
+	}()
+	base(global)
+	prelude = global.snapshot()
+	program(global)
+}
@@ -0,0 +1,51 @@
+package rt
+
+import "unicode/utf8"
+
+// Where the last lookup of a character in a Str ended up. Walking a Str by
+// index, as fold and take do through get, would otherwise decode it from
+// the start for every character.
+var cursor struct {
+	s      Str
+	ascii  bool
+	index  int // in runes
+	offset int // in bytes
+}
+
+// The i-th character of s, counted in runes and not bytes
+func runeAt(s Str, i int) (Value, bool) {
+	if i < 0 {
+		return nil, false
+	}
+	if cursor.s != s {
+		cursor.s, cursor.ascii = s, isASCII(string(s))
+		cursor.index, cursor.offset = 0, 0
+	}
+	if cursor.ascii {
+		if i >= len(s) {
+			return nil, false
+		}
+		return s[i : i+1], true
+	}
+	if i < cursor.index {
+		cursor.index, cursor.offset = 0, 0
+	}
+	for cursor.offset < len(s) {
+		_, size := utf8.DecodeRuneInString(string(s[cursor.offset:]))
+		if cursor.index == i {
+			return s[cursor.offset : cursor.offset+size], true
+		}
+		cursor.index++
+		cursor.offset += size
+	}
+	return nil, false
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
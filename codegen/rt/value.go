@@ -0,0 +1,348 @@
+// Package rt is the runtime linked into programs built with raja --build.
+//
+// It mirrors the values and semantics of the eval package, and shares its builtins
+// through the builtin package. It only depends on that and the standard library,
+// as their source is copied next to the generated Go code when building.
+package rt
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type Value interface {
+	String() string
+	Eq(Value) bool
+}
+
+type Int int64
+
+func (v Int) String() string {
+	return strconv.FormatInt(int64(v), 10)
+}
+
+func (v Int) Eq(u Value) bool {
+	switch w := u.(type) {
+	case Underscore:
+		return true
+	case Int:
+		return v == w
+	case Float:
+		return Float(v) == w
+	case BigInt:
+		return w.Eq(v)
+	}
+	return false
+}
+
+type Float float64
+
+func (v Float) String() string {
+	return strconv.FormatFloat(float64(v), 'g', -1, 64)
+}
+
+func (v Float) Eq(u Value) bool {
+	switch w := u.(type) {
+	case Underscore:
+		return true
+	case Float:
+		return v == w
+	case Int:
+		return v == Float(w)
+	case BigInt:
+		return w.Eq(v)
+	}
+	return false
+}
+
+type Bool bool
+
+func (v Bool) String() string {
+	if v {
+		return "true"
+	}
+	return "false"
+}
+
+func (v Bool) Eq(u Value) bool {
+	switch w := u.(type) {
+	case Underscore:
+		return true
+	case Bool:
+		return v == w
+	}
+	return false
+}
+
+type Str string
+
+func (v Str) String() string {
+	return string(v)
+}
+
+func (v Str) Eq(u Value) bool {
+	switch w := u.(type) {
+	case Underscore:
+		return true
+	case Str:
+		return v == w
+	}
+	return false
+}
+
+// A single unicode character, as given by chr
+type Char rune
+
+func (v Char) String() string {
+	return string(v)
+}
+
+func (v Char) Eq(u Value) bool {
+	switch w := u.(type) {
+	case Underscore:
+		return true
+	case Char:
+		return v == w
+	}
+	return false
+}
+
+type List []Value
+
+func (v List) String() string {
+	stringValues := make([]string, len(v))
+	for i, s := range v {
+		stringValues[i] = s.String()
+	}
+	return fmt.Sprintf("[%s]", strings.Join(stringValues, ", "))
+}
+
+func (v List) Eq(u Value) bool {
+	switch w := u.(type) {
+	case Underscore:
+		return true
+	case List:
+		if len(v) != len(w) {
+			return false
+		}
+		for i := range v {
+			if !v[i].Eq(w[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+type Enum struct {
+	Parent string
+	Name   string
+	Args   []Value
+}
+
+func (e Enum) String() string {
+	n := fmt.Sprintf("%s::%s", e.Parent, e.Name)
+	if len(e.Args) == 0 {
+		return n
+	}
+	stringValues := make([]string, len(e.Args))
+	for i, s := range e.Args {
+		stringValues[i] = s.String()
+	}
+	return n + "(" + strings.Join(stringValues, ", ") + ")"
+}
+
+func (e Enum) Eq(u Value) bool {
+	switch w := u.(type) {
+	case Underscore:
+		return true
+	case Enum:
+		if e.Parent != w.Parent || e.Name != w.Name || len(e.Args) != len(w.Args) {
+			return false
+		}
+		for i := range e.Args {
+			if !e.Args[i].Eq(w.Args[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+type Underscore struct{}
+
+func (v Underscore) String() string {
+	return "_"
+}
+
+func (v Underscore) Eq(u Value) bool {
+	return true
+}
+
+type Alias struct {
+	Name    string
+	Targets []Value
+
+	// Type parameters, bound to _ in Targets until the alias is given parameters
+	Params      []string
+	Scope       *Scope
+	evalTargets func(*Scope) []Value
+}
+
+// Evaluates the targets with the type parameters bound to _
+func NewAlias(sc *Scope, name string, params []string, targets func(*Scope) []Value) Alias {
+	aliasScope := NewScope(sc)
+	for _, param := range params {
+		aliasScope.vars[param] = Underscore{}
+	}
+	return Alias{
+		Name:        name,
+		Targets:     targets(aliasScope),
+		Params:      params,
+		Scope:       sc,
+		evalTargets: targets,
+	}
+}
+
+func (a Alias) String() string {
+	stringValues := make([]string, len(a.Targets))
+	for i, s := range a.Targets {
+		stringValues[i] = s.String()
+	}
+	return fmt.Sprintf("alias = %s", strings.Join(stringValues, " | "))
+}
+
+func (a Alias) Eq(u Value) bool {
+	if _, ok := u.(Underscore); ok {
+		return true
+	}
+	for _, t := range a.Targets {
+		if t.Eq(u) {
+			return true
+		}
+	}
+	return false
+}
+
+type BuiltinAlias struct {
+	Name  string
+	Match func(Value) bool
+}
+
+func (a BuiltinAlias) String() string {
+	return "alias = " + a.Name
+}
+
+func (a BuiltinAlias) Eq(u Value) bool {
+	return a.Match(u)
+}
+
+// A type used in a parameter: Int, List(Int) or Maybe(a)
+type Type struct {
+	Name   string
+	Params []Type
+}
+
+func (t *Type) String() string {
+	if len(t.Params) == 0 {
+		return t.Name
+	}
+	params := make([]string, len(t.Params))
+	for i := range t.Params {
+		params[i] = t.Params[i].String()
+	}
+	return t.Name + "(" + strings.Join(params, ", ") + ")"
+}
+
+type Param struct {
+	Name   string
+	Alias  string
+	Params []Type // the type parameters given to Alias
+}
+
+type Fn struct {
+	Params []Param
+	Return *Type // optional, the annotated return type
+	Scope  *Scope
+	Body   func(*Scope) Value
+	Source string // the raja source of the function, used when printing it
+}
+
+func NewFn(sc *Scope, params []Param, ret *Type, source string, body func(*Scope) Value) *Fn {
+	return &Fn{
+		Params: params,
+		Return: ret,
+		Scope:  sc,
+		Body:   body,
+		Source: source,
+	}
+}
+
+func (f *Fn) String() string {
+	return f.Source
+}
+
+func (f *Fn) Eq(u Value) bool {
+	switch w := u.(type) {
+	case Underscore:
+		return true
+	case *Fn:
+		return f == w
+	}
+	return false
+}
+
+// Multiple functions with the same name (multiple dispatch)
+type Fns struct {
+	Values []*Fn
+}
+
+func (v Fns) String() string {
+	stringValues := make([]string, len(v.Values))
+	for i, s := range v.Values {
+		stringValues[i] = s.String()
+	}
+	return strings.Join(stringValues, ", ")
+}
+
+func (v Fns) Eq(u Value) bool {
+	return false
+}
+
+type Builtin struct {
+	Name string
+	Call func(sc *Scope, site CallSite, args []Value) Value
+}
+
+func (b Builtin) String() string {
+	return fmt.Sprintf("<native function %s>", b.Name)
+}
+
+func (b Builtin) Eq(u Value) bool {
+	if w, ok := u.(Builtin); ok {
+		return b.Name == w.Name
+	}
+	return false
+}
+
+type Module struct {
+	Name  string
+	Scope *Scope
+}
+
+func (m *Module) String() string {
+	return fmt.Sprintf("<module %s>", m.Name)
+}
+
+func (m *Module) Eq(u Value) bool {
+	switch w := u.(type) {
+	case Underscore:
+		return true
+	case *Module:
+		return m.Name == w.Name
+	}
+	return false
+}
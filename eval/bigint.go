@@ -0,0 +1,117 @@
+package eval
+
+import (
+	"dghaehre/raja/ast"
+	"math"
+	"math/big"
+)
+
+// An Int too large for int64. Int operations that overflow give a BigIntValue
+// instead of wrapping around, and a BigIntValue that fits in int64 is always
+// turned back into an IntValue, so the two never hold the same number.
+type BigIntValue struct {
+	value *big.Int
+}
+
+// The Int holding n, as an IntValue when it fits
+func NewBigIntValue(n *big.Int) Value {
+	if n.IsInt64() {
+		return IntValue(n.Int64())
+	}
+	return BigIntValue{value: n}
+}
+
+// The number, which must not be modified
+func (v BigIntValue) Int() *big.Int {
+	return v.value
+}
+
+func (v BigIntValue) String() string {
+	return v.value.String()
+}
+
+func (v BigIntValue) Eq(u Value) bool {
+	if _, ok := u.(UnderscoreValue); ok {
+		return true
+	}
+	if w, ok := toBig(u); ok {
+		return v.value.Cmp(w) == 0
+	}
+	if w, ok := u.(FloatValue); ok {
+		return bigToFloat(v.value) == w
+	}
+	return false
+}
+
+// Int values, small or big, as a big.Int
+func toBig(v Value) (*big.Int, bool) {
+	switch n := v.(type) {
+	case IntValue:
+		return big.NewInt(int64(n)), true
+	case BigIntValue:
+		return n.value, true
+	}
+	return nil, false
+}
+
+func bigToFloat(n *big.Int) FloatValue {
+	f, _ := new(big.Float).SetInt(n).Float64()
+	return FloatValue(f)
+}
+
+// Whether left op right overflows int64, for the operators that can
+func intOverflows(op ast.TokKind, left IntValue, right IntValue) bool {
+	switch op {
+	case ast.Plus:
+		sum := left + right
+		return (left > 0 && right > 0 && sum < 0) || (left < 0 && right < 0 && sum >= 0)
+	case ast.Minus:
+		diff := left - right
+		return (left >= 0 && right < 0 && diff < 0) || (left < 0 && right > 0 && diff >= 0)
+	case ast.Times:
+		if left == 0 || right == 0 {
+			return false
+		}
+		product := left * right
+		return product/right != left || (left == -1 && right == math.MinInt64) || (right == -1 && left == math.MinInt64)
+	case ast.Divide:
+		return left == math.MinInt64 && right == -1
+	}
+	return false
+}
+
+func bigBinaryOp(op ast.TokKind, left *big.Int, right *big.Int) (Value, *RuntimeError) {
+	switch op {
+	case ast.Minus:
+		return NewBigIntValue(new(big.Int).Sub(left, right)), nil
+	case ast.Plus:
+		return NewBigIntValue(new(big.Int).Add(left, right)), nil
+	case ast.Times:
+		return NewBigIntValue(new(big.Int).Mul(left, right)), nil
+	case ast.Divide:
+		if right.Sign() == 0 {
+			return nil, divisionByZeroErr()
+		}
+		// Quo and Rem truncate, as / and % do for IntValue
+		return NewBigIntValue(new(big.Int).Quo(left, right)), nil
+	case ast.Modulus:
+		if right.Sign() == 0 {
+			return nil, divisionByZeroErr()
+		}
+		return NewBigIntValue(new(big.Int).Rem(left, right)), nil
+	case ast.Greater:
+		return BoolValue(left.Cmp(right) > 0), nil
+	case ast.Less:
+		return BoolValue(left.Cmp(right) < 0), nil
+	case ast.Geq:
+		return BoolValue(left.Cmp(right) >= 0), nil
+	case ast.Leq:
+		return BoolValue(left.Cmp(right) <= 0), nil
+	case ast.Eq:
+		return BoolValue(left.Cmp(right) == 0), nil
+	case ast.Neq:
+		return BoolValue(left.Cmp(right) != 0), nil
+	default:
+		return nil, incompatibleError(op, NewBigIntValue(left), NewBigIntValue(right), ast.Pos{})
+	}
+}
@@ -0,0 +1,420 @@
+package eval
+
+// What a Go program needs to embed raja: converting between Go and raja values,
+// setting globals, and calling raja functions.
+//
+//	c := eval.NewContext()
+//	c.LoadBuiltins()
+//	c.LoadFunc("__greeting", greeting)
+//	c.Set("config", config)
+//	c.Eval(file, "script.raja")
+//	v, err := c.Call("main", os.Args[1:])
+
+import (
+	"dghaehre/raja/ast"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+	"sort"
+)
+
+// Maybe::Some(a), Result::Err(e) and the other enum values
+func NewEnumValue(parent string, name string, args ...Value) EnumValue {
+	if args == nil {
+		args = []Value{}
+	}
+	return EnumValue{
+		parent: parent,
+		name:   name,
+		args:   args,
+	}
+}
+
+// Maybe in Maybe::Some(a)
+func (e EnumValue) Parent() string {
+	return e.parent
+}
+
+// Some in Maybe::Some(a)
+func (e EnumValue) Name() string {
+	return e.name
+}
+
+// a in Maybe::Some(a)
+func (e EnumValue) Args() []Value {
+	return e.args
+}
+
+// Converts a Go value to a raja value:
+//   - ints, uints and floats to Int and Float, and *big.Int to Int
+//   - strings and bools to Str and Bool
+//   - slices and arrays to List
+//   - maps to Map, with the keys sorted
+//   - structs to Map, with the exported field names as keys, or the name from a `raja:"name"` tag
+//
+// Pointers and interfaces are followed, and a Value is returned as it is.
+func ToValue(v any) (Value, error) {
+	return toValue(reflect.ValueOf(v))
+}
+
+func toValue(rv reflect.Value) (Value, error) {
+	if !rv.IsValid() {
+		return nil, fmt.Errorf("cannot convert nil to a raja value")
+	}
+	if rv.CanInterface() {
+		switch v := rv.Interface().(type) {
+		case Value:
+			return v, nil
+		case *big.Int:
+			if v == nil {
+				return nil, fmt.Errorf("cannot convert nil to a raja value")
+			}
+			return NewBigIntValue(new(big.Int).Set(v)), nil
+		}
+	}
+	switch rv.Kind() {
+	case reflect.Bool:
+		return BoolValue(rv.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return IntValue(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d is too large for Int", rv.Uint())
+		}
+		return IntValue(rv.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return FloatValue(rv.Float()), nil
+	case reflect.String:
+		return StringValue(rv.String()), nil
+	case reflect.Slice, reflect.Array:
+		list := make(ListValue, rv.Len())
+		for i := range list {
+			elem, err := toValue(rv.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			list[i] = elem
+		}
+		return &list, nil
+	case reflect.Map:
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i]) < fmt.Sprint(keys[j])
+		})
+		m := NewMapValue()
+		for _, k := range keys {
+			key, err := toValue(k)
+			if err != nil {
+				return nil, err
+			}
+			value, err := toValue(rv.MapIndex(k))
+			if err != nil {
+				return nil, err
+			}
+			m.Set(key, value)
+		}
+		return m, nil
+	case reflect.Struct:
+		m := NewMapValue()
+		for i := 0; i < rv.NumField(); i++ {
+			name, ok := fieldName(rv.Type().Field(i))
+			if !ok {
+				continue
+			}
+			value, err := toValue(rv.Field(i))
+			if err != nil {
+				return nil, err
+			}
+			m.Set(StringValue(name), value)
+		}
+		return m, nil
+	case reflect.Pointer, reflect.Interface:
+		if rv.IsNil() {
+			return nil, fmt.Errorf("cannot convert nil to a raja value")
+		}
+		return toValue(rv.Elem())
+	}
+	return nil, fmt.Errorf("cannot convert %s to a raja value", rv.Type())
+}
+
+// The key used for the struct field in a Map, and if the field is used at all
+func fieldName(field reflect.StructField) (string, bool) {
+	if !field.IsExported() {
+		return "", false
+	}
+	switch tag := field.Tag.Get("raja"); tag {
+	case "-":
+		return "", false
+	case "":
+		return field.Name, true
+	default:
+		return tag, true
+	}
+}
+
+// Converts a raja value to the Go value that target points to, the opposite of ToValue.
+// Int converts to any Go int or float, or a *big.Int, and Map to a Go map or struct.
+//
+// When target is an empty interface, the value gets its natural Go type:
+// int64 or *big.Int, float64, string, rune, bool, []any and map[any]any. Other values, like enums, are kept as a Value.
+func FromValue(v Value, target any) error {
+	rv := reflect.ValueOf(target)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("FromValue needs a non-nil pointer, got %T", target)
+	}
+	return fromValue(v, rv.Elem())
+}
+
+var valueType = reflect.TypeOf((*Value)(nil)).Elem()
+var bigIntType = reflect.TypeOf((*big.Int)(nil))
+
+func mismatch(v Value, rv reflect.Value) error {
+	return fmt.Errorf("cannot convert %s to %s", v, rv.Type())
+}
+
+func fromValue(v Value, rv reflect.Value) error {
+	if rv.Kind() == reflect.Interface {
+		if rv.NumMethod() == 0 {
+			natural, err := naturalValue(v)
+			if err != nil {
+				return err
+			}
+			rv.Set(reflect.ValueOf(natural))
+			return nil
+		}
+		if rv.Type() == valueType {
+			rv.Set(reflect.ValueOf(v))
+			return nil
+		}
+		return mismatch(v, rv)
+	}
+	if rv.Type() == bigIntType {
+		n, ok := toBig(v)
+		if !ok {
+			return mismatch(v, rv)
+		}
+		rv.Set(reflect.ValueOf(new(big.Int).Set(n)))
+		return nil
+	}
+	if reflect.TypeOf(v).AssignableTo(rv.Type()) {
+		rv.Set(reflect.ValueOf(v))
+		return nil
+	}
+
+	switch rv.Kind() {
+	case reflect.Bool:
+		b, ok := v.(BoolValue)
+		if !ok {
+			return mismatch(v, rv)
+		}
+		rv.SetBool(bool(b))
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := v.(IntValue)
+		if !ok || rv.OverflowInt(int64(i)) {
+			return mismatch(v, rv)
+		}
+		rv.SetInt(int64(i))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := v.(IntValue)
+		if !ok || i < 0 || rv.OverflowUint(uint64(i)) {
+			return mismatch(v, rv)
+		}
+		rv.SetUint(uint64(i))
+	case reflect.Float32, reflect.Float64:
+		switch n := v.(type) {
+		case FloatValue:
+			rv.SetFloat(float64(n))
+		case IntValue:
+			rv.SetFloat(float64(n))
+		case BigIntValue:
+			rv.SetFloat(float64(bigToFloat(n.value)))
+		default:
+			return mismatch(v, rv)
+		}
+	case reflect.String:
+		s, ok := v.(StringValue)
+		if !ok {
+			return mismatch(v, rv)
+		}
+		rv.SetString(string(s))
+	case reflect.Slice:
+		list, ok := v.(*ListValue)
+		if !ok {
+			return mismatch(v, rv)
+		}
+		slice := reflect.MakeSlice(rv.Type(), len(*list), len(*list))
+		for i, elem := range *list {
+			if err := fromValue(elem, slice.Index(i)); err != nil {
+				return err
+			}
+		}
+		rv.Set(slice)
+	case reflect.Array:
+		list, ok := v.(*ListValue)
+		if !ok || len(*list) != rv.Len() {
+			return mismatch(v, rv)
+		}
+		for i, elem := range *list {
+			if err := fromValue(elem, rv.Index(i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		m, ok := v.(*MapValue)
+		if !ok {
+			return mismatch(v, rv)
+		}
+		goMap := reflect.MakeMapWithSize(rv.Type(), m.Len())
+		keys, values := m.Entries()
+		for i := range keys {
+			key := reflect.New(rv.Type().Key()).Elem()
+			if err := fromValue(keys[i], key); err != nil {
+				return err
+			}
+			value := reflect.New(rv.Type().Elem()).Elem()
+			if err := fromValue(values[i], value); err != nil {
+				return err
+			}
+			goMap.SetMapIndex(key, value)
+		}
+		rv.Set(goMap)
+	case reflect.Struct:
+		m, ok := v.(*MapValue)
+		if !ok {
+			return mismatch(v, rv)
+		}
+		for i := 0; i < rv.NumField(); i++ {
+			name, ok := fieldName(rv.Type().Field(i))
+			if !ok {
+				continue
+			}
+			if value, ok := m.Get(StringValue(name)); ok {
+				if err := fromValue(value, rv.Field(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case reflect.Pointer:
+		elem := reflect.New(rv.Type().Elem())
+		if err := fromValue(v, elem.Elem()); err != nil {
+			return err
+		}
+		rv.Set(elem)
+	default:
+		return mismatch(v, rv)
+	}
+	return nil
+}
+
+// The Go value a raja value is converted to when the target is an empty interface
+func naturalValue(v Value) (any, error) {
+	switch value := v.(type) {
+	case IntValue:
+		return int64(value), nil
+	case BigIntValue:
+		return new(big.Int).Set(value.value), nil
+	case FloatValue:
+		return float64(value), nil
+	case StringValue:
+		return string(value), nil
+	case CharValue:
+		return rune(value), nil
+	case BoolValue:
+		return bool(value), nil
+	case *ListValue:
+		list := make([]any, len(*value))
+		for i, elem := range *value {
+			natural, err := naturalValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = natural
+		}
+		return list, nil
+	case *MapValue:
+		m := make(map[any]any, value.Len())
+		keys, values := value.Entries()
+		for i := range keys {
+			key, err := naturalValue(keys[i])
+			if err != nil {
+				return nil, err
+			}
+			if !reflect.TypeOf(key).Comparable() {
+				return nil, fmt.Errorf("cannot use %s as a key in a Go map", keys[i])
+			}
+			m[key], err = naturalValue(values[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+	return v, nil
+}
+
+// Sets the global variable, replacing any value it had. The value is converted with ToValue.
+func (c *Context) Set(name string, v any) error {
+	value, err := ToValue(v)
+	if err != nil {
+		return err
+	}
+	c.scope.vars[name] = value
+	return nil
+}
+
+// The global variable, if there is one
+func (c *Context) Get(name string) (Value, bool) {
+	v, ok := c.scope.vars[name]
+	return v, ok
+}
+
+// Calls the global function with the given arguments, converted with ToValue.
+// Which function is called is decided by multiple dispatch, as for a call from raja.
+func (c *Context) Call(name string, args ...any) (Value, error) {
+	tok := ast.Token{Kind: ast.Identifier, Payload: name}
+	call := ast.FnCallNode{
+		Fn:  ast.IdentifierNode{Payload: name, Tok: &tok},
+		Tok: &tok,
+	}
+	fn, err := c.evalExpr(call.Fn, c.scope)
+	if err != nil {
+		return nil, err
+	}
+	return c.call(call, fn, args)
+}
+
+// Calls a function value, like a raja function given as an argument to a builtin.
+// The arguments are converted with ToValue.
+func (c *Context) CallValue(fn Value, args ...any) (Value, error) {
+	tok := ast.Token{Kind: ast.Identifier}
+	if len(c.stack) > 0 {
+		// Called from the builtin that was given fn
+		tok.Pos = c.stack[len(c.stack)-1].Pos
+	}
+	// Shows up as an anonymous function in stack traces
+	call := ast.FnCallNode{
+		Fn:  ast.IdentifierNode{Tok: &tok},
+		Tok: &tok,
+	}
+	return c.call(call, fn, args)
+}
+
+func (c *Context) call(n ast.FnCallNode, fn Value, args []any) (Value, error) {
+	values := make([]Value, len(args))
+	for i, arg := range args {
+		v, err := ToValue(arg)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	v, err := c.callFn(n, fn, values)
+	if err != nil {
+		if err.exit != nil {
+			return nil, err.exit
+		}
+		return nil, err
+	}
+	return v, nil
+}
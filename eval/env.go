@@ -1,17 +1,21 @@
 package eval
 
 import (
+	"bufio"
 	"dghaehre/raja/ast"
+	"dghaehre/raja/builtin"
 	"fmt"
-	"os"
-	"strconv"
+	"math/big"
+	"strings"
 )
 
-type builtinFn func(string, []Value) (Value, *runtimeError)
+// A function implemented in Go. It is given the source of its first argument,
+// which is only used by update, and the evaluated arguments.
+type BuiltinFn func(string, []Value) (Value, *RuntimeError)
 
 type BuiltinFnValue struct {
 	name string
-	fn   builtinFn
+	fn   BuiltinFn
 }
 
 func (v BuiltinFnValue) String() string {
@@ -39,49 +43,148 @@ func (v BuiltinAliasValue) Eq(u Value) bool {
 	return v.eqFn(u)
 }
 
+// The name of a type parameter, as it is written
+func typeName(v Value) string {
+	switch t := v.(type) {
+	case BuiltinAliasValue:
+		return t.name
+	case AliasValue:
+		if t.node != nil {
+			return t.node.Name
+		}
+	}
+	return v.String()
+}
+
+// List(a) and Map(k, v) take type parameters, which every element must match.
+func (v BuiltinAliasValue) instantiate(params []Value, pos ast.Pos) (Value, *RuntimeError) {
+	expected := map[string]int{"List": 1, "Map": 2}[v.name]
+	if expected == 0 {
+		return nil, &RuntimeError{
+			reason: fmt.Sprintf("%s does not take type parameters", v.name),
+			Pos:    pos,
+		}
+	}
+	if len(params) != expected {
+		return nil, &RuntimeError{
+			reason: fmt.Sprintf("%s takes %d type parameter(s), got %d", v.name, expected, len(params)),
+			Pos:    pos,
+		}
+	}
+
+	// Avoid looking at every element when the parameters match anything
+	if allUnderscore(params) {
+		return v, nil
+	}
+	names := make([]string, len(params))
+	for i, param := range params {
+		names[i] = typeName(param)
+	}
+
+	instance := BuiltinAliasValue{name: v.name + "(" + strings.Join(names, ", ") + ")"}
+	switch v.name {
+	case "List":
+		instance.eqFn = func(u Value) bool {
+			list, ok := u.(*ListValue)
+			if !ok {
+				return false
+			}
+			for _, elem := range *list {
+				if !params[0].Eq(elem) {
+					return false
+				}
+			}
+			return true
+		}
+	case "Map":
+		instance.eqFn = func(u Value) bool {
+			m, ok := u.(*MapValue)
+			if !ok {
+				return false
+			}
+			keys, values := m.Entries()
+			for i := range keys {
+				if !params[0].Eq(keys[i]) || !params[1].Eq(values[i]) {
+					return false
+				}
+			}
+			return true
+		}
+	}
+	return instance, nil
+}
+
 func (c *Context) LoadBuiltins() {
 	// Types/Alias
 	c.LoadAlias("Int", c.rajaAliasInt)
+	c.LoadAlias("BigInt", c.rajaAliasBigInt)
 	c.LoadAlias("Float", c.rajaAliasFloat)
 	c.LoadAlias("Str", c.rajaAliasStr)
+	c.LoadAlias("Char", c.rajaAliasChar)
 	c.LoadAlias("List", c.rajaAliasList)
 	c.LoadAlias("Fn", c.rajaAliasFn)
 	c.LoadAlias("Enum", c.rajaAliasEnum)
+	c.LoadAlias("Map", c.rajaAliasMap)
 	// TODO: Bool?
 
-	c.LoadFunc("__print", c.rajaPrint)
+	io := builtin.IO{Stdin: c.bufferedStdin(), Stdout: c.stdout, Stderr: c.stderr, Args: c.args}
+	for _, b := range builtin.All[Value](builtinValues{}, io) {
+		c.loadShared(b)
+	}
 	c.LoadFunc("__index", c.rajaIndex)
-	c.LoadFunc("__string", c.rajaString)
-	c.LoadFunc("__int", c.rajaInt)
-	c.LoadFunc("__args", c.rajaArgs)
-	c.LoadFunc("__exit", c.rajaExit)
-	c.LoadFunc("__read_file", c.rajaReadFile)
-	c.LoadFunc("__length", c.rajaLength)
+	c.LoadFunc("__try", c.rajaTry)
+	c.LoadFunc("__lines", c.rajaLines)
 	c.LoadFunc("update", c.rajaUpdate)
 
 	_, err := c.LoadLib("base")
 	if err != nil {
 		panic(err)
 	}
+	c.prelude = c.scope.snapshot()
 }
 
-func (c *Context) LoadFunc(name string, fn builtinFn) {
-	c.scope.put(name, BuiltinFnValue{
+// Makes fn callable by name from raja. Builtins cannot be overloaded,
+// so they are usually wrapped in a raja function, like the ones in base.raja.
+func (c *Context) LoadFunc(name string, fn BuiltinFn) {
+	c.loadBuiltin(name, BuiltinFnValue{
 		name: name,
 		fn:   fn,
-	}, ast.Pos{})
+	})
+}
+
+// Loads one of the builtins shared with the runtime of built programs
+func (c *Context) loadShared(b builtin.Builtin[Value]) {
+	c.LoadFunc(b.Name, func(_ string, args []Value) (Value, *RuntimeError) {
+		v, err := b.Call(args)
+		if exit, ok := err.(*ExitError); ok {
+			return nil, &RuntimeError{reason: exit.Error(), exit: exit}
+		}
+		if err != nil {
+			return nil, &RuntimeError{reason: err.Error()}
+		}
+		return v, nil
+	})
 }
 
 func (c *Context) LoadAlias(name string, fn aliasFn) {
-	c.scope.put(name, BuiltinAliasValue{
+	c.loadBuiltin(name, BuiltinAliasValue{
 		name: name,
 		eqFn: fn,
-	}, ast.Pos{})
+	})
+}
+
+// Builtins loaded after the base lib, like the ones an embedding program
+// gives, are also seen by modules
+func (c *Context) loadBuiltin(name string, v Value) {
+	c.scope.put(name, v, ast.Pos{})
+	if c.prelude.vars != nil {
+		c.prelude.put(name, v, ast.Pos{})
+	}
 }
 
-func (c *Context) requireArgLen(fnName string, args []Value, count int) *runtimeError {
+func (c *Context) requireArgLen(fnName string, args []Value, count int) *RuntimeError {
 	if len(args) < count {
-		return &runtimeError{
+		return &RuntimeError{
 			reason: fmt.Sprintf("%s requires %d arguments, got %d", fnName, count, len(args)),
 		}
 	}
@@ -120,110 +223,155 @@ func toErr(v Value) Value {
 	}
 }
 
-// Builtin functions
+// How the shared builtins make and take apart Values
+type builtinValues struct{}
 
-func (c *Context) rajaString(_ string, args []Value) (Value, *runtimeError) {
-	if err := c.requireArgLen("__string", args, 1); err != nil {
-		return nil, err
-	}
-	switch arg := args[0].(type) {
-	case *StringValue:
-		return arg, nil
-	default:
-		return StringValue(arg.String()), nil
-	}
+func (builtinValues) Int(n int64) Value {
+	return IntValue(n)
 }
 
-func (c *Context) rajaInt(_ string, args []Value) (Value, *runtimeError) {
-	if err := c.requireArgLen("__int", args, 1); err != nil {
-		return nil, err
-	}
-	switch arg := args[0].(type) {
-	case StringValue:
-		i, err := strconv.Atoi(string(arg))
-		if err != nil {
-			return toErr(StringValue(err.Error())), nil
-		}
-		return toOk(IntValue(i)), nil
-	default:
-		return toErr(StringValue(fmt.Sprintf("Cannot cast %s to int", arg))), nil
-	}
+func (builtinValues) BigInt(n *big.Int) Value {
+	return BigIntValue{value: n}
 }
 
-func (c *Context) rajaPrint(_ string, args []Value) (Value, *runtimeError) {
-	if err := c.requireArgLen("__print", args, 1); err != nil {
-		return nil, err
-	}
+func (builtinValues) Float(f float64) Value {
+	return FloatValue(f)
+}
 
-	outputString, ok := args[0].(StringValue)
-	if !ok {
-		return nil, &runtimeError{
-			reason: fmt.Sprintf("Unexpected argument to print: %s", args[0]),
-		}
-	}
+func (builtinValues) Bool(b bool) Value {
+	return BoolValue(b)
+}
 
-	n, _ := os.Stdout.Write(outputString)
-	return IntValue(n), nil
+func (builtinValues) Str(s string) Value {
+	return StringValue(s)
 }
 
-func (c *Context) rajaLength(_ string, args []Value) (Value, *runtimeError) {
-	if err := c.requireArgLen("__length", args, 1); err != nil {
-		return nil, err
-	}
-	switch arg := args[0].(type) {
-	case StringValue:
-		return IntValue(len(arg)), nil
-	case *ListValue:
-		return IntValue(len(*arg)), nil
-	default:
-		return toErr(StringValue(fmt.Sprintf("Cannot get length of %s", arg))), nil
-	}
+func (builtinValues) Char(r rune) Value {
+	return CharValue(r)
 }
 
-func (c *Context) rajaReadFile(_ string, args []Value) (Value, *runtimeError) {
-	if err := c.requireArgLen("__read_file", args, 1); err != nil {
-		return nil, err
-	}
+func (builtinValues) Map(m *MapValue) Value {
+	return m
+}
 
-	s, ok := args[0].(StringValue)
+func (builtinValues) IsUnderscore(v Value) bool {
+	_, ok := v.(UnderscoreValue)
+	return ok
+}
+
+func (builtinValues) AsMap(v Value) (*MapValue, bool) {
+	m, ok := v.(*MapValue)
+	return m, ok
+}
+
+func (builtinValues) AsBigInt(v Value) (*big.Int, bool) {
+	n, ok := v.(BigIntValue)
+	return n.value, ok
+}
+
+func (builtinValues) List(elems []Value) Value {
+	list := ListValue(elems)
+	return &list
+}
+
+func (builtinValues) Enum(parent string, name string, args []Value) Value {
+	return EnumValue{parent: parent, name: name, args: args}
+}
+
+func (builtinValues) AsInt(v Value) (int64, bool) {
+	n, ok := v.(IntValue)
+	return int64(n), ok
+}
+
+func (builtinValues) AsFloat(v Value) (float64, bool) {
+	f, ok := v.(FloatValue)
+	return float64(f), ok
+}
+
+func (builtinValues) AsBool(v Value) (bool, bool) {
+	b, ok := v.(BoolValue)
+	return bool(b), ok
+}
+
+func (builtinValues) AsStr(v Value) (string, bool) {
+	s, ok := v.(StringValue)
+	return string(s), ok
+}
+
+func (builtinValues) AsChar(v Value) (rune, bool) {
+	r, ok := v.(CharValue)
+	return rune(r), ok
+}
+
+func (builtinValues) AsList(v Value) ([]Value, bool) {
+	list, ok := v.(*ListValue)
 	if !ok {
-		return toErr(StringValue(fmt.Sprintf("Unexpected argument to print: %s", args[0]))), nil
+		return nil, false
 	}
+	return *list, true
+}
 
-	bs, err := os.ReadFile(string(s))
-	if err != nil {
-		return toErr(StringValue(err.Error())), nil
-	}
-	return toOk(StringValue(string(bs))), nil
+func (builtinValues) AsEnum(v Value) (string, string, []Value, bool) {
+	e, ok := v.(EnumValue)
+	return e.parent, e.name, e.args, ok
 }
 
-func (c *Context) rajaArgs(_ string, _ []Value) (Value, *runtimeError) {
-	goArgs := os.Args
-	args := make(ListValue, len(goArgs))
-	for i, arg := range goArgs {
-		args[i] = StringValue(arg)
+// Builtin functions
+
+// Read line by line, and all at once, so the reads need to share the buffer
+func (c *Context) bufferedStdin() *bufio.Reader {
+	if c.stdinReader == nil {
+		c.stdinReader = bufio.NewReader(c.stdin)
 	}
-	return &args, nil
+	return c.stdinReader
 }
 
-func (c *Context) rajaExit(_ string, args []Value) (Value, *runtimeError) {
-	if err := c.requireArgLen("__exit", args, 1); err != nil {
+// Calls the given function with every line of stdin, as it is read,
+// and gives a list of what it returned
+func (c *Context) rajaLines(_ string, args []Value) (Value, *RuntimeError) {
+	if err := c.requireArgLen("__lines", args, 1); err != nil {
 		return nil, err
 	}
+	// Called from where lines was called
+	tok := ast.Token{Kind: ast.Identifier, Payload: "lines", Pos: c.stack[len(c.stack)-1].Pos}
+	call := ast.FnCallNode{Fn: ast.IdentifierNode{Payload: "lines", Tok: &tok}, Tok: &tok}
+	list := ListValue{}
+	for {
+		line, ok, err := builtin.ReadLine(c.bufferedStdin())
+		if err != nil {
+			return nil, &RuntimeError{reason: err.Error()}
+		}
+		if !ok {
+			return &list, nil
+		}
+		v, rErr := c.callFn(call, args[0], []Value{StringValue(line)})
+		if rErr != nil {
+			return nil, rErr
+		}
+		list = append(list, v)
+	}
+}
 
-	switch arg := args[0].(type) {
-	case IntValue:
-		os.Exit(int(arg))
-		// unreachable
-		return IntValue(int(arg)), nil
-	default:
-		return nil, &runtimeError{
-			reason: fmt.Sprintf("Mismatched types in call exit(%s)", args[0]),
+// Calls the given function without arguments.
+// Returns Result::Err with the message if it panics, and Result::Ok with its value otherwise.
+func (c *Context) rajaTry(_ string, args []Value) (Value, *RuntimeError) {
+	if err := c.requireArgLen("__try", args, 1); err != nil {
+		return nil, err
+	}
+	// Called from where try was called
+	tok := ast.Token{Kind: ast.Identifier, Payload: "try", Pos: c.stack[len(c.stack)-1].Pos}
+	call := ast.FnCallNode{Fn: ast.IdentifierNode{Payload: "try", Tok: &tok}, Tok: &tok}
+	v, err := c.callFn(call, args[0], []Value{})
+	if err != nil {
+		if err.exit != nil && err.exit.Panic {
+			return toErr(StringValue(err.exit.Message)), nil
 		}
+		return nil, err
 	}
+	return toOk(v), nil
 }
 
-func (c *Context) rajaUpdate(name string, args []Value) (Value, *runtimeError) {
+func (c *Context) rajaUpdate(name string, args []Value) (Value, *RuntimeError) {
 	if err := c.requireArgLen("__exit", args, 2); err != nil {
 		return nil, err
 	}
@@ -238,7 +386,7 @@ func (c *Context) rajaUpdate(name string, args []Value) (Value, *runtimeError) {
 // which is: Iterator
 //
 // Returns a Maybe if third argument is false
-func (c *Context) rajaIndex(_ string, args []Value) (Value, *runtimeError) {
+func (c *Context) rajaIndex(_ string, args []Value) (Value, *RuntimeError) {
 	if err := c.requireArgLen("__index", args, 3); err != nil {
 		return nil, err
 	}
@@ -247,7 +395,7 @@ func (c *Context) rajaIndex(_ string, args []Value) (Value, *runtimeError) {
 	case BoolValue:
 		unsafe = bool(u)
 	default:
-		return nil, &runtimeError{
+		return nil, &RuntimeError{
 			reason: fmt.Sprintf("Unexpected argument to __index: %s. Expected a bool as the third argument.", args[2]),
 		}
 	}
@@ -265,7 +413,7 @@ func (c *Context) rajaIndex(_ string, args []Value) (Value, *runtimeError) {
 			}
 			return toNone(), nil
 		default:
-			return nil, &runtimeError{
+			return nil, &RuntimeError{
 				reason: fmt.Sprintf("Unexpected argument to __index: %s. Expected an int as index.", args[1]),
 			}
 		}
@@ -280,35 +428,51 @@ func (c *Context) rajaIndex(_ string, args []Value) (Value, *runtimeError) {
 			}
 			return toNone(), nil
 		default:
-			return nil, &runtimeError{
+			return nil, &RuntimeError{
 				reason: fmt.Sprintf("Unexpected argument to __index: %s. Expected an int as index.", args[1]),
 			}
 		}
 	case StringValue:
 		switch i := args[1].(type) {
 		case IntValue:
+			char, ok := c.runes.runeAt(v, int(i))
+			if unsafe && !ok {
+				return nil, &RuntimeError{
+					reason: fmt.Sprintf("Index %d out of range for %s", i, v),
+				}
+			}
 			if unsafe {
-				return StringValue([]byte{v[i]}), nil
+				return char, nil
 			}
-			if len(v) > int(i) {
-				return toSome(StringValue([]byte{v[i]})), nil
+			if ok {
+				return toSome(char), nil
 			}
 			return toNone(), nil
 		default:
-			return nil, &runtimeError{
+			return nil, &RuntimeError{
 				reason: fmt.Sprintf("Unexpected argument to __index: %s. Expected an int as index.", args[1]),
 			}
 		}
 	default:
-		return nil, &runtimeError{
+		return nil, &RuntimeError{
 			reason: fmt.Sprintf("Unexpected argument to __index: %s. Expected an Iterator.", args[0]),
 		}
 	}
 }
 
+// Int covers both IntValue and BigIntValue, BigInt only the latter
 func (c *Context) rajaAliasInt(u Value) bool {
 	switch u.(type) {
-	case IntValue:
+	case IntValue, BigIntValue:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *Context) rajaAliasBigInt(u Value) bool {
+	switch u.(type) {
+	case BigIntValue:
 		return true
 	default:
 		return false
@@ -333,6 +497,15 @@ func (c *Context) rajaAliasStr(u Value) bool {
 	}
 }
 
+func (c *Context) rajaAliasChar(u Value) bool {
+	switch u.(type) {
+	case CharValue:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *Context) rajaAliasList(u Value) bool {
 	switch u.(type) {
 	case *ListValue:
@@ -351,6 +524,15 @@ func (c *Context) rajaAliasEnum(u Value) bool {
 	}
 }
 
+func (c *Context) rajaAliasMap(u Value) bool {
+	switch u.(type) {
+	case *MapValue:
+		return true
+	default:
+		return false
+	}
+}
+
 func (c *Context) rajaAliasFn(u Value) bool {
 	switch u.(type) {
 	case FnValue, FnValues, BuiltinFnValue:
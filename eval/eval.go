@@ -1,37 +1,71 @@
 package eval
 
 import (
+	"bufio"
 	"bytes"
 	"dghaehre/raja/ast"
+	"dghaehre/raja/builtin"
+	"dghaehre/raja/lib"
 	"dghaehre/raja/util"
 	"fmt"
 	color "github.com/dghaehre/termcolor"
 	"io"
 	"math"
+	"math/big"
+	"os"
+	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type stackEntry struct {
-	name string
+	name    string
+	builtin bool
 	ast.Pos
 }
 
 func (e stackEntry) String() string {
+	if e.Pos == (ast.Pos{}) {
+		// Called by a Go program embedding raja, with Context.Call
+		return fmt.Sprintf("  in function %s, called from Go", e.name)
+	}
+	if e.builtin {
+		return fmt.Sprintf("  in builtin function %s %s", e.name, e.Pos)
+	}
 	if e.name != "" {
 		return fmt.Sprintf("  in function %s %s", e.name, e.Pos)
 	}
 	return fmt.Sprintf("  in anonymous function %s", e.Pos)
 }
 
-type runtimeError struct {
+// An error while evaluating, with where it happened and the call stack at the time.
+// Builtin functions, also the ones given by a Go host with LoadFunc, fail with NewRuntimeError.
+type RuntimeError struct {
 	reason string
 	ast.Pos
 	stackTrace []stackEntry
+
+	// Set when the program is stopped by exit or panic,
+	// which unwinds all the way out of Context.Eval
+	exit *ExitError
+}
+
+// The position of the error is filled in with where the failing function was called from
+func NewRuntimeError(format string, args ...any) *RuntimeError {
+	return &RuntimeError{
+		reason: fmt.Sprintf(format, args...),
+	}
+}
+
+// The message of the error, without the position and stack trace
+func (e *RuntimeError) Reason() string {
+	return e.reason
 }
 
-func (e *runtimeError) Error() string {
+func (e *RuntimeError) Error() string {
 	trace := make([]string, len(e.stackTrace))
 	for i, entry := range e.stackTrace {
 		trace[i] = entry.String()
@@ -40,6 +74,10 @@ func (e *runtimeError) Error() string {
 	return fmt.Sprintf("%s at %s:\n\n%s\n%s", header, e.Pos, e.reason, strings.Join(trace, "\n"))
 }
 
+// Returned by Context.Eval when the program calls exit or panic.
+// It is up to the caller to exit the process with Code.
+type ExitError = builtin.Exit
+
 type scope struct {
 	parent *scope
 
@@ -49,15 +87,79 @@ type scope struct {
 
 type Context struct {
 	scope
+
+	// The current call stack, used to give runtime errors a stack trace
+	stack []stackEntry
+
+	// Builtins and the base lib, which is all that modules see of the global scope
+	prelude scope
+
+	// Imported modules, by lib.Module.Key, so that each module is only evaluated once
+	modules map[string]ModuleValue
+
+	// Modules currently being imported, used to detect cyclic imports
+	importing []lib.Module
+
+	// What the IO builtins use, set with the options given to NewContext
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+	args   []string
+
+	// stdin, buffered when it is first read from
+	stdinReader *bufio.Reader
+
+	// Makes indexing a Str character by character linear, and not quadratic
+	runes runeCursor
+}
+
+// Configures a Context, given to NewContext
+type Option func(*Context)
+
+// Where input is read from. Defaults to os.Stdin
+func WithStdin(r io.Reader) Option {
+	return func(c *Context) {
+		c.stdin = r
+	}
+}
+
+// Where print writes to. Defaults to os.Stdout
+func WithStdout(w io.Writer) Option {
+	return func(c *Context) {
+		c.stdout = w
+	}
 }
 
-func NewContext() Context {
-	return Context{
+// Where eprint writes to. Defaults to os.Stderr
+func WithStderr(w io.Writer) Option {
+	return func(c *Context) {
+		c.stderr = w
+	}
+}
+
+// What get_args gives, where the first argument is the program. Defaults to os.Args
+func WithArgs(args []string) Option {
+	return func(c *Context) {
+		c.args = args
+	}
+}
+
+func NewContext(opts ...Option) Context {
+	c := Context{
 		scope: scope{
 			parent: nil,
 			vars:   map[string]Value{},
 		},
+		modules: map[string]ModuleValue{},
+		stdin:   os.Stdin,
+		stdout:  os.Stdout,
+		stderr:  os.Stderr,
+		args:    os.Args,
 	}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	return c
 }
 
 func isMutable(name string) bool {
@@ -88,6 +190,8 @@ func (v IntValue) Eq(u Value) bool {
 		return v == w
 	} else if w, ok := u.(FloatValue); ok {
 		return FloatValue(v) == w
+	} else if w, ok := u.(BigIntValue); ok {
+		return w.Eq(v)
 	}
 	return false
 }
@@ -125,6 +229,8 @@ func (v FloatValue) Eq(u Value) bool {
 		return v == w
 	} else if w, ok := u.(IntValue); ok {
 		return v == FloatValue(w)
+	} else if w, ok := u.(BigIntValue); ok {
+		return w.Eq(v)
 	}
 	return false
 }
@@ -145,6 +251,23 @@ func (v StringValue) Eq(u Value) bool {
 	return false
 }
 
+// A single unicode character, as given by chr
+type CharValue rune
+
+func (v CharValue) String() string {
+	return string(v)
+}
+
+func (v CharValue) Eq(u Value) bool {
+	if _, ok := u.(UnderscoreValue); ok {
+		return true
+	}
+	if w, ok := u.(CharValue); ok {
+		return v == w
+	}
+	return false
+}
+
 type ListValue []Value
 
 func (v *ListValue) String() string {
@@ -173,9 +296,21 @@ func (v *ListValue) Eq(u Value) bool {
 	return false
 }
 
+// Keys are compared by value, and entries are kept in insertion order.
+// A MapValue is never changed after it is created; insert and remove return a new map.
+type MapValue = builtin.Map[Value]
+
+func NewMapValue() *MapValue {
+	return builtin.NewMap[Value](builtinValues{})
+}
+
 type AliasValue struct {
 	targets []Value
 	scope
+
+	// Type parameters, bound to _ in targets until the alias is given parameters: Maybe(Int)
+	params []string
+	node   *ast.AliasNode
 }
 
 func (a AliasValue) String() string {
@@ -272,11 +407,31 @@ func HasAlias(a ast.Arg) bool {
 	return a.Alias != ""
 }
 
+// Counts every type given to the arguments, including type parameters,
+// so that (l:List(Int)) is more specific than (l:List).
+// Type variables match anything, and are not counted.
+func specificity(args []ast.Arg) int {
+	n := 0
+	for _, a := range util.Filter(args, HasAlias) {
+		n += typeCount(a.Type())
+	}
+	return n
+}
+
+func typeCount(t ast.Type) int {
+	if isTypeVariable(t.Name) {
+		return 0
+	}
+	n := 1
+	for _, param := range t.Params {
+		n += typeCount(param)
+	}
+	return n
+}
+
 // Currently only sorting by which function that has the most 'aliases'
 func (fv MostSpecific) Less(i, j int) bool {
-	x := len(util.Filter(fv[i].fn.Args, HasAlias))
-	y := len(util.Filter(fv[j].fn.Args, HasAlias))
-	return x > y
+	return specificity(fv[i].fn.Args) > specificity(fv[j].fn.Args)
 }
 func (fv MostSpecific) Swap(i, j int) { fv[i], fv[j] = fv[j], fv[i] }
 
@@ -299,6 +454,44 @@ func (v FnValue) Eq(u Value) bool {
 	return false
 }
 
+// Created by an import.
+// The top-level bindings of the module are used by calling functions on it, or by name:
+//
+//	strings = import "strings.raja"
+//	strings.split("a,b", ",")
+//
+//	import math
+//	math.sqrt(2) * math.pi
+type ModuleValue struct {
+	name string
+	scope
+}
+
+func (v ModuleValue) String() string {
+	return fmt.Sprintf("<module %s>", v.name)
+}
+
+func (v ModuleValue) Eq(u Value) bool {
+	if _, ok := u.(UnderscoreValue); ok {
+		return true
+	}
+	if w, ok := u.(ModuleValue); ok {
+		return v.name == w.name
+	}
+	return false
+}
+
+// Only looks at the top-level bindings of the module
+func (v ModuleValue) get(name string, pos ast.Pos) (Value, *RuntimeError) {
+	if value, ok := v.vars[name]; ok {
+		return value, nil
+	}
+	return nil, &RuntimeError{
+		reason: fmt.Sprintf("%s is not defined in module %s", name, v.name),
+		Pos:    pos,
+	}
+}
+
 type UnderscoreValue byte
 
 // interned "empty" value
@@ -315,14 +508,14 @@ func (v UnderscoreValue) Eq(u Value) bool {
 
 // Update variable
 // TODO: how do we not know we want to "update" a variable in the outer scope?
-func (sc *scope) update(name string, v Value, pos ast.Pos) *runtimeError {
+func (sc *scope) update(name string, v Value, pos ast.Pos) *RuntimeError {
 	_, exist := sc.vars[name]
 	if exist {
 		if isMutable(name) {
 			sc.vars[name] = v
 			return nil
 		} else {
-			return &runtimeError{
+			return &RuntimeError{
 				reason: fmt.Sprintf("%s is not mutable.\nTry renaming the variable to mut_%s", name, name),
 				Pos:    pos,
 			}
@@ -331,14 +524,14 @@ func (sc *scope) update(name string, v Value, pos ast.Pos) *runtimeError {
 	if sc.parent != nil {
 		return sc.parent.update(name, v, pos)
 	}
-	return &runtimeError{
+	return &RuntimeError{
 		reason: fmt.Sprintf("Cannot find variable %s to update.\nMake sure you have already created the variable before calling update", name),
 		Pos:    pos,
 	}
 }
 
 // Put variable into scope
-func (sc *scope) put(name string, v Value, pos ast.Pos) *runtimeError {
+func (sc *scope) put(name string, v Value, pos ast.Pos) *RuntimeError {
 	switch value := v.(type) {
 	case FnValue:
 		scvalue, ok := sc.vars[name]
@@ -354,7 +547,7 @@ func (sc *scope) put(name string, v Value, pos ast.Pos) *runtimeError {
 			sc.vars[name] = scvalue
 			return nil
 		default:
-			return &runtimeError{
+			return &RuntimeError{
 				reason: fmt.Sprintf("Should never happen. expected fnValue, got %s.", scvalue),
 				Pos:    pos,
 			}
@@ -363,12 +556,12 @@ func (sc *scope) put(name string, v Value, pos ast.Pos) *runtimeError {
 		_, exist := sc.vars[name]
 		if exist {
 			if isMutable(name) {
-				return &runtimeError{
+				return &RuntimeError{
 					reason: fmt.Sprintf("To update a variable, use the update function.\nExample: %s.update(%s)", name, v),
 					Pos:    pos,
 				}
 			}
-			return &runtimeError{
+			return &RuntimeError{
 				reason: fmt.Sprintf("%s is not mutable.\nTry renaming the variable to mut_%s and use the update function\nExample: %s.update(%s)", name, name, name, name),
 				Pos:    pos,
 			}
@@ -378,18 +571,56 @@ func (sc *scope) put(name string, v Value, pos ast.Pos) *runtimeError {
 	return nil
 }
 
-func (sc *scope) get(name string) (Value, *runtimeError) {
+func (sc *scope) get(name string) (Value, *RuntimeError) {
 	if v, ok := sc.vars[name]; ok {
 		return v, nil
 	}
 	if sc.parent != nil {
 		return sc.parent.get(name)
 	}
-	return nil, &runtimeError{
+	return nil, &RuntimeError{
 		reason: fmt.Sprintf("%s is undefined", name),
 	}
 }
 
+// A copy of the variables of sc, without its parent
+func (sc scope) snapshot() scope {
+	vars := make(map[string]Value, len(sc.vars))
+	for name, v := range sc.vars {
+		vars[name] = v
+	}
+	return scope{vars: vars}
+}
+
+// A copy of sc, where the functions defined in sc see the copy instead of sc.
+// Updating a variable of the copy leaves sc as it was.
+func (sc scope) isolated() scope {
+	copied := sc.snapshot()
+	copied.parent = sc.parent
+	definedIn := func(fn FnValue) bool {
+		return reflect.ValueOf(fn.vars).Pointer() == reflect.ValueOf(sc.vars).Pointer()
+	}
+	for name, v := range copied.vars {
+		switch fn := v.(type) {
+		case FnValue:
+			if definedIn(fn) {
+				fn.scope = copied
+				copied.vars[name] = fn
+			}
+		case FnValues:
+			values := make([]FnValue, len(fn.values))
+			for i, f := range fn.values {
+				if definedIn(f) {
+					f.scope = copied
+				}
+				values[i] = f
+			}
+			copied.vars[name] = FnValues{values: values}
+		}
+	}
+	return copied
+}
+
 // Eval
 
 func (c *Context) Eval(reader io.Reader, filename string) (Value, error) {
@@ -406,24 +637,29 @@ func (c *Context) Eval(reader io.Reader, filename string) (Value, error) {
 	}
 	v, runtimeErr := c.evalNodes(nodes)
 	if runtimeErr != nil {
+		if runtimeErr.exit != nil {
+			return nil, runtimeErr.exit
+		}
 		return nil, runtimeErr
 	}
 	return v, nil
 }
 
-func incompatibleError(op ast.TokKind, left, right Value, position ast.Pos) *runtimeError {
-	return &runtimeError{
+func incompatibleError(op ast.TokKind, left, right Value, position ast.Pos) *RuntimeError {
+	return &RuntimeError{
 		reason: fmt.Sprintf("Cannot %s incompatible values %s, %s",
 			ast.Token{Kind: op}, left, right),
 		Pos: position,
 	}
 }
 
-var divisionByZeroErr = runtimeError{
-	reason: fmt.Sprintf("Division by zero"),
+func divisionByZeroErr() *RuntimeError {
+	return &RuntimeError{
+		reason: "Division by zero",
+	}
 }
 
-func floatBinaryOp(op ast.TokKind, left FloatValue, right FloatValue) (Value, *runtimeError) {
+func floatBinaryOp(op ast.TokKind, left FloatValue, right FloatValue) (Value, *RuntimeError) {
 	switch op {
 	case ast.Minus:
 		return FloatValue(left - right), nil
@@ -431,12 +667,12 @@ func floatBinaryOp(op ast.TokKind, left FloatValue, right FloatValue) (Value, *r
 		return FloatValue(left + right), nil
 	case ast.Divide:
 		if right == 0 {
-			return nil, &divisionByZeroErr
+			return nil, divisionByZeroErr()
 		}
 		return FloatValue(left / right), nil
 	case ast.Modulus:
 		if right == 0 {
-			return nil, &divisionByZeroErr
+			return nil, divisionByZeroErr()
 		}
 		return FloatValue(math.Mod(float64(left), float64(right))), nil
 	case ast.Times:
@@ -458,7 +694,10 @@ func floatBinaryOp(op ast.TokKind, left FloatValue, right FloatValue) (Value, *r
 	}
 }
 
-func intBinaryOp(op ast.TokKind, left IntValue, right IntValue) (Value, *runtimeError) {
+func intBinaryOp(op ast.TokKind, left IntValue, right IntValue) (Value, *RuntimeError) {
+	if intOverflows(op, left, right) {
+		return bigBinaryOp(op, big.NewInt(int64(left)), big.NewInt(int64(right)))
+	}
 	switch op {
 	case ast.Minus:
 		return IntValue(left - right), nil
@@ -468,12 +707,12 @@ func intBinaryOp(op ast.TokKind, left IntValue, right IntValue) (Value, *runtime
 		return IntValue(left * right), nil
 	case ast.Divide:
 		if right == 0 {
-			return nil, &divisionByZeroErr
+			return nil, divisionByZeroErr()
 		}
 		return IntValue(left / right), nil
 	case ast.Modulus:
 		if right == 0 {
-			return nil, &divisionByZeroErr
+			return nil, divisionByZeroErr()
 		}
 		return IntValue(left % right), nil
 	case ast.Greater:
@@ -493,10 +732,11 @@ func intBinaryOp(op ast.TokKind, left IntValue, right IntValue) (Value, *runtime
 	}
 }
 
-func stringBinaryOp(op ast.TokKind, left StringValue, right StringValue) (Value, *runtimeError) {
+func stringBinaryOp(op ast.TokKind, left StringValue, right StringValue) (Value, *RuntimeError) {
 	switch op {
 	case ast.PlusOther:
-		x := append(left, right...)
+		// Copies left, which can share its bytes with another string
+		x := append(left[:len(left):len(left)], right...)
 		return StringValue(x), nil
 	case ast.Eq:
 		return BoolValue(string(left) == string(right)), nil
@@ -507,7 +747,24 @@ func stringBinaryOp(op ast.TokKind, left StringValue, right StringValue) (Value,
 	}
 }
 
-func listBinaryOp(op ast.TokKind, left *ListValue, right *ListValue) (Value, *runtimeError) {
+func charBinaryOp(op ast.TokKind, left CharValue, right CharValue) (Value, *RuntimeError) {
+	switch op {
+	case ast.Greater:
+		return BoolValue(left > right), nil
+	case ast.Less:
+		return BoolValue(left < right), nil
+	case ast.Geq:
+		return BoolValue(left >= right), nil
+	case ast.Leq:
+		return BoolValue(left <= right), nil
+	case ast.Neq:
+		return BoolValue(left != right), nil
+	default:
+		return nil, incompatibleError(op, left, right, ast.Pos{})
+	}
+}
+
+func listBinaryOp(op ast.TokKind, left *ListValue, right *ListValue) (Value, *RuntimeError) {
 	switch op {
 	case ast.PlusOther:
 		x := append(*left, *right...)
@@ -518,7 +775,63 @@ func listBinaryOp(op ast.TokKind, left *ListValue, right *ListValue) (Value, *ru
 	}
 }
 
-func (c *Context) evalBinaryNode(n ast.BinaryNode, sc scope) (Value, *runtimeError) {
+// && and ||, which only evaluate the right side when the left side does not decide the result
+func (c *Context) evalLogicalNode(n ast.BinaryNode, sc scope) (Value, *RuntimeError) {
+	for _, side := range []ast.AstNode{n.Left, n.Right} {
+		computed, err := c.evalExpr(side, sc)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := computed.(BoolValue)
+		if !ok {
+			return nil, &RuntimeError{
+				reason: fmt.Sprintf("Cannot %s non-bool value %s", ast.Token{Kind: n.Op}, computed),
+				Pos:    n.Pos(),
+			}
+		}
+		if bool(b) == (n.Op == ast.Or) {
+			return b, nil
+		}
+	}
+	return BoolValue(n.Op == ast.And), nil
+}
+
+func (c *Context) evalUnaryNode(n ast.UnaryNode, sc scope) (Value, *RuntimeError) {
+	operand, err := c.evalExpr(n.Operand, sc)
+	if err != nil {
+		return nil, err
+	}
+	switch v := operand.(type) {
+	case BoolValue:
+		if n.Op == ast.Not {
+			return !v, nil
+		}
+	case IntValue:
+		if n.Op == ast.Minus {
+			if v == math.MinInt64 {
+				return NewBigIntValue(new(big.Int).Neg(big.NewInt(int64(v)))), nil
+			}
+			return -v, nil
+		}
+	case BigIntValue:
+		if n.Op == ast.Minus {
+			return NewBigIntValue(new(big.Int).Neg(v.value)), nil
+		}
+	case FloatValue:
+		if n.Op == ast.Minus {
+			return -v, nil
+		}
+	}
+	return nil, &RuntimeError{
+		reason: fmt.Sprintf("Unary operator %s is not defined for value %s", ast.Token{Kind: n.Op}, operand),
+		Pos:    n.Pos(),
+	}
+}
+
+func (c *Context) evalBinaryNode(n ast.BinaryNode, sc scope) (Value, *RuntimeError) {
+	if n.Op == ast.And || n.Op == ast.Or {
+		return c.evalLogicalNode(n, sc)
+	}
 	leftComputed, err := c.evalExpr(n.Left, sc)
 	if err != nil {
 		return nil, err
@@ -535,7 +848,7 @@ func (c *Context) evalBinaryNode(n ast.BinaryNode, sc scope) (Value, *runtimeErr
 		right, ok := rightComputed.(*ListValue)
 		if !ok {
 			switch x := rightComputed.(type) {
-			case IntValue, FloatValue, StringValue: // TODO: extend
+			case IntValue, FloatValue, StringValue, CharValue: // TODO: extend
 				elem := make([]Value, 1)
 				elem[0] = x
 				l := ListValue(elem)
@@ -551,19 +864,16 @@ func (c *Context) evalBinaryNode(n ast.BinaryNode, sc scope) (Value, *runtimeErr
 		return val, err
 
 	case FloatValue:
-		right, ok := rightComputed.(FloatValue)
-		if !ok {
-			rightFloat, ok := rightComputed.(IntValue)
-			if !ok {
-				return nil, incompatibleError(n.Op, leftComputed, rightComputed, n.Pos())
-			}
-
-			right := FloatValue(float64(int64(rightFloat)))
-			val, err := floatBinaryOp(n.Op, left, right)
-			if err != nil {
-				err.Pos = n.Pos()
-			}
-			return val, err
+		var right FloatValue
+		switch r := rightComputed.(type) {
+		case FloatValue:
+			right = r
+		case IntValue:
+			right = FloatValue(float64(int64(r)))
+		case BigIntValue:
+			right = bigToFloat(r.value)
+		default:
+			return nil, incompatibleError(n.Op, leftComputed, rightComputed, n.Pos())
 		}
 
 		val, err := floatBinaryOp(n.Op, left, right)
@@ -574,6 +884,13 @@ func (c *Context) evalBinaryNode(n ast.BinaryNode, sc scope) (Value, *runtimeErr
 	case IntValue:
 		right, ok := rightComputed.(IntValue)
 		if !ok {
+			if rightBig, ok := rightComputed.(BigIntValue); ok {
+				val, err := bigBinaryOp(n.Op, big.NewInt(int64(left)), rightBig.value)
+				if err != nil {
+					err.Pos = n.Pos()
+				}
+				return val, err
+			}
 			rightFloat, ok := rightComputed.(FloatValue)
 			if !ok {
 				return nil, incompatibleError(n.Op, leftComputed, rightComputed, n.Pos())
@@ -592,8 +909,29 @@ func (c *Context) evalBinaryNode(n ast.BinaryNode, sc scope) (Value, *runtimeErr
 			err.Pos = n.Pos()
 		}
 		return val, err
+	case BigIntValue:
+		if rightFloat, ok := rightComputed.(FloatValue); ok {
+			val, err := floatBinaryOp(n.Op, bigToFloat(left.value), rightFloat)
+			if err != nil {
+				err.Pos = n.Pos()
+			}
+			return val, err
+		}
+		right, ok := toBig(rightComputed)
+		if !ok {
+			return nil, incompatibleError(n.Op, leftComputed, rightComputed, n.Pos())
+		}
+
+		val, err := bigBinaryOp(n.Op, left.value, right)
+		if err != nil {
+			err.Pos = n.Pos()
+		}
+		return val, err
 	case StringValue:
 		right, ok := rightComputed.(StringValue)
+		if char, isChar := rightComputed.(CharValue); isChar && n.Op == ast.PlusOther {
+			right, ok = StringValue(string(char)), true
+		}
 		if !ok {
 			return nil, incompatibleError(n.Op, leftComputed, rightComputed, n.Pos())
 		}
@@ -602,8 +940,18 @@ func (c *Context) evalBinaryNode(n ast.BinaryNode, sc scope) (Value, *runtimeErr
 			err.Pos = n.Pos()
 		}
 		return val, err
+	case CharValue:
+		right, ok := rightComputed.(CharValue)
+		if !ok {
+			return nil, incompatibleError(n.Op, leftComputed, rightComputed, n.Pos())
+		}
+		val, err := charBinaryOp(n.Op, left, right)
+		if err != nil {
+			err.Pos = n.Pos()
+		}
+		return val, err
 	default:
-		return nil, &runtimeError{
+		return nil, &RuntimeError{
 			reason: fmt.Sprintf("Binary operator %s is not defined for values %s, %s",
 				ast.Token{Kind: n.Op}, leftComputed, rightComputed),
 			Pos: n.Pos(),
@@ -611,10 +959,111 @@ func (c *Context) evalBinaryNode(n ast.BinaryNode, sc scope) (Value, *runtimeErr
 	}
 }
 
-func (c *Context) getCorrectFnValue(n ast.FnCallNode, fnv FnValues, args []Value) (FnValue, *runtimeError) {
+// Type variables are single lowercase letters, like a in List(a).
+// They are never looked up in scope, and match anything at runtime.
+// Other names are types that have to be defined, so a typo like int is not taken as one.
+func isTypeVariable(name string) bool {
+	r, size := utf8.DecodeRuneInString(name)
+	return size == len(name) && unicode.IsLower(r)
+}
+
+// Looks up the alias of a parameter, and gives it its type parameters
+func (c *Context) resolveType(t ast.Type, sc scope) (Value, *RuntimeError) {
+	if isTypeVariable(t.Name) {
+		if len(t.Params) > 0 {
+			return nil, &RuntimeError{
+				reason: fmt.Sprintf("Type variable %s cannot take type parameters", t.Name),
+			}
+		}
+		return underscorevalue, nil
+	}
+	v, err := sc.get(t.Name)
+	if err != nil {
+		if r, _ := utf8.DecodeRuneInString(t.Name); unicode.IsLower(r) {
+			err.reason = fmt.Sprintf("Unknown type %s. Type variables are single lowercase letters, like a in List(a)", t.Name)
+		}
+		return nil, err
+	}
+	if len(t.Params) == 0 {
+		return v, nil
+	}
+	params := make([]Value, len(t.Params))
+	for i, param := range t.Params {
+		params[i], err = c.resolveType(param, sc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return c.instantiateAlias(v, params, ast.Pos{})
+}
+
+// Gives an alias its type parameters: Maybe(Int) or List(Str)
+func (c *Context) instantiateAlias(alias Value, params []Value, pos ast.Pos) (Value, *RuntimeError) {
+	switch a := alias.(type) {
+	case BuiltinAliasValue:
+		return a.instantiate(params, pos)
+	case AliasValue:
+		if len(a.params) != len(params) {
+			return nil, &RuntimeError{
+				reason: fmt.Sprintf("%s takes %d type parameter(s), got %d", a.node.Name, len(a.params), len(params)),
+				Pos:    pos,
+			}
+		}
+		// The targets were evaluated with every type parameter bound to _
+		if allUnderscore(params) {
+			return a, nil
+		}
+		aliasScope := scope{
+			parent: &a.scope,
+			vars:   map[string]Value{},
+		}
+		for i, name := range a.params {
+			if err := aliasScope.put(name, params[i], pos); err != nil {
+				return nil, err
+			}
+		}
+		targets, err := c.evalAliasTargets(*a.node, aliasScope)
+		if err != nil {
+			return nil, err
+		}
+		return AliasValue{
+			targets: targets,
+			scope:   a.scope,
+			node:    a.node,
+		}, nil
+	default:
+		return nil, &RuntimeError{
+			reason: fmt.Sprintf("%s is not a type, and cannot be given type parameters", alias),
+			Pos:    pos,
+		}
+	}
+}
+
+func allUnderscore(values []Value) bool {
+	for _, v := range values {
+		if _, ok := v.(UnderscoreValue); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *Context) evalAliasTargets(n ast.AliasNode, sc scope) ([]Value, *RuntimeError) {
+	var err *RuntimeError
+	targets := make([]Value, len(n.Targets))
+	for i, elNode := range n.Targets {
+		targets[i], err = c.evalExpr(elNode, sc)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return targets, nil
+}
+
+func (c *Context) getCorrectFnValue(n ast.FnCallNode, fnv FnValues, args []Value) (FnValue, *RuntimeError) {
 
 	// Filter out functions that does not 'pass' as possible alternatives
-	var filterError *runtimeError
+	var filterError *RuntimeError
 	relevant := util.Filter(fnv.values, func(f FnValue) bool {
 		if len(f.fn.Args) != len(args) {
 			return false
@@ -626,7 +1075,7 @@ func (c *Context) getCorrectFnValue(n ast.FnCallNode, fnv FnValues, args []Value
 			if f.fn.Args[i].Alias == "" {
 				continue
 			}
-			v, err := c.scope.get(f.fn.Args[i].Alias)
+			v, err := c.resolveType(f.fn.Args[i].Type(), f.scope)
 			if err != nil {
 				filterError = err
 				return false
@@ -642,7 +1091,7 @@ func (c *Context) getCorrectFnValue(n ast.FnCallNode, fnv FnValues, args []Value
 	}
 
 	if len(relevant) == 0 {
-		return FnValue{}, &runtimeError{
+		return FnValue{}, &RuntimeError{
 			reason: fmt.Sprintf("Cannot call function %s with the supplied args.\nThere are %d function(s) named %s in scope, but none matched the parameters used.", n.Fn, len(fnv.values), n.Fn),
 			Pos:    n.Pos(),
 		}
@@ -652,18 +1101,190 @@ func (c *Context) getCorrectFnValue(n ast.FnCallNode, fnv FnValues, args []Value
 	return relevant[0], nil
 }
 
-func (c *Context) evalFnCallNode(n ast.FnCallNode, sc scope, args []Value) (Value, *runtimeError) {
-	leftComputed, err := c.evalExpr(n.Fn, sc)
+func (c *Context) pushStack(n ast.FnCallNode, builtin bool) {
+	name := ""
+	if ident, ok := n.Fn.(ast.IdentifierNode); ok {
+		name = ident.Payload
+	}
+	c.stack = append(c.stack, stackEntry{
+		name:    name,
+		builtin: builtin,
+		Pos:     n.Pos(),
+	})
+}
+
+func (c *Context) popStack() {
+	c.stack = c.stack[:len(c.stack)-1]
+}
+
+// Attach the current call stack to the error, with the innermost call first.
+// Only the first call frame the error passes through records the trace.
+func (c *Context) withStackTrace(err *RuntimeError) *RuntimeError {
+	if err == nil || err.stackTrace != nil {
+		return err
+	}
+	trace := make([]stackEntry, len(c.stack))
+	for i, entry := range c.stack {
+		trace[len(c.stack)-1-i] = entry
+	}
+	err.stackTrace = trace
+	return err
+}
+
+// A function call in tail position is not evaluated right away, but returned as a
+// tailCallValue to the caller, which evaluates it in a loop instead of recursing.
+// This keeps the Go stack from growing with recursive raja functions.
+type tailCallValue struct {
+	node ast.FnCallNode
+	fn   Value
+	args []Value
+}
+
+func (v tailCallValue) String() string {
+	return fmt.Sprintf("<tail call %s>", v.node)
+}
+
+func (v tailCallValue) Eq(u Value) bool {
+	return false
+}
+
+// A call on a module, mod.fn(a), is really the call fn(mod, a), where fn should be
+// looked up in the module instead of the current scope. A module given as the
+// first argument of a normal call, like print(mod), is just an argument.
+func qualifyingModule(n ast.FnCallNode, args []Value) (ModuleValue, string, bool) {
+	if !n.Dot || len(args) == 0 {
+		return ModuleValue{}, "", false
+	}
+	module, ok := args[0].(ModuleValue)
+	if !ok {
+		return ModuleValue{}, "", false
+	}
+	ident, ok := n.Fn.(ast.IdentifierNode)
+	if !ok {
+		return ModuleValue{}, "", false
+	}
+	return module, ident.Payload, true
+}
+
+func (c *Context) evalFnCallNode(n ast.FnCallNode, sc scope, args []Value, tail bool) (Value, *RuntimeError) {
+	var leftComputed Value
+	var err *RuntimeError
+	if module, name, ok := qualifyingModule(n, args); ok {
+		leftComputed, err = module.get(name, n.Pos())
+		n.Args = n.Args[1:]
+		args = args[1:]
+	} else {
+		leftComputed, err = c.evalExpr(n.Fn, sc)
+	}
 	if err != nil {
 		return nil, err
 	}
+	if tail {
+		return tailCallValue{
+			node: n,
+			fn:   leftComputed,
+			args: args,
+		}, nil
+	}
+	return c.callFn(n, leftComputed, args)
+}
+
+// Calls the function value with the already evaluated arguments, as the call n
+func (c *Context) callFn(n ast.FnCallNode, leftComputed Value, args []Value) (Value, *RuntimeError) {
+	_, isBuiltin := leftComputed.(BuiltinFnValue)
+	depth := len(c.stack)
+	c.pushStack(n, isBuiltin)
+	defer func() {
+		c.stack = c.stack[:depth]
+	}()
+
+	// Functions with a return type that are waiting for the value of this call.
+	// A tail call hands its value to every function before it.
+	var checks []returnCheck
+
+	// Trampoline: keep evaluating tail calls until we get an actual value.
+	for {
+		v, called, err := c.callFnValue(n, leftComputed, args)
+		if err != nil {
+			return nil, c.withStackTrace(err)
+		}
+		if called.fn != nil && called.fn.Return != nil && !hasReturnCheck(checks, called.fn) {
+			checks = append(checks, returnCheck{call: n, fn: called})
+		}
+		tc, ok := v.(tailCallValue)
+		if !ok {
+			for _, check := range checks {
+				if err := c.checkReturn(check, v); err != nil {
+					return nil, c.withStackTrace(err)
+				}
+			}
+			return v, nil
+		}
+		n, leftComputed, args = tc.node, tc.fn, tc.args
+
+		// The first tail call gets a stack entry of its own, and the later ones reuse
+		// the last entry. So the stack stays the same size, while the stack trace still
+		// shows where the tail calls started: like the assert in a test.
+		_, isBuiltin := leftComputed.(BuiltinFnValue)
+		if len(c.stack) > depth+2 {
+			c.popStack()
+		}
+		c.pushStack(n, isBuiltin)
+	}
+}
+
+type returnCheck struct {
+	call ast.FnCallNode
+	fn   FnValue
+}
+
+func hasReturnCheck(checks []returnCheck, fn *ast.FnNode) bool {
+	for _, check := range checks {
+		if check.fn.fn == fn {
+			return true
+		}
+	}
+	return false
+}
+
+// (a:Int) -> Str => ...
+// The returned value has to match the annotated return type.
+func (c *Context) checkReturn(check returnCheck, v Value) *RuntimeError {
+	t, err := c.resolveType(*check.fn.fn.Return, check.fn.scope)
+	if err != nil {
+		if err.Pos == (ast.Pos{}) {
+			err.Pos = check.fn.fn.Pos()
+		}
+		return err
+	}
+	if t.Eq(v) {
+		return nil
+	}
+	return &RuntimeError{
+		reason: fmt.Sprintf("Function %s returned %s, which is not %s as annotated.", check.call.Fn, v, check.fn.fn.Return),
+		Pos:    check.call.Pos(),
+	}
+}
+
+// Calls the given function value. The body of the function is evaluated in tail position,
+// so the returned value might be a tailCallValue.
+// Also gives back the raja function that was called, which is empty for builtins.
+func (c *Context) callFnValue(n ast.FnCallNode, leftComputed Value, args []Value) (Value, FnValue, *RuntimeError) {
 	switch left := leftComputed.(type) {
 	case BuiltinFnValue:
-		return left.fn(n.FirstArgName(), args)
+		v, err := left.fn(n.FirstArgName(), args)
+		if err != nil && err.Pos == (ast.Pos{}) {
+			// Builtins does not know where they are called from
+			err.Pos = n.Pos()
+		}
+		return v, FnValue{}, err
 	case FnValues: // Multiple Dispatch
 		v, err := c.getCorrectFnValue(n, left, args)
 		if err != nil {
-			return nil, err
+			if err.Pos == (ast.Pos{}) {
+				err.Pos = n.Pos()
+			}
+			return nil, FnValue{}, err
 		}
 		fnScope := scope{
 			parent: &v.scope,
@@ -673,15 +1294,27 @@ func (c *Context) evalFnCallNode(n ast.FnCallNode, sc scope, args []Value) (Valu
 			if a.Name != "" {
 				err := fnScope.put(a.Name, args[i], n.Pos())
 				if err != nil {
-					return nil, err
+					return nil, FnValue{}, err
 				}
 			}
 		}
-		return c.evalExpr(v.fn.Body, fnScope)
+		body, err := c.evalExprTail(v.fn.Body, fnScope, true)
+		return body, v, err
+	case AliasValue, BuiltinAliasValue:
+		// A type given its type parameters, like List(Int) in an alias
+		v, err := c.instantiateAlias(left, args, n.Pos())
+		return v, FnValue{}, err
 	case FnValue:
 		// Not sure if this will ever happen?
 		// Stays here just in case for now..
 
+		if len(args) != len(left.fn.Args) {
+			return nil, FnValue{}, &RuntimeError{
+				reason: fmt.Sprintf("Function %s takes %d argument(s), but was called with %d", n.Fn, len(left.fn.Args), len(args)),
+				Pos:    n.Pos(),
+			}
+		}
+
 		// Takes the scope from outside of the defined function.
 		fnScope := scope{
 			parent: &left.scope,
@@ -691,134 +1324,213 @@ func (c *Context) evalFnCallNode(n ast.FnCallNode, sc scope, args []Value) (Valu
 			if a.Name != "" {
 				err := fnScope.put(a.Name, args[i], n.Pos())
 				if err != nil {
-					return nil, err
+					return nil, FnValue{}, err
 				}
 			}
 		}
-		return c.evalExpr(left.fn.Body, fnScope)
+		body, err := c.evalExprTail(left.fn.Body, fnScope, true)
+		return body, left, err
 	default:
-		return nil, &runtimeError{
+		return nil, FnValue{}, &RuntimeError{
 			reason: fmt.Sprintf("Cannot call function from %s.", leftComputed),
 			Pos:    n.Pos(),
 		}
 	}
 }
 
-func (c *Context) evalMatchNode(n ast.MatchNode, sc scope) (Value, *runtimeError) {
+func (c *Context) evalMatchNode(n ast.MatchNode, sc scope, tail bool) (Value, *RuntimeError) {
 	cond, err := c.evalExpr(n.Cond, sc)
 	if err != nil {
 		return nil, err
 	}
 	for _, v := range n.Branches {
-		t, bodyScope, err := c.evalMatchBranchExpr(v.Target, sc, cond)
+		matched, bodyScope, err := c.evalMatchBranchExpr(v.Target, sc, cond)
 		if err != nil {
 			return nil, err
 		}
-		if cond.Eq(t) {
-			return c.evalExpr(v.Body, bodyScope)
+		if !matched {
+			continue
 		}
+		if v.Guard != nil {
+			guard, err := c.evalExpr(v.Guard, bodyScope)
+			if err != nil {
+				return nil, err
+			}
+			passed, ok := guard.(BoolValue)
+			if !ok {
+				return nil, &RuntimeError{
+					reason: fmt.Sprintf("Match guard must be a bool, got %s: %s", guard, v.Guard),
+					Pos:    v.Guard.Pos(),
+				}
+			}
+			if !passed {
+				continue
+			}
+		}
+		return c.evalExprTail(v.Body, bodyScope, tail)
 	}
-	return nil, &runtimeError{
+	return nil, &RuntimeError{
 		reason: fmt.Sprintf("No patterns matched in match expression: %s", n.String()),
 		Pos:    n.Pos(),
 	}
 }
 
-// Return a list of values from:
-// - EnumValue
-// - ListValue
-//
-// Other Values returns an empty list
-func getIndexValuesFromValue(value Value, max int) []Value {
-	condArgs := []Value{}
-	switch v := value.(type) {
-	case EnumValue:
-		condArgs = v.args
-	case *ListValue:
-		for i, vv := range *v {
-			if i > max {
-				break
-			}
-			condArgs = append(condArgs, vv)
-		}
-	}
-	return condArgs
-}
-
-// This is a wrapper around evalExpr to make the pattern matching with the match keyword better.
-// It handles the listed nodes in a special way:
-// - identifierNode
-// - enumNode
-// - listNode
-//
-// If the given node is one of these nodes, it will look for identifierNode's inside the original node.
-// If it finds one, it will:
-// - substitue the identifierNode with an underscoreNode
-// - put that identifierNode/identifierValue into scope to be used in the body of that branch
-func (c *Context) evalMatchBranchExpr(node ast.AstNode, sc scope, cond Value) (Value, scope, *runtimeError) {
+// This is a wrapper around matchPattern, creating the scope for the body of the branch.
+// The identifiers bound by the pattern are put into that scope.
+func (c *Context) evalMatchBranchExpr(node ast.AstNode, sc scope, cond Value) (bool, scope, *RuntimeError) {
 	// Creating a new scope for the body of the target branch.
 	bodyScope := scope{
 		parent: &sc,
 		vars:   map[string]Value{},
 	}
+	matched, err := c.matchPattern(node, sc, bodyScope, cond)
+	return matched, bodyScope, err
+}
 
+// Matches value against the pattern given by node.
+// It handles the listed nodes in a special way, at any depth:
+//   - identifierNode: matches anything, and binds the value in bodyScope
+//   - underscoreNode: matches anything
+//   - enumNode: matches an enum with the same name and number of args, where every arg matches
+//   - listNode: matches a list of the same length, where every element matches.
+//     If the last element is a restNode ([head, ..tail]) the list might be longer,
+//     and the remaining elements are bound to the name of the restNode.
+//
+// Any other node is evaluated in sc, and compared with the value.
+func (c *Context) matchPattern(node ast.AstNode, sc scope, bodyScope scope, value Value) (bool, *RuntimeError) {
 	switch n := node.(type) {
 	case ast.IdentifierNode:
-		bodyScope.put(n.Payload, cond, n.Pos())
-		return underscorevalue, bodyScope, nil
+		return true, bodyScope.put(n.Payload, value, n.Pos())
+	case ast.UnderscoreNode:
+		return true, nil
 	case ast.EnumNode:
-		condArgs := getIndexValuesFromValue(cond, len(n.Args))
-		var err *runtimeError
-		elems := make([]Value, len(n.Args))
-		for i, elNode := range n.Args {
-			if i >= len(condArgs) {
-				// This is to prevent us from causing a panic with condArgs[i]
-				break
-			}
-			switch en := elNode.(type) {
-			case ast.IdentifierNode:
-				bodyScope.put(en.Payload, condArgs[i], n.Pos())
-				elems[i] = underscorevalue
-			default:
-				elems[i], err = c.evalExpr(elNode, sc)
-				if err != nil {
-					return nil, sc, err
-				}
+		enum, ok := value.(EnumValue)
+		if !ok || enum.parent != n.Parent || enum.name != n.Name || len(enum.args) != len(n.Args) {
+			return false, nil
+		}
+		for i, arg := range n.Args {
+			matched, err := c.matchPattern(arg, sc, bodyScope, enum.args[i])
+			if !matched || err != nil {
+				return false, err
 			}
 		}
-		return EnumValue{
-			name:   n.Name,
-			parent: n.Parent,
-			args:   elems,
-		}, bodyScope, nil
+		return true, nil
 	case ast.ListNode:
-		condArgs := getIndexValuesFromValue(cond, len(n.Elems))
-		listValue := make(ListValue, len(n.Elems))
-		for i, elNode := range n.Elems {
-			if i >= len(condArgs) {
-				// This is to prevent us from causing a panic with condArgs[i]
-				break
-			}
-			switch en := elNode.(type) {
-			case ast.IdentifierNode:
-				bodyScope.put(en.Payload, condArgs[i], n.Pos())
-				listValue[i] = underscorevalue
-			default:
-				v, err := c.evalExpr(elNode, sc)
-				listValue[i] = v
-				if err != nil {
-					return nil, sc, err
-				}
+		list, ok := value.(*ListValue)
+		if !ok {
+			return false, nil
+		}
+		elems := n.Elems
+		var rest *ast.RestNode
+		if len(elems) > 0 {
+			if r, ok := elems[len(elems)-1].(ast.RestNode); ok {
+				rest = &r
+				elems = elems[:len(elems)-1]
 			}
 		}
-		return &listValue, bodyScope, nil
+		if len(*list) < len(elems) || (rest == nil && len(*list) != len(elems)) {
+			return false, nil
+		}
+		for i, elem := range elems {
+			matched, err := c.matchPattern(elem, sc, bodyScope, (*list)[i])
+			if !matched || err != nil {
+				return false, err
+			}
+		}
+		if rest != nil && rest.Name != "" {
+			remaining := make(ListValue, len(*list)-len(elems))
+			copy(remaining, (*list)[len(elems):])
+			return true, bodyScope.put(rest.Name, &remaining, rest.Pos())
+		}
+		return true, nil
+	case ast.RestNode:
+		return false, &RuntimeError{
+			reason: fmt.Sprintf("%s can only be used as the last element of a list pattern", n),
+			Pos:    n.Pos(),
+		}
 	default:
 		v, err := c.evalExpr(node, sc)
-		return v, sc, err
+		if err != nil {
+			return false, err
+		}
+		return value.Eq(v), nil
 	}
 }
 
-func (c *Context) evalExpr(node ast.AstNode, sc scope) (Value, *runtimeError) {
+func (c *Context) evalImportNode(n ast.ImportNode, sc scope) (Value, *RuntimeError) {
+	mod, resolveErr := lib.ResolveModule(n.Name, n.Path, n.Pos().FileName())
+	if resolveErr != nil {
+		return nil, &RuntimeError{
+			reason: resolveErr.Error(),
+			Pos:    n.Pos(),
+		}
+	}
+
+	module, cached := c.modules[mod.Key]
+	if !cached {
+		for i, importing := range c.importing {
+			if importing.Key == mod.Key {
+				chain := []string{}
+				for _, m := range c.importing[i:] {
+					chain = append(chain, m.FileName)
+				}
+				chain = append(chain, mod.FileName)
+				return nil, &RuntimeError{
+					reason: fmt.Sprintf("Cyclic import: %s", strings.Join(chain, " -> ")),
+					Pos:    n.Pos(),
+				}
+			}
+		}
+
+		c.importing = append(c.importing, mod)
+		defer func() {
+			c.importing = c.importing[:len(c.importing)-1]
+		}()
+
+		tokenizer := ast.NewTokenizer(mod.Source, mod.FileName)
+		parser := ast.NewParser(tokenizer.Tokenize())
+		nodes, parseErr := parser.Parse()
+		if parseErr != nil {
+			return nil, &RuntimeError{
+				reason: fmt.Sprintf("Could not import %s:\n%s", mod.FileName, parseErr),
+				Pos:    n.Pos(),
+			}
+		}
+
+		// Modules get their own scope on top of the builtins and the base lib,
+		// so they do not see the variables of the program importing them.
+		module = ModuleValue{
+			name: mod.FileName,
+			scope: scope{
+				parent: &c.prelude,
+				vars:   map[string]Value{},
+			},
+		}
+		for _, node := range nodes {
+			if _, err := c.evalExpr(node, module.scope); err != nil {
+				return nil, err
+			}
+		}
+		c.modules[mod.Key] = module
+	}
+
+	if n.Name != "" {
+		if existing, ok := sc.vars[n.Name]; !ok || !existing.Eq(module) {
+			if err := sc.put(n.Name, module, n.Pos()); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return module, nil
+}
+
+func (c *Context) evalExpr(node ast.AstNode, sc scope) (Value, *RuntimeError) {
+	return c.evalExprTail(node, sc, false)
+}
+
+// Evaluates the node. If tail is true, the node is in tail position, and
+// a function call might be returned as a tailCallValue.
+func (c *Context) evalExprTail(node ast.AstNode, sc scope, tail bool) (Value, *RuntimeError) {
 	switch n := node.(type) {
 	case ast.IntNode:
 		return IntValue(n.Payload), nil
@@ -830,10 +1542,12 @@ func (c *Context) evalExpr(node ast.AstNode, sc scope) (Value, *runtimeError) {
 		return underscorevalue, nil
 	case ast.BinaryNode:
 		return c.evalBinaryNode(n, sc)
+	case ast.UnaryNode:
+		return c.evalUnaryNode(n, sc)
 	case ast.BoolNode:
 		return BoolValue(n.Payload), nil
 	case ast.MatchNode:
-		return c.evalMatchNode(n, sc)
+		return c.evalMatchNode(n, sc, tail)
 	case ast.IdentifierNode:
 		val, err := sc.get(n.Payload)
 		if err != nil {
@@ -850,7 +1564,7 @@ func (c *Context) evalExpr(node ast.AstNode, sc scope) (Value, *runtimeError) {
 			err := sc.put(left.Payload, assignedValue, n.Pos())
 			return assignedValue, err
 		default:
-			return nil, &runtimeError{
+			return nil, &RuntimeError{
 				reason: fmt.Sprintf("Invalid assignment target %s", left.String()),
 				Pos:    n.Pos(),
 			}
@@ -864,7 +1578,20 @@ func (c *Context) evalExpr(node ast.AstNode, sc scope) (Value, *runtimeError) {
 			}
 			args = append(args, v)
 		}
-		return c.evalFnCallNode(n, sc, args)
+		return c.evalFnCallNode(n, sc, args, tail)
+	case ast.MemberNode:
+		value, err := c.evalExpr(n.Module, sc)
+		if err != nil {
+			return nil, err
+		}
+		module, ok := value.(ModuleValue)
+		if !ok {
+			return nil, &RuntimeError{
+				reason: fmt.Sprintf("Cannot get %s from %s, which is not a module", n.Name, value),
+				Pos:    n.Pos(),
+			}
+		}
+		return module.get(n.Name, n.Pos())
 	case ast.BlockNode:
 		blockScope := scope{
 			parent: &sc,
@@ -877,9 +1604,9 @@ func (c *Context) evalExpr(node ast.AstNode, sc scope) (Value, *runtimeError) {
 				return nil, err
 			}
 		}
-		return c.evalExpr(n.Exprs[last], blockScope)
+		return c.evalExprTail(n.Exprs[last], blockScope, tail)
 	case ast.ListNode:
-		var err *runtimeError
+		var err *RuntimeError
 		elems := make([]Value, len(n.Elems))
 		for i, elNode := range n.Elems {
 			elems[i], err = c.evalExpr(elNode, sc)
@@ -889,8 +1616,22 @@ func (c *Context) evalExpr(node ast.AstNode, sc scope) (Value, *runtimeError) {
 		}
 		list := ListValue(elems)
 		return &list, nil
+	case ast.MapNode:
+		m := NewMapValue()
+		for i, keyNode := range n.Keys {
+			key, err := c.evalExpr(keyNode, sc)
+			if err != nil {
+				return nil, err
+			}
+			value, err := c.evalExpr(n.Values[i], sc)
+			if err != nil {
+				return nil, err
+			}
+			m.Set(key, value)
+		}
+		return m, nil
 	case ast.EnumNode:
-		var err *runtimeError
+		var err *RuntimeError
 		elems := make([]Value, len(n.Args))
 		for i, elNode := range n.Args {
 			elems[i], err = c.evalExpr(elNode, sc)
@@ -905,17 +1646,25 @@ func (c *Context) evalExpr(node ast.AstNode, sc scope) (Value, *runtimeError) {
 		}, nil
 
 	case ast.AliasNode:
-		var err *runtimeError
-		elems := make([]Value, len(n.Targets))
-		for i, elNode := range n.Targets {
-			elems[i], err = c.evalExpr(elNode, sc)
-			if err != nil {
+		// Type parameters match anything until the alias is given parameters
+		aliasScope := scope{
+			parent: &sc,
+			vars:   map[string]Value{},
+		}
+		for _, param := range n.Params {
+			if err := aliasScope.put(param, underscorevalue, n.Pos()); err != nil {
 				return nil, err
 			}
 		}
+		elems, err := c.evalAliasTargets(n, aliasScope)
+		if err != nil {
+			return nil, err
+		}
 		alias := AliasValue{
 			targets: elems,
 			scope:   sc,
+			params:  n.Params,
+			node:    &n,
 		}
 		err = sc.put(n.Name, alias, n.Pos())
 		return alias, err
@@ -924,13 +1673,20 @@ func (c *Context) evalExpr(node ast.AstNode, sc scope) (Value, *runtimeError) {
 			fn:    &n,
 			scope: sc,
 		}, nil
+	case ast.ImportNode:
+		return c.evalImportNode(n, sc)
+	case ast.RestNode:
+		return nil, &RuntimeError{
+			reason: fmt.Sprintf("%s can only be used in a list pattern in a match branch", n),
+			Pos:    n.Pos(),
+		}
 	}
 	panic(fmt.Sprintf("Unexpected astNode type: %s", node))
 }
 
-func (c *Context) evalNodes(nodes []ast.AstNode) (Value, *runtimeError) {
+func (c *Context) evalNodes(nodes []ast.AstNode) (Value, *RuntimeError) {
 	var returnValue Value = nil
-	var err *runtimeError
+	var err *RuntimeError
 	for _, expr := range nodes {
 		returnValue, err = c.evalExpr(expr, c.scope)
 		if err != nil {
@@ -1,10 +1,17 @@
 package eval
 
 import (
+	"dghaehre/raja/ast"
 	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func expectProgramToReturn(t *testing.T, program string, expected Value) {
@@ -142,6 +149,81 @@ func TestMatch(t *testing.T) {
 	expectProgramToReturn(t, p, StringValue("yes"))
 }
 
+func TestMatchGuard(t *testing.T) {
+	p := `
+	size = (a) => match a {
+		Maybe::Some(n) if n > 10 -> "big maybe"
+		Maybe::Some(_) -> "small maybe"
+		n if n > 10 -> "big"
+		_ -> "small"
+	}
+	[size(11), size(3), size(Maybe::Some(20)), size(Maybe::Some(1))]
+  `
+	expected := ListValue{StringValue("big"), StringValue("small"), StringValue("big maybe"), StringValue("small maybe")}
+	expectProgramToReturn(t, p, &expected)
+}
+
+func TestMatchGuardMustBeBool(t *testing.T) {
+	p := `
+	match 1 {
+		n if n + 1 -> "yes"
+		_ -> "no"
+	}
+  `
+	expectProgramToFail(t, p)
+}
+
+func TestNestedPatterns(t *testing.T) {
+	p := `
+	f = (a) => match a {
+		Result::Ok([x, Maybe::Some(y)]) -> x + y
+		[Maybe::Some(x), _] -> x
+		[[x, y], [z]] -> x + y + z
+		_ -> 0
+	}
+	[f(Result::Ok([1, Maybe::Some(2)])), f([Maybe::Some(5), 1]), f([[1, 2], [3]]), f(Result::Ok([1, Maybe::None]))]
+  `
+	expected := ListValue{IntValue(3), IntValue(5), IntValue(6), IntValue(0)}
+	expectProgramToReturn(t, p, &expected)
+}
+
+func TestListPatternLength(t *testing.T) {
+	p := `
+	f = (a) => match a {
+		[x, y] -> "two"
+		_ -> "other"
+	}
+	[f([1, 2]), f([1, 2, 3]), f([1])]
+  `
+	expected := ListValue{StringValue("two"), StringValue("other"), StringValue("other")}
+	expectProgramToReturn(t, p, &expected)
+}
+
+func TestRestPattern(t *testing.T) {
+	p := `
+	my_sum = (l) => match l {
+		[] -> 0
+		[head, ..tail] -> my_sum(tail) + head
+	}
+	second = (l) => match l {
+		[_, x, .._] -> Maybe::Some(x)
+		_ -> Maybe::None
+	}
+	[my_sum([1, 2, 3]), second([1]), second([1, 2]), second([1, 2, 3])]
+  `
+	expected := ListValue{IntValue(6), toNone(), toSome(IntValue(2)), toSome(IntValue(2))}
+	expectProgramToReturn(t, p, &expected)
+}
+
+func TestRestPatternMustBeLast(t *testing.T) {
+	p := `
+	match [1, 2] {
+		[..init, last] -> last
+	}
+  `
+	expectProgramToFail(t, p)
+}
+
 func TestMutableVariable(t *testing.T) {
 	p := `
   mut_x = 1
@@ -305,3 +387,1244 @@ func TestBaseMergeHelperSort(t *testing.T) {
 `
 	expectProgramToReturn(t, p, &ListValue{IntValue(1), IntValue(2), IntValue(3), IntValue(4), IntValue(8), IntValue(9)})
 }
+
+func TestContextKeepsDefinitionsBetweenEvals(t *testing.T) {
+	ctx := NewContext()
+	ctx.LoadBuiltins()
+	lines := []string{
+		`double = (a:Int) => a * 2`,
+		`double = (a:Str) => a ++ a`,
+		`x = 21`,
+	}
+	for _, line := range lines {
+		if _, err := ctx.Eval(strings.NewReader(line), "test"); err != nil {
+			t.Fatalf("Did not expect %s to fail: %s", strconv.Quote(line), err)
+		}
+	}
+	val, err := ctx.Eval(strings.NewReader(`[x.double(), "a".double()]`), "test")
+	if err != nil {
+		t.Fatalf("Did not expect program to exit with error: %s", err.Error())
+	}
+	expected := &ListValue{IntValue(42), StringValue("aa")}
+	if !val.Eq(expected) {
+		t.Errorf("Expected and returned values don't match: %s != %s", expected, val)
+	}
+}
+
+func TestRuntimeErrorStackTrace(t *testing.T) {
+	p := `
+# The calls are not in tail position, so they all show up in the stack trace
+inner = (l:List) => {
+	v = __index(l, "not an int", true)
+	v
+}
+outer = (l:List) => {
+	v = inner(l)
+	v
+}
+outer([1, 2])
+`
+	ctx := NewContext()
+	ctx.LoadBuiltins()
+	_, err := ctx.Eval(strings.NewReader(p), "test")
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		t.Fatalf("Expected a runtime error, got: %v", err)
+	}
+	names := []string{}
+	for _, entry := range runtimeErr.stackTrace {
+		names = append(names, entry.name)
+	}
+	expected := []string{"__index", "inner", "outer"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected stack trace %v, got %v", expected, names)
+	}
+	if !runtimeErr.stackTrace[0].builtin {
+		t.Errorf("Expected innermost entry to be a builtin")
+	}
+	if runtimeErr.Pos == (ast.Pos{}) {
+		t.Errorf("Expected error from builtin to have a position")
+	}
+	if len(ctx.stack) != 0 {
+		t.Errorf("Expected call stack to be empty after evaluation, got %d entries", len(ctx.stack))
+	}
+}
+
+func TestTailCallDoesNotGrowStack(t *testing.T) {
+	p := `
+	range(1, 1000000).length()
+	`
+	expectProgramToReturn(t, p, IntValue(1000000))
+
+	count := `
+	count_down = (n:Int) => match n {
+		0 -> "done"
+		_ -> {
+			next = n - 1
+			count_down(next)
+		}
+	}
+	count_down(1000000)
+	`
+	expectProgramToReturn(t, count, StringValue("done"))
+}
+
+func writeModules(t *testing.T, files map[string]string) string {
+	dir := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestImport(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"util/shapes.raja": `
+helpers = import "helpers.raja"
+alias Shape = Shape::Square(_) | Shape::Circle(_)
+area = (s:Shape) => match s {
+	Shape::Square(a) -> helpers.square(a)
+	Shape::Circle(r) -> 3 * helpers.square(r)
+}
+`,
+		"util/helpers.raja": `square = (a:Num) => a * a`,
+	})
+	ctx := NewContext()
+	ctx.LoadBuiltins()
+	_, err := ctx.Eval(strings.NewReader(`import does_not_exist`), filepath.Join(dir, "main.raja"))
+	if err == nil {
+		t.Errorf("Expected import of a missing module to fail")
+	}
+
+	p := `
+shapes = import "util/shapes.raja"
+again = import "util/shapes.raja"
+[shapes.area(Shape::Square(2)), again.area(Shape::Circle(1))]
+`
+	ctx = NewContext()
+	ctx.LoadBuiltins()
+	val, err := ctx.Eval(strings.NewReader(p), filepath.Join(dir, "main.raja"))
+	if err != nil {
+		t.Fatalf("Did not expect program to exit with error: %s", err.Error())
+	}
+	expected := &ListValue{IntValue(4), IntValue(3)}
+	if !val.Eq(expected) {
+		t.Errorf("Expected and returned values don't match: %s != %s", expected, val)
+	}
+	if len(ctx.modules) != 2 {
+		t.Errorf("Expected each module to be evaluated once, got %d modules", len(ctx.modules))
+	}
+
+	p = `
+import helpers
+helpers.square(3)
+`
+	ctx = NewContext()
+	ctx.LoadBuiltins()
+	val, err = ctx.Eval(strings.NewReader(p), filepath.Join(dir, "util", "main.raja"))
+	if err != nil {
+		t.Fatalf("Did not expect program to exit with error: %s", err.Error())
+	}
+	if !val.Eq(IntValue(9)) {
+		t.Errorf("Expected 9, got %s", val)
+	}
+}
+
+func TestImportScope(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"counter.raja": `
+count = (l:List) => l.length()
+peek = () => secret
+`,
+	})
+
+	// A module given as an argument is not what the call is looked up in
+	p := `
+import counter
+id = (a) => a
+[id(counter).count([1, 2]), counter.count([])]
+`
+	ctx := NewContext()
+	ctx.LoadBuiltins()
+	val, err := ctx.Eval(strings.NewReader(p), filepath.Join(dir, "main.raja"))
+	if err != nil {
+		t.Fatalf("Did not expect program to exit with error: %s", err.Error())
+	}
+	expected := &ListValue{IntValue(2), IntValue(0)}
+	if !val.Eq(expected) {
+		t.Errorf("Expected and returned values don't match: %s != %s", expected, val)
+	}
+
+	// Modules see the builtins and the base lib, but not the globals of the importer
+	p = `
+secret = 1
+import counter
+counter.peek()
+`
+	ctx = NewContext()
+	ctx.LoadBuiltins()
+	_, err = ctx.Eval(strings.NewReader(p), filepath.Join(dir, "main.raja"))
+	if err == nil || !strings.Contains(err.Error(), "secret is undefined") {
+		t.Errorf("Expected secret to be undefined in the module, got: %v", err)
+	}
+}
+
+func TestCyclicImport(t *testing.T) {
+	dir := writeModules(t, map[string]string{
+		"a.raja": `import b`,
+		"b.raja": `import a`,
+	})
+	ctx := NewContext()
+	ctx.LoadBuiltins()
+	_, err := ctx.Eval(strings.NewReader(`import a`), filepath.Join(dir, "main.raja"))
+	if err == nil || !strings.Contains(err.Error(), "Cyclic import") {
+		t.Errorf("Expected a cyclic import error, got: %v", err)
+	}
+}
+
+func TestMap(t *testing.T) {
+	p := `
+m = {"a": 1, "b": 2}
+m2 = m.insert("c", 3).remove("a")
+[m.get("a"), m.get("c"), m2.get("c"), m2.keys(), m2.values(), m.has?("b"), m.length()]`
+	expected := ListValue{
+		toSome(IntValue(1)),
+		toNone(),
+		toSome(IntValue(3)),
+		&ListValue{StringValue("b"), StringValue("c")},
+		&ListValue{IntValue(2), IntValue(3)},
+		BoolValue(true),
+		IntValue(2),
+	}
+	expectProgramToReturn(t, p, &expected)
+}
+
+func TestMapEq(t *testing.T) {
+	p := `
+[{"a": 1, "b": [1, 2]} == {"b": [1, 2], "a": 1}, {1: "x"} == {1: "y"}, {} == {}]`
+	expected := ListValue{BoolValue(true), BoolValue(false), BoolValue(true)}
+	expectProgramToReturn(t, p, &expected)
+}
+
+func TestMapKeysDoNotCollide(t *testing.T) {
+	p := `
+m = {["a, b"]: 1}.insert(["a", "b"], 2).insert([char("a")], 3).insert(["a"], 4)
+[m.get(["a, b"]), m.get(["a", "b"]), m.get([char("a")]), m.get(["a"]), m.length(), {1.0: "x"}.get(1)]`
+	expected := ListValue{
+		toSome(IntValue(1)),
+		toSome(IntValue(2)),
+		toSome(IntValue(3)),
+		toSome(IntValue(4)),
+		IntValue(4),
+		toSome(StringValue("x")),
+	}
+	expectProgramToReturn(t, p, &expected)
+}
+
+func TestMapFoldAndDispatch(t *testing.T) {
+	p := `
+count = (l:List) => l.fold({}, (acc, e) => acc.insert(e, acc.get(e).unwrap_or(0) + 1))
+counted = count(["x", "y", "x"])
+describe = (m:Map) => "map"
+describe = (l:List) => "list"
+[counted, counted.fold(0, (acc, entry) => acc + entry.get_unsafe(1)), describe(counted), describe([])]`
+	counted := NewMapValue().Insert(StringValue("x"), IntValue(2)).Insert(StringValue("y"), IntValue(1))
+	expected := ListValue{counted, IntValue(3), StringValue("map"), StringValue("list")}
+	expectProgramToReturn(t, p, &expected)
+}
+
+func TestBlockIsNotMap(t *testing.T) {
+	p := `
+b = {
+  x = 1
+  x + 1
+}
+b`
+	expectProgramToReturn(t, p, IntValue(2))
+}
+
+func TestParametricDispatch(t *testing.T) {
+	p := `
+	f = (l:List(Int)) => "ints"
+	f = (l:List(Str)) => "strs"
+	f = (l:List) => "other"
+	g = (m:Maybe(Str)) => "str"
+	g = (m) => "other"
+	h = (m:Map(Str, List(Int))) => "str to ints"
+	h = (m) => "other"
+	[f([1, 2]), f(["a"]), f([1, "a"]), g(Maybe::Some("a")), g(Maybe::Some(1)), h({"a": [1]}), h({"a": ["b"]})]
+  `
+	expected := ListValue{
+		StringValue("ints"), StringValue("strs"), StringValue("other"),
+		StringValue("str"), StringValue("other"),
+		StringValue("str to ints"), StringValue("other"),
+	}
+	expectProgramToReturn(t, p, &expected)
+}
+
+func TestParametricAlias(t *testing.T) {
+	p := `
+	alias Pair(a) = [a, a]
+	alias Grid = List(List(Int))
+	f = (p:Pair(Int)) => "pair"
+	f = (g:Grid) => "grid"
+	f = (a) => "other"
+	[f([1, 2]), f([[1], [2, 3]]), f([1, "a"]), f([["a"]])]
+  `
+	expected := ListValue{StringValue("pair"), StringValue("grid"), StringValue("other"), StringValue("other")}
+	expectProgramToReturn(t, p, &expected)
+}
+
+func TestTypeVariables(t *testing.T) {
+	p := `
+	a = Int
+	first = (l:List(a)) => l.get(0).unwrap()
+	first(["a lowercase type is never looked up"])
+  `
+	expectProgramToReturn(t, p, StringValue("a lowercase type is never looked up"))
+}
+
+func TestUnknownLowercaseType(t *testing.T) {
+	expectProgramToFail(t, `
+	f = (i:int) => i
+	f(1)
+  `)
+	p := `
+	alias num = Int | Float
+	f = (n:num) => "num"
+	f = (s) => "other"
+	[f(1), f("a")]
+  `
+	expected := ListValue{StringValue("num"), StringValue("other")}
+	expectProgramToReturn(t, p, &expected)
+}
+
+func TestWrongTypeParameters(t *testing.T) {
+	expectProgramToFail(t, `
+	f = (l:List(Int, Str)) => l
+	f([1])
+  `)
+	expectProgramToFail(t, `
+	f = (i:Int(Str)) => i
+	f(1)
+  `)
+}
+
+func TestTypeVariablesAreNotSpecific(t *testing.T) {
+	p := `
+	f = (l:List(a), b) => "type variable"
+	f = (l:List, b:Int) => "int"
+	f([1], 2)
+  `
+	expectProgramToReturn(t, p, StringValue("int"))
+}
+
+func TestReturnType(t *testing.T) {
+	p := `
+	sum_to = (n:Int, acc:Int) -> Int => match n {
+		0 -> acc
+		_ -> sum_to(n - 1, acc + n)
+	}
+	first = (l:List(a)) -> a => l.get(0).unwrap()
+	[sum_to(10, 0), first(["a"])]
+  `
+	expectProgramToReturn(t, p, &ListValue{IntValue(55), StringValue("a")})
+}
+
+func TestWrongReturnType(t *testing.T) {
+	expectProgramToFail(t, `
+	f = (a) -> Str => a
+	f(1)
+  `)
+	// The tail call returns the value on behalf of f
+	expectProgramToFail(t, `
+	g = (a) => a
+	f = (a) -> Str => g(a)
+	f(1)
+  `)
+}
+
+func TestRunTests(t *testing.T) {
+	p := `square = (a:Num) => a * a
+
+test_square = () => assert_eq(square(3), 9)
+test_wrong = () => {
+	assert(true, "fine")
+	assert_eq(square(3), 10)
+	true
+}
+test_runtime_error = () => square("a")
+test_with_param = (a) => a
+not_a_test = () => fail("not run")
+`
+	tests, err := FindTests(p, "square_test.raja")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := []string{}
+	for _, test := range tests {
+		names = append(names, test.Name)
+	}
+	expected := []string{"test_square", "test_wrong", "test_runtime_error"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected tests %v, got %v", expected, names)
+	}
+
+	file := LoadTestFile(p, "square_test.raja")
+	if err := file.Run(tests[0]); err != nil {
+		t.Errorf("Expected %s to pass, got: %s", tests[0].Name, err)
+	}
+
+	err = file.Run(tests[1])
+	failure, ok := err.(*TestFailure)
+	if !ok {
+		t.Fatalf("Expected %s to fail, got: %v", tests[1].Name, err)
+	}
+	if failure.Failed.Line() != 6 {
+		t.Errorf("Expected failure at the assert_eq on line 6, got %s", failure.Failed)
+	}
+	if !strings.Contains(failure.Error(), "left:  9") || !strings.Contains(failure.Error(), "right: 10") {
+		t.Errorf("Expected both values in the failure, got: %s", failure)
+	}
+
+	err = file.Run(tests[2])
+	failure, ok = err.(*TestFailure)
+	if !ok {
+		t.Fatalf("Expected %s to fail, got: %v", tests[2].Name, err)
+	}
+	if failure.Failed.Line() != 9 {
+		t.Errorf("Expected failure on line 9, got %s", failure.Failed)
+	}
+}
+
+// The file is evaluated once, and each test starts from the globals it defined
+func TestRunTestsIsolated(t *testing.T) {
+	p := `println("loaded")
+mut_count = 0
+bump = () => mut_count.update(mut_count + 1)
+
+test_first = () => {
+	bump()
+	assert_eq(mut_count, 1)
+}
+test_second = () => {
+	bump()
+	assert_eq(mut_count, 1)
+}
+`
+	tests, err := FindTests(p, "count_test.raja")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out strings.Builder
+	file := LoadTestFile(p, "count_test.raja", WithStdout(&out))
+	for _, test := range tests {
+		if err := file.Run(test); err != nil {
+			t.Errorf("Expected %s to pass, got: %s", test.Name, err)
+		}
+	}
+	if out.String() != "loaded\n" {
+		t.Errorf("Expected the file to be evaluated once, got output %q", out.String())
+	}
+}
+
+func TestAssertEq(t *testing.T) {
+	expectProgramToReturn(t, `assert_eq([1, 2], [1, 2])`, &ListValue{IntValue(1), IntValue(2)})
+	expectProgramToFail(t, `assert_eq([1, 2], [2, 1])`)
+	expectProgramToFail(t, `fail("failed")`)
+}
+
+func TestExitUnwinds(t *testing.T) {
+	ctx := NewContext()
+	ctx.LoadBuiltins()
+	_, err := ctx.Eval(strings.NewReader(`
+	f = () => exit(3)
+	f()
+	fail("not reached")
+	`), "test")
+	exit, ok := err.(*ExitError)
+	if !ok {
+		t.Fatalf("Expected an ExitError, got: %v", err)
+	}
+	if exit.Code != 3 || exit.Panic {
+		t.Errorf("Expected exit code 3 without a panic, got %d %v", exit.Code, exit.Panic)
+	}
+	if len(ctx.stack) != 0 {
+		t.Errorf("Expected call stack to be empty after exit, got %d entries", len(ctx.stack))
+	}
+}
+
+func TestPanicUnwinds(t *testing.T) {
+	ctx := NewContext()
+	ctx.LoadBuiltins()
+	_, err := ctx.Eval(strings.NewReader(`Maybe::None.unwrap()`), "test")
+	exit, ok := err.(*ExitError)
+	if !ok {
+		t.Fatalf("Expected an ExitError, got: %v", err)
+	}
+	if exit.Code != 1 || !exit.Panic || exit.Message != "Trying to unwrap Maybe::None" {
+		t.Errorf("Expected exit code 1 with the panic message, got %d %q", exit.Code, exit.Message)
+	}
+}
+
+func TestTry(t *testing.T) {
+	expectProgramToReturn(t, `try(() => 1 + 1)`, toOk(IntValue(2)))
+	expectProgramToReturn(t, `try(() => panic("oh no", 2))`, toErr(StringValue("oh no 2")))
+	expectProgramToReturn(t, `
+	check = (n:Int) => match n {
+		0 -> panic("zero")
+		_ -> n
+	}
+	[0, 1].map((n) => try(() => check(n)))
+	`, &ListValue{toErr(StringValue("zero")), toOk(IntValue(1))})
+	expectProgramToReturn(t, `try(() => panic(""))`, toErr(StringValue("")))
+
+	// Only panics are caught
+	expectProgramToFail(t, `try(() => 1 + "a")`)
+	expectProgramToFail(t, `try(() => exit(0))`)
+	expectProgramToFail(t, `try((a) => a)`)
+}
+
+func TestToValueAndFromValue(t *testing.T) {
+	type point struct {
+		X      int     `raja:"x"`
+		Y      float64 `raja:"y"`
+		Label  string
+		hidden bool
+	}
+	v, err := ToValue(map[string]any{
+		"points": []point{{X: 1, Y: 2.5, Label: "a"}},
+		"ok":     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{ok: true, points: [{x: 1, y: 2.5, Label: a}]}`
+	if v.String() != expected {
+		t.Errorf("Expected %s, got %s", expected, v)
+	}
+
+	var back struct {
+		Points []point `raja:"points"`
+		Ok     bool    `raja:"ok"`
+	}
+	if err := FromValue(v, &back); err != nil {
+		t.Fatal(err)
+	}
+	if !back.Ok || len(back.Points) != 1 || back.Points[0].X != 1 || back.Points[0].Y != 2.5 || back.Points[0].Label != "a" {
+		t.Errorf("Did not convert back to the same value: %+v", back)
+	}
+
+	var natural any
+	if err := FromValue(&ListValue{IntValue(1), StringValue("a"), toNone()}, &natural); err != nil {
+		t.Fatal(err)
+	}
+	list, ok := natural.([]any)
+	if !ok || list[0] != int64(1) || list[1] != "a" || !toNone().Eq(list[2].(Value)) {
+		t.Errorf("Expected []any{1, \"a\", Maybe::None}, got %#v", natural)
+	}
+
+	var n int8
+	if err := FromValue(IntValue(1000), &n); err == nil {
+		t.Errorf("Expected an error converting 1000 to int8")
+	}
+	var s string
+	if err := FromValue(IntValue(1), &s); err == nil {
+		t.Errorf("Expected an error converting Int to string")
+	}
+	if _, err := ToValue(make(chan int)); err == nil {
+		t.Errorf("Expected an error converting a channel")
+	}
+
+	large, _ := new(big.Int).SetString("100000000000000000000", 10)
+	v, err = ToValue(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var backLarge *big.Int
+	if err := FromValue(v, &backLarge); err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "100000000000000000000" || backLarge.Cmp(large) != 0 {
+		t.Errorf("Expected %s to convert back and forth, got %s and %s", large, v, backLarge)
+	}
+	if v, err := ToValue(big.NewInt(5)); err != nil || v != IntValue(5) {
+		t.Errorf("Expected a small big.Int to be an Int, got %s", v)
+	}
+}
+
+func TestEmbedding(t *testing.T) {
+	ctx := NewContext()
+	ctx.LoadBuiltins()
+	ctx.LoadFunc("__double", func(_ string, args []Value) (Value, *RuntimeError) {
+		n, ok := args[0].(IntValue)
+		if !ok {
+			return nil, NewRuntimeError("double needs an Int, got %s", args[0])
+		}
+		return n * 2, nil
+	})
+	ctx.LoadFunc("__apply", func(_ string, args []Value) (Value, *RuntimeError) {
+		v, err := ctx.CallValue(args[0], args[1])
+		if err != nil {
+			return nil, NewRuntimeError("%s", err)
+		}
+		return v, nil
+	})
+	if err := ctx.Set("offset", 10); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ctx.Eval(strings.NewReader(`
+	describe = (a:Int) => __double(a) + offset
+	describe = (a:Str) => a ++ "!"
+	describe = (a:List) => a.map(describe)
+	apply_twice = (f:Fn, a) => __apply(f, __apply(f, a))
+	stop = () => panic("stopped")
+	`), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for arg, expected := range map[any]string{21: "52", "hi": "hi!"} {
+		v, err := ctx.Call("describe", arg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.String() != expected {
+			t.Errorf("Expected describe(%v) to be %s, got %s", arg, expected, v)
+		}
+	}
+	v, err := ctx.Call("describe", []any{1, "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var described []any
+	if err := FromValue(v, &described); err != nil {
+		t.Fatal(err)
+	}
+	if described[0] != int64(12) || described[1] != "a!" {
+		t.Errorf("Expected [12, a!], got %v", described)
+	}
+
+	double, _ := ctx.Get("describe")
+	v, err = ctx.Call("apply_twice", double, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !v.Eq(IntValue(34)) {
+		t.Errorf("Expected 34, got %s", v)
+	}
+
+	if _, err := ctx.Call("describe", 1.5); err == nil {
+		t.Errorf("Expected no describe to match a Float")
+	}
+	if _, err := ctx.Call("undefined"); err == nil {
+		t.Errorf("Expected an error calling an undefined function")
+	}
+	if _, err := ctx.Call("stop"); err == nil || err.(*ExitError).Message != "stopped" {
+		t.Errorf("Expected an ExitError from the panic, got %v", err)
+	}
+	if len(ctx.stack) != 0 {
+		t.Errorf("Expected call stack to be empty after the calls, got %d entries", len(ctx.stack))
+	}
+}
+
+func expectProgramToPrint(t *testing.T, program string, expected string, opts ...Option) {
+	var out strings.Builder
+	ctx := NewContext(append([]Option{WithStdout(&out)}, opts...)...)
+	ctx.LoadBuiltins()
+	_, err := ctx.Eval(strings.NewReader(program), "test")
+	if err != nil {
+		t.Errorf("Did not expect program to exit with error: %s", err.Error())
+	}
+	if out.String() != expected {
+		t.Errorf("Expected program to print %s, but it printed %s", strconv.Quote(expected), strconv.Quote(out.String()))
+	}
+}
+
+func TestPrintedOutput(t *testing.T) {
+	for example, expected := range map[string]string{
+		"hello_world.raja": "hello world!",
+		"print.raja":       "hello world",
+		"dot.raja":         "The answer is: 3\n",
+	} {
+		program, err := os.ReadFile(filepath.Join("..", "examples", example))
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectProgramToPrint(t, string(program), expected)
+	}
+	expectProgramToPrint(t, `[1, 2].println()
+	println("a", 1)`, "[1, 2]\na 1\n")
+}
+
+func TestConfiguredIO(t *testing.T) {
+	expectProgramToPrint(t, `get_args().println()`, "[raja, script.raja, -v]\n",
+		WithArgs([]string{"raja", "script.raja", "-v"}))
+
+	var stderr strings.Builder
+	expectProgramToPrint(t, `
+	eprintln("to stderr")
+	println("to stdout")
+	`, "to stdout\n", WithStderr(&stderr))
+	if stderr.String() != "to stderr\n" {
+		t.Errorf("Expected %q on stderr, got %q", "to stderr\n", stderr.String())
+	}
+}
+
+func TestReadStdin(t *testing.T) {
+	stdin := WithStdin(strings.NewReader("first\r\nsecond\nthird"))
+	expectProgramToPrint(t, `
+	read_line().println()
+	read_all_stdin().println()
+	read_line().println()
+	`, "Maybe::Some(first)\nResult::Ok(second\nthird)\nMaybe::None\n", stdin)
+
+	expectProgramToPrint(t, `lines().println()`, "[1, 2, 3]\n", WithStdin(strings.NewReader("1\n2\n3\n")))
+	expectProgramToPrint(t, `
+	lines(0, (sum, line) => line.int().unwrap() + sum).println()
+	`, "6\n", WithStdin(strings.NewReader("1\n2\n3\n")))
+
+	// Each line is given to the function before the next line is read
+	expectProgramToPrint(t, `
+	lines((line) => {
+		println(line)
+		read_line()
+	}).println()
+	`, "1\n3\n[Maybe::Some(2), Maybe::None]\n", WithStdin(strings.NewReader("1\n2\n3\n")))
+
+	// A list returned by f is one element, and is not flattened
+	expectProgramToPrint(t, `
+	lines((line) => line.split_by(" ")).println()
+	`, "[[a, b], [c]]\n", WithStdin(strings.NewReader("a b\nc\n")))
+
+	// The list is built in one go, and not copied for every line
+	many := strings.Repeat("line\n", 100000)
+	expectProgramToPrint(t, `lines().length().println()`, "100000\n", WithStdin(strings.NewReader(many)))
+}
+
+func TestFsModule(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	p := fmt.Sprintf(`
+	import fs
+	dir = %q
+	file = dir ++ "/notes.txt"
+	fs.mkdir_all(dir).unwrap()
+	fs.write_file(file, "one").unwrap()
+	fs.append_file(file, " two").unwrap()
+	info = fs.file_info(file).unwrap()
+	[
+		fs.read_file(file).unwrap(),
+		fs.list_dir(dir).unwrap(),
+		fs.exists?(file).unwrap(),
+		info.get("size").unwrap(),
+		info.get("is_dir").unwrap(),
+		fs.remove(file).unwrap(),
+		fs.exists?(file).unwrap()
+	]
+	`, dir)
+	file := filepath.Join(dir, "notes.txt")
+	expected := &ListValue{
+		StringValue("one two"),
+		&ListValue{StringValue("notes.txt")},
+		BoolValue(true),
+		IntValue(7),
+		BoolValue(false),
+		StringValue(file),
+		BoolValue(false),
+	}
+	expectProgramToReturn(t, p, expected)
+
+	missing := filepath.Join(dir, "missing")
+	expectProgramToReturn(t, fmt.Sprintf(`
+	import fs
+	match fs.remove(%q) {
+		Result::Err(_) -> "failed"
+		Result::Ok(_)  -> "removed"
+	}
+	`, missing), StringValue("failed"))
+}
+
+func TestStringsModule(t *testing.T) {
+	p := `
+	import strings
+	[
+		strings.split("a,,b", ","),
+		strings.join(["a", "b", "c"], ", "),
+		strings.replace("a-b-c", "-", "+"),
+		strings.contains?("hello", "ell"),
+		strings.index_of("hello", "l"),
+		strings.index_of("hello", "x"),
+		strings.to_upper("abc"),
+		strings.trim("  abc \n"),
+		strings.trim("xxabcxx", "x"),
+		strings.trim_prefix("main.raja", "main"),
+		strings.repeat("ab", 3),
+		strings.pad_left("7", 3, "0"),
+		strings.pad_right("ab", 4),
+		strings.lines("one\r\ntwo\n"),
+		strings.chars("hé"),
+		strings.ends_with?("main.raja", ".raja")
+	]
+	`
+	expected := &ListValue{
+		&ListValue{StringValue("a"), StringValue(""), StringValue("b")},
+		StringValue("a, b, c"),
+		StringValue("a+b+c"),
+		BoolValue(true),
+		toSome(IntValue(2)),
+		toNone(),
+		StringValue("ABC"),
+		StringValue("abc"),
+		StringValue("abc"),
+		StringValue(".raja"),
+		StringValue("ababab"),
+		StringValue("007"),
+		StringValue("ab  "),
+		&ListValue{StringValue("one"), StringValue("two")},
+		&ListValue{StringValue("h"), StringValue("é")},
+		BoolValue(true),
+	}
+	expectProgramToReturn(t, p, expected)
+	expectProgramToFail(t, `
+	import strings
+	strings.repeat("a", -1)
+	`)
+}
+
+func TestBaseSplitByAndTrimEdgeCases(t *testing.T) {
+	p := `[",a,,b,".split_by(","), "".split_by(","), "".trim(), " \t".trim_left()]`
+	expected := &ListValue{
+		&ListValue{StringValue("a"), StringValue("b")},
+		&ListValue{},
+		StringValue(""),
+		StringValue(""),
+	}
+	expectProgramToReturn(t, p, expected)
+}
+
+// split_by and trim as they were written in raja, before they used the strings builtins
+const recursiveStrFunctions = `
+recursive_has_prefix_at? = (a:Str, prefix:Str, i:Int) => a.tail(i).take(prefix.length()) == prefix
+
+recursive_split_by = (a:Str, by:Str, acc:List, i:Int) => match a.recursive_has_prefix_at?(by, i) {
+	true  -> {
+		is_ending = i + by.length() == a.length()
+		match is_ending {
+			true  -> acc
+			false -> a.recursive_split_by(by, [acc, []], i + by.length())
+		}
+	}
+	false -> match a.get(i) {
+		Maybe::Some(v) -> match acc.length() {
+			0 -> a.recursive_split_by(by, [v], i + 1)
+			_ -> {
+				newacc = acc.map_last((x) => x ++ (a.get(i).unwrap()))
+				a.recursive_split_by(by, newacc, i + 1)
+			}
+		}
+		Maybe::None    -> acc
+	}
+}
+recursive_split_by = (a:Str, by:Str) => a.recursive_split_by(by, [], 0)
+
+recursive_trim_left = (a:Str, i:Int) => match a.get(i).map(is_whitespace?) {
+	Maybe::Some(true) -> a.recursive_trim_left(i + 1)
+	_                 -> a.tail(i)
+}
+recursive_trim_right = (a:Str, i:Int) => match a.get(i).map(is_whitespace?) {
+	Maybe::Some(true) -> recursive_trim_right(a, i - 1)
+	_                 -> a.take(i + 1)
+}
+recursive_trim = (a:Str) => a.recursive_trim_left(0).recursive_trim_right(a.recursive_trim_left(0).length() - 1)
+`
+
+// The AoC example, reading the first n lines of its input, or all of it when n is 0.
+// The recursive split_by takes minutes on all of it
+func aocExample(b *testing.B, n int) string {
+	program, err := os.ReadFile(filepath.Join("..", "examples", "aoc", "aoc-2022-01.raja"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	input, err := os.ReadFile(filepath.Join("..", "examples", "aoc", "aoc-input.txt"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	lines := strings.SplitAfter(string(input), "\n")
+	if n > 0 {
+		lines = lines[:n]
+	}
+	path := filepath.Join(b.TempDir(), "aoc-input.txt")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "")), 0o644); err != nil {
+		b.Fatal(err)
+	}
+	return strings.Replace(string(program), "./examples/aoc/aoc-input.txt", path, 1)
+}
+
+// The AoC example using split_by and trim as they were written in raja
+func aocExampleRecursive(b *testing.B, n int) string {
+	program := aocExample(b, n)
+	program = strings.ReplaceAll(program, "split_by(", "recursive_split_by(")
+	program = strings.ReplaceAll(program, "trim()", "recursive_trim()")
+	return recursiveStrFunctions + program
+}
+
+func runProgram(b *testing.B, program string) {
+	ctx := NewContext(WithStdout(io.Discard))
+	ctx.LoadBuiltins()
+	if _, err := ctx.Eval(strings.NewReader(program), "bench"); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func benchmarkProgram(b *testing.B, program string) {
+	for i := 0; i < b.N; i++ {
+		runProgram(b, program)
+	}
+}
+
+// Compare with BenchmarkAocExampleRecursive: go test -bench Aoc ./eval
+func BenchmarkAocExample(b *testing.B) {
+	benchmarkProgram(b, aocExample(b, 100))
+}
+
+func BenchmarkAocExampleRecursive(b *testing.B) {
+	benchmarkProgram(b, aocExampleRecursive(b, 100))
+}
+
+// Runs all of the AoC input once with the strings builtins and once with the
+// recursive functions, and fails if the builtins are not at least 10 times faster.
+// The recursive functions take about 20 minutes, which is more than the default timeout:
+// go test -run '^$' -bench AocSpeedup -benchtime 1x -timeout 60m ./eval
+func BenchmarkAocSpeedup(b *testing.B) {
+	program := aocExample(b, 0)
+	recursive := aocExampleRecursive(b, 0)
+	for i := 0; i < b.N; i++ {
+		start := time.Now()
+		runProgram(b, program)
+		builtins := time.Since(start)
+
+		start = time.Now()
+		runProgram(b, recursive)
+		speedup := float64(time.Since(start)) / float64(builtins)
+
+		b.ReportMetric(speedup, "speedup")
+		if speedup < 10 {
+			b.Fatalf("Expected the strings builtins to be at least 10x faster, got %.1fx", speedup)
+		}
+	}
+}
+
+func TestUnicodeStrings(t *testing.T) {
+	p := `
+	s = "æøå"
+	[s.length(), s.get(1).unwrap(), s.take(2), s.tail(1), s.byte_length(), s.get(3), "hé".bytes()]
+	`
+	expected := &ListValue{
+		IntValue(3),
+		StringValue("ø"),
+		StringValue("æø"),
+		StringValue("øå"),
+		IntValue(6),
+		toNone(),
+		&ListValue{IntValue(104), IntValue(195), IntValue(169)},
+	}
+	expectProgramToReturn(t, p, expected)
+	expectProgramToReturn(t, `"æøå".map((c) => c ++ "-")`, StringValue("æ-ø-å-"))
+	// Looking up characters out of order, and in more than one Str at a time
+	expectProgramToReturn(t, `
+	s = "æøå"
+	a = "abc"
+	[s.get(2), a.get(1), s.get(1), s.get(0), a.get(3), s.get(3), s.get(1)]
+	`, &ListValue{
+		toSome(StringValue("å")),
+		toSome(StringValue("b")),
+		toSome(StringValue("ø")),
+		toSome(StringValue("æ")),
+		toNone(),
+		toNone(),
+		toSome(StringValue("ø")),
+	})
+	expectProgramToReturn(t, `
+	import strings
+	s = "æøå"
+	s.get(strings.index_of(s, "å").unwrap())
+	`, toSome(StringValue("å")))
+	expectProgramToReturn(t, `"\u{1F600}\u{e6}x"`, StringValue("😀æx"))
+	expectProgramToFail(t, `"\u{110000}"`)
+	expectProgramToFail(t, `"\u{e6"`)
+}
+
+func TestChar(t *testing.T) {
+	p := `
+	a = chr(97)
+	b = char("b")
+	also_a = char("a")
+	ab = "" ++ a ++ b
+	[a, ord(b), "é".ord(), a == also_a, a < b, ab, a.is_whitespace?()]
+	`
+	expected := &ListValue{
+		CharValue('a'),
+		IntValue(98),
+		IntValue(233),
+		BoolValue(true),
+		BoolValue(true),
+		StringValue("ab"),
+		BoolValue(false),
+	}
+	expectProgramToReturn(t, p, expected)
+	expectProgramToFail(t, `char("ab")`)
+	expectProgramToFail(t, `chr(-1)`)
+	expectProgramToFail(t, `chr(97).length()`)
+}
+
+func TestMathModule(t *testing.T) {
+	p := `
+	import math
+	[
+		math.abs(0 - 3),
+		math.abs(0.0 - 2.5),
+		math.min(3, 1.5),
+		math.max(1, 5),
+		math.min([4, 2, 8]),
+		math.max([1, 5, 2, 9, 4, 3]),
+		math.max([]),
+		math.round(2.5),
+		math.floor(0.0 - 2.5),
+		math.ceil(2.1),
+		math.pow(2, 10),
+		math.pow(4, 0.5),
+		math.sqrt(16),
+		math.log(math.e),
+		math.pi * 2,
+		math.cos(0),
+		math.div(0 - 7, 2),
+		math.mod(0 - 7, 2),
+		math.mod(7, 0 - 2),
+		math.gcd(12, 18),
+		math.lcm(4, 6)
+	]
+	`
+	expected := &ListValue{
+		IntValue(3),
+		FloatValue(2.5),
+		FloatValue(1.5),
+		IntValue(5),
+		toSome(IntValue(2)),
+		toSome(IntValue(9)),
+		toNone(),
+		IntValue(3),
+		IntValue(-3),
+		IntValue(3),
+		IntValue(1024),
+		FloatValue(2),
+		FloatValue(4),
+		FloatValue(1),
+		FloatValue(2 * math.Pi),
+		FloatValue(1),
+		IntValue(-4),
+		IntValue(1),
+		IntValue(-1),
+		IntValue(6),
+		IntValue(12),
+	}
+	expectProgramToReturn(t, p, expected)
+	expectProgramToFail(t, `import math
+	math.pow(2, 0 - 1)`)
+	expectProgramToFail(t, `import math
+	math.div(1, 0)`)
+	expectProgramToFail(t, `import math
+	math.round(math.sqrt(0 - 1))`)
+	expectProgramToFail(t, `import math
+	math.tau`)
+	expectProgramToFail(t, `x = 1
+	x.pi`)
+}
+
+func TestBigInt(t *testing.T) {
+	p := `
+	max = 9223372036854775807
+	big = max + 1
+	squared = max * max
+	back = squared / max
+	min = 0 - max - 1
+	kind = (x:BigInt) => "big"
+	kind = (x:Int) => "int"
+	double = (x:Num) => x * 2
+	[
+		big,
+		big.string(),
+		squared.string(),
+		back == max,
+		big > max,
+		big == 9223372036854775808.0,
+		big - 1,
+		min - 1,
+		min / (0 - 1),
+		kind(big),
+		kind(max),
+		double(big).string(),
+		"99999999999999999999".int().to_maybe().map(string),
+		{}.insert(big, 1).get(max + 1)
+	]
+	`
+	bigValue, _ := new(big.Int).SetString("9223372036854775808", 10)
+	belowMin, _ := new(big.Int).SetString("-9223372036854775809", 10)
+	expected := &ListValue{
+		NewBigIntValue(bigValue),
+		StringValue("9223372036854775808"),
+		StringValue("85070591730234615847396907784232501249"),
+		BoolValue(true),
+		BoolValue(true),
+		BoolValue(true),
+		IntValue(math.MaxInt64),
+		NewBigIntValue(belowMin),
+		NewBigIntValue(bigValue),
+		StringValue("big"),
+		StringValue("int"),
+		StringValue("18446744073709551616"),
+		toSome(StringValue("99999999999999999999")),
+		toSome(IntValue(1)),
+	}
+	expectProgramToReturn(t, p, expected)
+	expectProgramToFail(t, `
+	big = 9223372036854775807 + 1
+	big / 0
+	`)
+	expectProgramToFail(t, `
+	big = 9223372036854775807 + 1
+	"a".repeat(big)
+	`)
+}
+
+func TestMathModuleBigInt(t *testing.T) {
+	p := `
+	import math
+	max = 9223372036854775807
+	big = max + 1
+	[
+		math.pow(2, 64).string(),
+		math.abs(0 - max - 1).string(),
+		math.max(big, max).string(),
+		math.gcd(big, 6),
+		math.lcm(max, 2).string(),
+		math.round(100000000000000000000.0).string()
+	]
+	`
+	expected := &ListValue{
+		StringValue("18446744073709551616"),
+		StringValue("9223372036854775808"),
+		StringValue("9223372036854775808"),
+		IntValue(2),
+		StringValue("18446744073709551614"),
+		StringValue("100000000000000000000"),
+	}
+	expectProgramToReturn(t, p, expected)
+}
+
+func TestBooleanOperators(t *testing.T) {
+	p := `
+	a = 3
+	fail = () => 1 / 0 > 0
+	is_three = (n) => n == 3
+	[
+		a > 1 && a < 5,
+		a < 1 || a > 5,
+		a < 1 || a > 1 && a < 5,
+		false && fail(),
+		true || fail(),
+		!true,
+		!(a > 1) || !false,
+		a == 2 || is_three(a)
+	]
+	`
+	expected := &ListValue{
+		BoolValue(true),
+		BoolValue(false),
+		BoolValue(true),
+		BoolValue(false),
+		BoolValue(true),
+		BoolValue(false),
+		BoolValue(true),
+		BoolValue(true),
+	}
+	expectProgramToReturn(t, p, expected)
+	expectProgramToFail(t, `1 && true`)
+	expectProgramToFail(t, `false || "a"`)
+	expectProgramToFail(t, `!1`)
+}
+
+func TestUnaryMinus(t *testing.T) {
+	p := `
+	a = 3
+	sign = (n:Int) => match n {
+		0 -> "zero"
+		-1 -> "minus one"
+		_ -> "other"
+	}
+	[
+		-a,
+		-a * 2,
+		-a.string().length(),
+		10 - -1,
+		-2.5,
+		-(1 + 2),
+		--a,
+		sign(0 - 1),
+		-9223372036854775808,
+		-(-9223372036854775808)
+	]
+	`
+	minNeg, _ := new(big.Int).SetString("9223372036854775808", 10)
+	expected := &ListValue{
+		IntValue(-3),
+		IntValue(-6),
+		IntValue(-1),
+		IntValue(11),
+		FloatValue(-2.5),
+		IntValue(-3),
+		IntValue(3),
+		StringValue("minus one"),
+		IntValue(math.MinInt64),
+		NewBigIntValue(minNeg),
+	}
+	expectProgramToReturn(t, p, expected)
+	expectProgramToFail(t, `-"a"`)
+
+	// Outside of match branches a minus starting a line continues the expression
+	expectProgramToReturn(t, `
+	a = 10
+	t = a
+	- 5
+	t
+	`, IntValue(5))
+	expectProgramToReturn(t, `
+	f = (n:Int) => match n {
+		0 -> {
+			t = n
+			- 5
+			t
+		}
+		-1 -> 1
+		_ -> 2
+	}
+	[f(0), f(0 - 1)]
+	`, &ListValue{IntValue(-5), IntValue(1)})
+}
+
+func TestNumberConversion(t *testing.T) {
+	p := `[float("2.5"), float("x").to_maybe(), to_float(3), to_int(0.0 - 2.7), to_int(4)]`
+	expected := &ListValue{
+		toOk(FloatValue(2.5)),
+		toNone(),
+		FloatValue(3),
+		IntValue(-2),
+		IntValue(4),
+	}
+	expectProgramToReturn(t, p, expected)
+}
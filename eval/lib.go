@@ -7,20 +7,20 @@ import (
 	"strings"
 )
 
-func (c *Context) LoadLib(name string) (Value, *runtimeError) {
+func (c *Context) LoadLib(name string) (Value, *RuntimeError) {
 	program, ok := lib.Stdlibs[name]
 	if !ok {
-		return nil, &runtimeError{
+		return nil, &RuntimeError{
 			reason: fmt.Sprintf("%s is not a valid standard library; could not import", name),
 		}
 	}
 
 	v, err := c.Eval(strings.NewReader(program), "")
 	if err != nil {
-		if runtimeErr, ok := err.(*runtimeError); ok {
+		if runtimeErr, ok := err.(*RuntimeError); ok {
 			return nil, runtimeErr
 		} else {
-			return nil, &runtimeError{
+			return nil, &RuntimeError{
 				reason: fmt.Sprintf("Error loading %s: %s", name, err.Error()),
 			}
 		}
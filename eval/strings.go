@@ -0,0 +1,53 @@
+package eval
+
+import (
+	"bytes"
+	"unicode/utf8"
+)
+
+// Where the last lookup of a character in a Str ended up. Walking a Str by
+// index, as fold and take do through get, would otherwise decode it from
+// the start for every character.
+type runeCursor struct {
+	s      StringValue
+	ascii  bool
+	index  int // in runes
+	offset int // in bytes
+}
+
+// The i-th character of s, counted in runes and not bytes
+func (r *runeCursor) runeAt(s StringValue, i int) (StringValue, bool) {
+	if i < 0 {
+		return nil, false
+	}
+	if !bytes.Equal(r.s, s) {
+		*r = runeCursor{s: s, ascii: isASCII(s)}
+	}
+	if r.ascii {
+		if i >= len(s) {
+			return nil, false
+		}
+		return s[i : i+1], true
+	}
+	if i < r.index {
+		r.index, r.offset = 0, 0
+	}
+	for r.offset < len(s) {
+		_, size := utf8.DecodeRune(s[r.offset:])
+		if r.index == i {
+			return s[r.offset : r.offset+size], true
+		}
+		r.index++
+		r.offset += size
+	}
+	return nil, false
+}
+
+func isASCII(s []byte) bool {
+	for _, b := range s {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
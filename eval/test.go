@@ -0,0 +1,111 @@
+package eval
+
+import (
+	"dghaehre/raja/ast"
+	"strings"
+)
+
+// A top-level function named test_*, which raja test runs
+type Test struct {
+	Name string
+	ast.Pos
+}
+
+// A test that failed, either by a failed assertion or by a runtime error
+type TestFailure struct {
+	Test
+	err error
+
+	// Where in the test file it failed, which is the test itself when
+	// the failure happened outside the file
+	Failed ast.Pos
+}
+
+func (f *TestFailure) Error() string {
+	return f.err.Error()
+}
+
+// The tests in a *_test.raja file: the top-level functions named test_* without parameters
+func FindTests(source string, filename string) ([]Test, error) {
+	tokenizer := ast.NewTokenizer(source, filename)
+	parser := ast.NewParser(tokenizer.Tokenize())
+	nodes, err := parser.Parse()
+	if err != nil {
+		return nil, err
+	}
+	tests := []Test{}
+	for _, node := range nodes {
+		assignment, ok := node.(ast.AssignmentNode)
+		if !ok {
+			continue
+		}
+		ident, ok := assignment.Left.(ast.IdentifierNode)
+		if !ok || !strings.HasPrefix(ident.Payload, "test_") {
+			continue
+		}
+		if fn, ok := assignment.Right.(ast.FnNode); ok && len(fn.Args) == 0 {
+			tests = append(tests, Test{Name: ident.Payload, Pos: ident.Pos()})
+		}
+	}
+	return tests, nil
+}
+
+// A *_test.raja file, evaluated once. Each test is run from a copy of what the
+// file defined, so that top-level side effects happen once, and tests cannot
+// affect each other through globals.
+type TestFile struct {
+	c   *Context
+	err error // from evaluating the file, which fails every test
+}
+
+func LoadTestFile(source string, filename string, opts ...Option) *TestFile {
+	c := NewContext(opts...)
+	c.LoadBuiltins()
+	_, err := c.Eval(strings.NewReader(source), filename)
+	// The builtins are bound to c, so the tests run in it as well
+	return &TestFile{c: &c, err: err}
+}
+
+// Calls the test function from a copy of the globals of the file.
+// Returns a *TestFailure when the test fails.
+func (f *TestFile) Run(test Test) error {
+	if f.err != nil {
+		return testFailure(test, f.err)
+	}
+	globals := f.c.scope
+	f.c.scope = globals.isolated()
+	f.c.stack = nil
+	defer func() { f.c.scope = globals }()
+	tok := ast.Token{Kind: ast.Identifier, Payload: test.Name, Pos: test.Pos}
+	call := ast.FnCallNode{
+		Fn:  ast.IdentifierNode{Payload: test.Name, Tok: &tok},
+		Tok: &tok,
+	}
+	if _, err := f.c.evalExpr(call, f.c.scope); err != nil {
+		return testFailure(test, err)
+	}
+	return nil
+}
+
+func testFailure(test Test, err error) *TestFailure {
+	failure := &TestFailure{Test: test, err: err, Failed: test.Pos}
+	runtimeErr, ok := err.(*RuntimeError)
+	if !ok {
+		if withPos, ok := err.(interface{ Position() ast.Pos }); ok {
+			failure.Failed = withPos.Position()
+		}
+		return failure
+	}
+	if runtimeErr.Pos.FileName() == test.FileName() {
+		failure.Failed = runtimeErr.Pos
+		return failure
+	}
+	// The innermost call in the test file
+	for _, entry := range runtimeErr.stackTrace {
+		if entry.FileName() == test.FileName() {
+			failure.Failed = entry.Pos
+			break
+		}
+	}
+	return failure
+}
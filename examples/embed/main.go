@@ -0,0 +1,95 @@
+// An example of a Go program embedding raja: it gives a script Go functions and
+// values, calls the functions the script defines, and converts the results back to Go.
+//
+// Run it from the root of the repository with: go run ./examples/embed
+package main
+
+import (
+	_ "embed"
+	"fmt"
+	"os"
+	"strings"
+
+	"dghaehre/raja/eval"
+)
+
+//go:embed script.raja
+var script string
+
+type Config struct {
+	Greeting string `raja:"greeting"`
+	Verbose  bool   `raja:"-"`
+}
+
+type Stats struct {
+	Count int `raja:"count"`
+	Sum   int `raja:"sum"`
+}
+
+func wordCount(_ string, args []eval.Value) (eval.Value, *eval.RuntimeError) {
+	var s string
+	if err := eval.FromValue(args[0], &s); err != nil {
+		return nil, eval.NewRuntimeError("word_count: %s", err)
+	}
+	return eval.IntValue(len(strings.Fields(s))), nil
+}
+
+func main() {
+	c := eval.NewContext()
+	c.LoadBuiltins()
+	c.LoadFunc("__word_count", wordCount)
+	c.LoadFunc("__shout", func(_ string, args []eval.Value) (eval.Value, *eval.RuntimeError) {
+		return eval.StringValue(strings.ToUpper(args[0].String()) + "!"), nil
+	})
+	if err := c.Set("config", Config{Greeting: "Hei"}); err != nil {
+		fail(err)
+	}
+	if _, err := c.Eval(strings.NewReader(script), "script.raja"); err != nil {
+		fail(err)
+	}
+
+	// Multiple dispatch picks the describe for each argument
+	for _, arg := range []any{42, "raja is fun", []any{1, "two"}} {
+		v, err := c.Call("describe", arg)
+		if err != nil {
+			fail(err)
+		}
+		fmt.Println(v)
+	}
+
+	greeting, err := c.Call("greet", "Go")
+	if err != nil {
+		fail(err)
+	}
+	fmt.Println(greeting)
+
+	shouted, err := c.Call("shout_all", []string{"hello", "world"})
+	if err != nil {
+		fail(err)
+	}
+	var words []string
+	if err := eval.FromValue(shouted, &words); err != nil {
+		fail(err)
+	}
+	fmt.Println(strings.Join(words, " "))
+
+	v, err := c.Call("stats", []int{1, 2, 3, 4})
+	if err != nil {
+		fail(err)
+	}
+	var stats Stats
+	if err := eval.FromValue(v, &stats); err != nil {
+		fail(err)
+	}
+	fmt.Printf("%+v\n", stats)
+
+	// Errors in the script are returned to the host, which keeps running
+	if _, err := c.Call("word_count", 1); err != nil {
+		fmt.Println("word_count(1) failed, as there is no word_count for Int")
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
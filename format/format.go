@@ -0,0 +1,508 @@
+// Package format re-prints raja source from its AST, in the one style used by raja fmt.
+//
+// The style:
+//   - tabs for indentation, and one blank line at most between expressions
+//   - the -> of every branch in a match lined up
+//   - long . pipelines broken into one call per line
+//   - one alias target per line, when there is more than one and they were not
+//     written on one line
+//
+// Comments are not part of the AST, but the parser attaches each to a node, see ast.CommentMap.
+// A comment is printed with its node, so a list, map or call with comments in it
+// gets one element per line.
+package format
+
+import (
+	"strings"
+
+	"dghaehre/raja/ast"
+)
+
+const (
+	// Lines longer than this are broken up, where possible
+	maxWidth = 80
+	tabWidth = 4
+
+	// A pipeline with more calls than this is always broken up
+	maxPipelineCalls = 4
+)
+
+type formatter struct {
+	comments ast.CommentMap
+	// Set while formatting only to see how much space something takes, which leaves the comments
+	dryRun bool
+
+	// Lines with a token or comment on them, used to keep blank lines
+	lines map[int]bool
+}
+
+// Formats the given source. Source that does not parse is returned with the parse error.
+func Format(source string, filename string) (string, error) {
+	shebang := ""
+	if strings.HasPrefix(source, "#!") {
+		shebang, _, _ = strings.Cut(source, "\n")
+		shebang += "\n"
+	}
+
+	tokenizer := ast.NewTokenizer(source, filename)
+	tokens := tokenizer.Tokenize()
+	parser := ast.NewParser(tokens)
+	nodes, err := parser.Parse()
+	if err != nil {
+		return "", err
+	}
+
+	f := formatter{comments: parser.Comments(), lines: map[int]bool{}}
+	for _, tok := range tokens {
+		f.lines[tok.Line()] = true
+	}
+
+	out := shebang + strings.TrimLeft(f.items(0, f.nodeItems(nodes), "", f.comments.End, true), "\n")
+	if out == "" {
+		return "", nil
+	}
+	return out + "\n", nil
+}
+
+func indentation(indent int) string {
+	return strings.Repeat("\t", indent)
+}
+
+func comment(tok ast.Token) string {
+	if tok.Payload == "" {
+		return "#"
+	}
+	return "# " + tok.Payload
+}
+
+// Was there a blank line right before line in the source
+func (f *formatter) blankBefore(line int) bool {
+	return line > 1 && !f.lines[line-1]
+}
+
+// Takes the comments attached to the node starting at key, so that they are printed once
+func (f *formatter) take(key ast.Pos) ast.Comments {
+	comments := f.comments.Nodes[key]
+	if !f.dryRun {
+		delete(f.comments.Nodes, key)
+	}
+	return comments
+}
+
+// Takes the comments before the end of the node at pos
+func (f *formatter) takeClosing(pos ast.Pos) []ast.Token {
+	comments := f.comments.Closing[pos]
+	if !f.dryRun {
+		delete(f.comments.Closing, pos)
+	}
+	return comments
+}
+
+// Something printed on its own line when its parent is broken up, like the element of a list
+type item struct {
+	key    ast.Pos // where its comments are attached
+	format func(indent int) string
+}
+
+func (f *formatter) nodeItems(nodes []ast.AstNode) []item {
+	items := make([]item, len(nodes))
+	for i, node := range nodes {
+		node := node
+		items[i] = item{key: ast.Start(node), format: func(indent int) string { return f.expr(node, indent) }}
+	}
+	return items
+}
+
+// Whether any of the items has comments
+func (f *formatter) commented(items []item) bool {
+	for _, it := range items {
+		c := f.comments.Nodes[it.key]
+		if len(c.Previous)+len(c.Leading)+len(c.Trailing) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Each item on a line of its own at indent, with its comments, and then the closing comments.
+// sep goes after every item but the last, and blanks keeps blank lines from the source.
+func (f *formatter) items(indent int, items []item, sep string, closing []ast.Token, blanks bool) string {
+	var b strings.Builder
+	started := false
+	newline := func(line int) {
+		b.WriteString("\n")
+		if blanks && started && f.blankBefore(line) {
+			b.WriteString("\n")
+		}
+		b.WriteString(indentation(indent))
+		started = true
+	}
+	for i, it := range items {
+		comments := f.take(it.key)
+		for _, c := range comments.Previous {
+			b.WriteString(" " + comment(c))
+		}
+		for _, c := range comments.Leading {
+			newline(c.Line())
+			b.WriteString(comment(c))
+		}
+		newline(it.key.Line())
+		b.WriteString(it.format(indent))
+		if i < len(items)-1 {
+			b.WriteString(sep)
+		}
+		for _, c := range comments.Trailing {
+			b.WriteString(" " + comment(c))
+		}
+	}
+	for _, c := range closing {
+		newline(c.Line())
+		b.WriteString(comment(c))
+	}
+	return b.String()
+}
+
+// The items between open and close, like the elements of a list: on one line,
+// unless there are comments among them, which need one item per line
+func (f *formatter) enclosed(open string, items []item, pos ast.Pos, close string, indent int) string {
+	if !f.commented(items) && len(f.comments.Closing[pos]) == 0 {
+		formatted := make([]string, len(items))
+		for i, it := range items {
+			formatted[i] = it.format(indent)
+		}
+		return open + strings.Join(formatted, ", ") + close
+	}
+	return open + f.items(indent+1, items, ",", f.takeClosing(pos), true) + "\n" + indentation(indent) + close
+}
+
+// Formats without taking any comments, to see how much space something takes
+func (f *formatter) dry(format func() string) string {
+	dryRun := f.dryRun
+	f.dryRun = true
+	s := format()
+	f.dryRun = dryRun
+	return s
+}
+
+// The width of the first line of s
+func width(indent int, s string) int {
+	first, _, _ := strings.Cut(s, "\n")
+	return indent*tabWidth + len(first)
+}
+
+func (f *formatter) exprs(nodes []ast.AstNode, indent int) string {
+	formatted := make([]string, len(nodes))
+	for i, node := range nodes {
+		formatted[i] = f.expr(node, indent)
+	}
+	return strings.Join(formatted, ", ")
+}
+
+func (f *formatter) expr(node ast.AstNode, indent int) string {
+	switch n := node.(type) {
+	case ast.IntNode:
+		return n.Tok.Payload
+	case ast.FloatNode:
+		return n.Tok.Payload
+	case ast.StringNode:
+		// The source of the string, so that escapes stay as they were written
+		return `"` + n.Tok.Payload + `"`
+	case ast.BoolNode, ast.IdentifierNode, ast.UnderscoreNode, ast.RestNode, ast.ImportNode:
+		return node.String()
+	case ast.AssignmentNode:
+		return f.expr(n.Left, indent) + " = " + f.expr(n.Right, indent)
+	case ast.BinaryNode:
+		op := ast.Token{Kind: n.Op}
+		left := f.expr(n.Left, indent)
+		if needsParensLeft(n.Left, n.Op) {
+			left = "(" + left + ")"
+		}
+		right := f.expr(n.Right, indent)
+		if needsParensRight(n.Right, n.Op) {
+			right = "(" + right + ")"
+		}
+		return left + " " + op.String() + " " + right
+	case ast.UnaryNode:
+		op := ast.Token{Kind: n.Op}
+		operand := f.expr(n.Operand, indent)
+		if !isUnit(n.Operand) && !isCall(n.Operand) {
+			operand = "(" + operand + ")"
+		}
+		return op.String() + operand
+	case ast.FnCallNode:
+		if n.Dot {
+			return f.pipeline(n, indent)
+		}
+		return f.callee(n.Fn, indent) + f.enclosed("(", f.nodeItems(n.Args), n.Pos(), ")", indent)
+	case ast.MemberNode:
+		module := f.expr(n.Module, indent)
+		if !isUnit(n.Module) && !isCall(n.Module) {
+			module = "(" + module + ")"
+		}
+		return module + "." + n.Name
+	case ast.FnNode:
+		args := make([]string, len(n.Args))
+		for i, a := range n.Args {
+			args[i] = a.String()
+		}
+		signature := "(" + strings.Join(args, ", ") + ")"
+		if n.Return != nil {
+			signature += " -> " + n.Return.String()
+		}
+		return signature + " => " + f.expr(n.Body, indent)
+	case ast.BlockNode:
+		exprs := f.items(indent+1, f.nodeItems(n.Exprs), "", f.takeClosing(n.Pos()), true)
+		return "{" + exprs + "\n" + indentation(indent) + "}"
+	case ast.ListNode:
+		return f.enclosed("[", f.nodeItems(n.Elems), n.Pos(), "]", indent)
+	case ast.MapNode:
+		entries := make([]item, len(n.Keys))
+		for i := range n.Keys {
+			key, value := n.Keys[i], n.Values[i]
+			entries[i] = item{key: ast.Start(key), format: func(indent int) string {
+				return f.expr(key, indent) + ": " + f.expr(value, indent)
+			}}
+		}
+		return f.enclosed("{", entries, n.Pos(), "}", indent)
+	case ast.EnumNode:
+		s := n.Parent + "::" + n.Name
+		if len(n.Args) > 0 {
+			s += "(" + f.exprs(n.Args, indent) + ")"
+		}
+		return s
+	case ast.MatchNode:
+		return f.match(n, indent)
+	case ast.AliasNode:
+		return f.alias(n, indent)
+	}
+	return node.String()
+}
+
+// What parseUnit parses, without parens. Numbers are left out, as 1.f() would be read as a float
+func isUnit(node ast.AstNode) bool {
+	switch node.(type) {
+	case ast.IdentifierNode, ast.StringNode, ast.BoolNode, ast.UnderscoreNode, ast.ListNode, ast.MapNode,
+		ast.BlockNode, ast.EnumNode, ast.MatchNode, ast.RestNode, ast.ImportNode:
+		return true
+	}
+	return false
+}
+
+func isNumber(node ast.AstNode) bool {
+	switch node.(type) {
+	case ast.IntNode, ast.FloatNode:
+		return true
+	}
+	return false
+}
+
+// A call, or a binding of a module like math.pi, which binds as tightly
+func isCall(node ast.AstNode) bool {
+	switch node.(type) {
+	case ast.FnCallNode, ast.MemberNode:
+		return true
+	}
+	return false
+}
+
+// Binary operators on the same level of precedence are read from left to right
+func needsParensLeft(node ast.AstNode, op ast.TokKind) bool {
+	switch n := node.(type) {
+	case ast.FnNode, ast.AssignmentNode:
+		return true
+	case ast.BinaryNode:
+		return ast.Precedence(n.Op) < ast.Precedence(op)
+	}
+	return false
+}
+
+// The right side of a binary operator is a unit with its calls, or operators that bind tighter: a || b.f() && c
+func needsParensRight(node ast.AstNode, op ast.TokKind) bool {
+	switch n := node.(type) {
+	case ast.FnCallNode, ast.MemberNode, ast.UnaryNode:
+		return false
+	case ast.BinaryNode:
+		return ast.Precedence(n.Op) <= ast.Precedence(op)
+	}
+	return !isUnit(node) && !isNumber(node)
+}
+
+// What is called: f in f(a), which has to be a unit or another call
+func (f *formatter) callee(node ast.AstNode, indent int) string {
+	s := f.expr(node, indent)
+	switch n := node.(type) {
+	case ast.FnCallNode:
+		if n.Dot {
+			return "(" + s + ")"
+		}
+		return s
+	case ast.FnNode, ast.AssignmentNode, ast.BinaryNode, ast.UnaryNode, ast.MemberNode:
+		return "(" + s + ")"
+	}
+	return s
+}
+
+// a.f(b).g(), which is broken up with one call per line when it is too long
+func (f *formatter) pipeline(n ast.FnCallNode, indent int) string {
+	calls := []ast.FnCallNode{n}
+	receiver := n.Args[0]
+	for {
+		call, ok := receiver.(ast.FnCallNode)
+		if !ok || !call.Dot {
+			break
+		}
+		calls = append([]ast.FnCallNode{call}, calls...)
+		receiver = call.Args[0]
+	}
+	head := func() string {
+		s := f.expr(receiver, indent)
+		if !isUnit(receiver) && !isCall(receiver) {
+			return "(" + s + ")"
+		}
+		return s
+	}
+	call := func(call ast.FnCallNode, indent int) string {
+		return "." + f.callee(call.Fn, indent) + f.enclosed("(", f.nodeItems(call.Args[1:]), call.Pos(), ")", indent)
+	}
+	items := make([]item, len(calls))
+	for i, c := range calls {
+		c := c
+		items[i] = item{key: ast.Start(c.Fn), format: func(indent int) string { return call(c, indent) }}
+	}
+
+	oneLine := f.dry(func() string {
+		s := head()
+		for _, c := range calls {
+			s += call(c, indent)
+		}
+		return s
+	})
+	fits := len(calls) <= maxPipelineCalls && width(indent, oneLine) <= maxWidth
+	if !f.commented(items) && (len(calls) == 1 || fits) {
+		s := head()
+		for _, c := range calls {
+			s += call(c, indent)
+		}
+		return s
+	}
+
+	return head() + f.items(indent+1, items, "", nil, false)
+}
+
+// Every target of a branch, and its guard: a, b if a > 0
+type branchGroup struct {
+	targets []ast.AstNode
+	guard   ast.AstNode
+	body    ast.AstNode
+}
+
+// The parser gives a branch with several targets as one branch per target, with the same body
+func groupBranches(branches []ast.MatchBranch) []branchGroup {
+	groups := []branchGroup{}
+	for _, br := range branches {
+		if len(groups) > 0 {
+			last := &groups[len(groups)-1]
+			if last.body.Pos() == br.Body.Pos() {
+				last.targets = append(last.targets, br.Target)
+				continue
+			}
+		}
+		groups = append(groups, branchGroup{
+			targets: []ast.AstNode{br.Target},
+			guard:   br.Guard,
+			body:    br.Body,
+		})
+	}
+	return groups
+}
+
+func (f *formatter) branchHead(g branchGroup, indent int) string {
+	head := f.exprs(g.targets, indent)
+	if g.guard != nil {
+		head += " if " + f.expr(g.guard, indent)
+	}
+	return head
+}
+
+func (f *formatter) match(n ast.MatchNode, indent int) string {
+	var b strings.Builder
+	b.WriteString("match ")
+	// match { ... } has no condition in the source
+	if cond, ok := n.Cond.(ast.BoolNode); !ok || cond.Tok.Kind != ast.MatchKeyword {
+		b.WriteString(f.expr(n.Cond, indent) + " ")
+	}
+	b.WriteString("{")
+
+	groups := groupBranches(n.Branches)
+	align := 0
+	for _, g := range groups {
+		head := f.dry(func() string { return f.branchHead(g, indent+1) })
+		if !strings.Contains(head, "\n") && len(head) > align {
+			align = len(head)
+		}
+	}
+
+	items := make([]item, len(groups))
+	for i, g := range groups {
+		g := g
+		items[i] = item{key: ast.Start(g.targets[0]), format: func(indent int) string {
+			head := f.branchHead(g, indent)
+			if !strings.Contains(head, "\n") {
+				head += strings.Repeat(" ", align-len(head))
+			}
+			return head + " -> " + f.expr(g.body, indent)
+		}}
+	}
+	b.WriteString(f.items(indent+1, items, "", f.takeClosing(n.Pos()), false))
+	b.WriteString("\n" + indentation(indent) + "}")
+	return b.String()
+}
+
+// An alias with more than one target gets one target per line, with the | in front:
+//
+//	alias Maybe(a) =
+//			Maybe::Some(a)
+//		| Maybe::None
+//
+// unless it was written on one line, like alias Num = Int | Float, and still fits on one.
+func (f *formatter) alias(n ast.AliasNode, indent int) string {
+	head := "alias " + n.Name
+	if len(n.Params) > 0 {
+		head += "(" + strings.Join(n.Params, ", ") + ")"
+	}
+	target := func(node ast.AstNode) string {
+		s := f.expr(node, indent+1)
+		if !isUnit(node) && !isCall(node) && !isNumber(node) {
+			return "(" + s + ")"
+		}
+		return s
+	}
+	items := make([]item, len(n.Targets))
+	for i, t := range n.Targets {
+		prefix := "| "
+		if i == 0 {
+			prefix = "\t"
+		}
+		t := t
+		items[i] = item{key: ast.Start(t), format: func(indent int) string { return prefix + target(t) }}
+	}
+	if f.commented(items) {
+		return head + " =" + f.items(indent+1, items, "", nil, false)
+	}
+	if len(n.Targets) == 1 {
+		return head + " = " + target(n.Targets[0])
+	}
+	if ast.Start(n.Targets[len(n.Targets)-1]).Line() == n.Pos().Line() {
+		oneLine := f.dry(func() string {
+			targets := make([]string, len(n.Targets))
+			for i, t := range n.Targets {
+				targets[i] = target(t)
+			}
+			return head + " = " + strings.Join(targets, " | ")
+		})
+		if !strings.Contains(oneLine, "\n") && width(indent, oneLine) <= maxWidth {
+			return oneLine
+		}
+	}
+	return head + " =" + f.items(indent+1, items, "", nil, false)
+}
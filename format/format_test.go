@@ -0,0 +1,235 @@
+package format
+
+import (
+	"dghaehre/raja/ast"
+	"dghaehre/raja/lib"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The AST of the source, as a string
+func parse(source string, filename string) (string, error) {
+	tokenizer := ast.NewTokenizer(source, filename)
+	parser := ast.NewParser(tokenizer.Tokenize())
+	nodes, err := parser.Parse()
+	if err != nil {
+		return "", err
+	}
+	nodeStrings := make([]string, len(nodes))
+	for i, node := range nodes {
+		nodeStrings[i] = node.String()
+	}
+	return strings.Join(nodeStrings, "\n"), nil
+}
+
+func countComments(source string) int {
+	count := 0
+	tokenizer := ast.NewTokenizer(source, "")
+	for _, tok := range tokenizer.Tokenize() {
+		if tok.Kind == ast.Comment {
+			count++
+		}
+	}
+	return count
+}
+
+// Formatting twice gives the same source, and formatting does not change the program
+func testIdempotent(t *testing.T, source string, filename string) {
+	original, err := parse(source, filename)
+	if err != nil {
+		t.Skipf("%s does not parse: %s", filename, err)
+	}
+	once, err := Format(source, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted, err := parse(once, filename)
+	if err != nil {
+		t.Fatalf("Formatted %s does not parse: %s\n%s", filename, err, once)
+	}
+	if formatted != original {
+		t.Errorf("Formatting changed the program %s\nexpected:\n%s\ngot:\n%s", filename, original, formatted)
+	}
+	if countComments(once) != countComments(source) {
+		t.Errorf("Formatting %s lost comments:\n%s", filename, once)
+	}
+	twice, err := Format(once, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if twice != once {
+		t.Errorf("Formatting %s is not idempotent\nonce:\n%s\ntwice:\n%s", filename, once, twice)
+	}
+}
+
+func TestIdempotentExamples(t *testing.T) {
+	examples, err := filepath.Glob("../examples/*.raja")
+	if err != nil {
+		t.Fatal(err)
+	}
+	aoc, err := filepath.Glob("../examples/*/*.raja")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, example := range append(examples, aoc...) {
+		t.Run(filepath.Base(example), func(t *testing.T) {
+			source, err := os.ReadFile(example)
+			if err != nil {
+				t.Fatal(err)
+			}
+			testIdempotent(t, string(source), example)
+		})
+	}
+}
+
+func TestIdempotentStdlibs(t *testing.T) {
+	for name, source := range lib.Stdlibs {
+		t.Run(name, func(t *testing.T) {
+			testIdempotent(t, source, name+".raja")
+		})
+	}
+}
+
+func TestFormat(t *testing.T) {
+	source := `#!/usr/bin/env raja
+  # A comment
+x = 1   # trailing
+f = (a:Int,b) => {
+      y = a+b
+
+
+  y * 2
+}
+alias Shape = Shape::Circle(Num) | Shape::Square(Num)
+alias Maybe(a) = Maybe::Some(a)
+  | Maybe::None
+r = match x {
+  1, 2 -> "small"
+  n if n > 100 -> "large"
+ _ -> "other"
+}
+`
+	expected := `#!/usr/bin/env raja
+# A comment
+x = 1 # trailing
+f = (a:Int, b) => {
+	y = a + b
+
+	y * 2
+}
+alias Shape = Shape::Circle(Num) | Shape::Square(Num)
+alias Maybe(a) =
+		Maybe::Some(a)
+	| Maybe::None
+r = match x {
+	1, 2         -> "small"
+	n if n > 100 -> "large"
+	_            -> "other"
+}
+`
+	formatted, err := Format(source, "test.raja")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, formatted)
+	}
+}
+
+func TestFormatPipeline(t *testing.T) {
+	source := `total = read_file("input.txt").unwrap().split("\n").map((l) => l.int()).filter((n) => n > 0).sum()
+short = x.f().g()
+`
+	expected := `total = read_file("input.txt")
+	.unwrap()
+	.split("\n")
+	.map((l) => l.int())
+	.filter((n) => n > 0)
+	.sum()
+short = x.f().g()
+`
+	formatted, err := Format(source, "test.raja")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, formatted)
+	}
+}
+
+func TestFormatOperators(t *testing.T) {
+	source := `a = (x||y)&&!z
+b = x&&(y||z)
+c = -n.abs()*(1+2)
+d = (-n).abs()-(-1)
+e = x>1&&y<=-2.5||!f(x)
+f = -math.pi*2+(math.pi)
+`
+	expected := `a = (x || y) && !z
+b = x && (y || z)
+c = -n.abs() * (1 + 2)
+d = (-n).abs() - -1
+e = x > 1 && y <= -2.5 || !f(x)
+f = -math.pi * 2 + math.pi
+`
+	formatted, err := Format(source, "test.raja")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, formatted)
+	}
+	testIdempotent(t, source, "test.raja")
+}
+
+func TestFormatComments(t *testing.T) {
+	source := `xs = [
+	# first
+  1,   # one
+	2
+
+	,3 # three
+	# last
+]
+m = {"a": 1, # a
+  "b": 2}
+y = xs # the list
+	.map((x) => x + 1)
+.sum() # total
+z = f(1).g() # one line
+`
+	expected := `xs = [
+	# first
+	1, # one
+	2,
+
+	3 # three
+	# last
+]
+m = {
+	"a": 1, # a
+	"b": 2
+}
+y = xs # the list
+	.map((x) => x + 1)
+	.sum() # total
+z = f(1).g() # one line
+`
+	formatted, err := Format(source, "test.raja")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, formatted)
+	}
+	testIdempotent(t, source, "test.raja")
+}
+
+func TestFormatParseError(t *testing.T) {
+	_, err := Format("x = (1", "test.raja")
+	if err == nil {
+		t.Fatal("expected a parse error")
+	}
+}
@@ -2,11 +2,70 @@ package lib
 
 import (
 	_ "embed"
+	"fmt"
+	"os"
+	"path/filepath"
 )
 
 //go:embed base.raja
 var libBase string
 
+//go:embed fs.raja
+var libFs string
+
+//go:embed math.raja
+var libMath string
+
+//go:embed strings.raja
+var libStrings string
+
 var Stdlibs = map[string]string{
-	"base": libBase,
+	"base":    libBase,
+	"fs":      libFs,
+	"math":    libMath,
+	"strings": libStrings,
+}
+
+// A module resolved from an import.
+//
+// Key uniquely identifies the module, and is used to cache modules and detect cyclic imports.
+// FileName is used for positions in the module, and to resolve imports from within the module.
+type Module struct {
+	Key      string
+	FileName string
+	Source   string
+}
+
+// Resolves an import from the file importingFile.
+//
+// A name (import name) is first looked up in the standard libraries, and otherwise
+// resolved as name.raja relative to the importing file.
+// A path (import "path/to/file.raja") is always resolved relative to the importing file.
+func ResolveModule(name string, path string, importingFile string) (Module, error) {
+	if name != "" {
+		if source, ok := Stdlibs[name]; ok {
+			return Module{
+				Key:      "std:" + name,
+				FileName: name,
+				Source:   source,
+			}, nil
+		}
+		path = name + ".raja"
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(importingFile), path)
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return Module{}, err
+	}
+	source, err := os.ReadFile(abs)
+	if err != nil {
+		return Module{}, fmt.Errorf("Could not import %s: %s", path, err)
+	}
+	return Module{
+		Key:      abs,
+		FileName: path,
+		Source:   string(source),
+	}, nil
 }
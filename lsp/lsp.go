@@ -0,0 +1,405 @@
+// Package lsp is a language server for raja, speaking the language server protocol over stdio.
+//
+// Every open document is typechecked on each change, and the result is used for diagnostics,
+// hover, go-to-definition, completion and document symbols.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"dghaehre/raja/ast"
+	"dghaehre/raja/typecheck"
+)
+
+type document struct {
+	uri  string
+	path string
+	text string
+	src  source
+
+	// The typecheck of the latest text
+	ctx   typecheck.TypecheckContext
+	nodes []ast.AstNode // top-level nodes, nil if the text does not parse
+}
+
+type server struct {
+	out       io.Writer
+	documents map[string]*document // by uri
+}
+
+// Serves requests from in until the client sends exit, or in is closed
+func Serve(in io.Reader, out io.Writer) error {
+	s := server{
+		out:       out,
+		documents: map[string]*document{},
+	}
+	r := bufio.NewReader(in)
+	for {
+		body, err := readMessage(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg message
+		if err := json.Unmarshal(body, &msg); err != nil {
+			if err := s.replyError(nil, codeParseError, err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if msg.Method == "exit" {
+			return nil
+		}
+		if err := s.handle(msg); err != nil {
+			return err
+		}
+	}
+}
+
+func (s *server) handle(msg message) error {
+	isRequest := len(msg.ID) > 0
+	var result any
+	var err error
+	switch msg.Method {
+	case "initialize":
+		result = map[string]any{
+			"capabilities": map[string]any{
+				"positionEncoding":       "utf-16",
+				"textDocumentSync":       1, // full
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"completionProvider":     map[string]any{},
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "raja"},
+		}
+	case "shutdown":
+		result = nil
+	case "textDocument/didOpen":
+		var params didOpenParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			return s.update(params.TextDocument.URI, params.TextDocument.Text)
+		}
+	case "textDocument/didChange":
+		var params didChangeParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil && len(params.ContentChanges) > 0 {
+			text := params.ContentChanges[len(params.ContentChanges)-1].Text
+			return s.update(params.TextDocument.URI, text)
+		}
+	case "textDocument/didClose":
+		var params didCloseParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			delete(s.documents, params.TextDocument.URI)
+			return s.publishDiagnostics(params.TextDocument.URI, []diagnostic{})
+		}
+	case "textDocument/hover":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.hover(params)
+		}
+	case "textDocument/definition":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.definition(params)
+		}
+	case "textDocument/completion":
+		var params textDocumentPositionParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.completion(params)
+		}
+	case "textDocument/documentSymbol":
+		var params documentSymbolParams
+		if err = json.Unmarshal(msg.Params, &params); err == nil {
+			result = s.documentSymbols(params)
+		}
+	default:
+		if isRequest {
+			return s.replyError(msg.ID, codeMethodNotFound, fmt.Sprintf("%s is not supported", msg.Method))
+		}
+		// Unknown notifications, like initialized, are ignored
+		return nil
+	}
+	if !isRequest {
+		return nil
+	}
+	if err != nil {
+		return s.replyError(msg.ID, codeInvalidParams, err.Error())
+	}
+	return writeMessage(s.out, response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+}
+
+func (s *server) replyError(id json.RawMessage, code int, reason string) error {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return writeMessage(s.out, errorResponse{
+		JSONRPC: "2.0",
+		ID:      id,
+		Error:   responseError{Code: code, Message: reason},
+	})
+}
+
+func (s *server) publishDiagnostics(uri string, diagnostics []diagnostic) error {
+	return writeMessage(s.out, notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  publishDiagnosticsParams{URI: uri, Diagnostics: diagnostics},
+	})
+}
+
+// Typechecks the new text of the document, and publishes what was found
+func (s *server) update(uri string, text string) error {
+	doc := &document{
+		uri:  uri,
+		path: uriToPath(uri),
+		text: text,
+		src:  newSource(text),
+		ctx:  typecheck.NewTypecheckContext(),
+	}
+	doc.ctx.LoadBuiltins()
+	doc.ctx.LoadLibs()
+	_, err := doc.ctx.Typecheck(strings.NewReader(text), doc.path)
+	found := typecheck.Diagnostics(err)
+	if err == nil {
+		found = typecheck.Diagnostics(doc.ctx.Warnings())
+	}
+	tokenizer := ast.NewTokenizer(text, doc.path)
+	parser := ast.NewParser(tokenizer.Tokenize())
+	doc.nodes, _ = parser.Parse()
+	s.documents[uri] = doc
+
+	diagnostics := []diagnostic{}
+	for _, d := range found {
+		pos := doc.src.toPosition(d.Pos)
+		message := d.Message
+		if d.FileName() != doc.path {
+			// Found in an imported module: shown at the top of the document
+			message = fmt.Sprintf("%s: %s", d.Pos, message)
+			pos = position{}
+		}
+		severity := severityError
+		if d.Warning {
+			severity = severityWarning
+		}
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    lspRange{Start: pos, End: pos},
+			Severity: severity,
+			Source:   "raja",
+			Message:  message,
+		})
+	}
+	return s.publishDiagnostics(uri, diagnostics)
+}
+
+// The lines of a file. Columns of raja are counted in runes, while the protocol counts
+// characters in UTF-16 code units, so the line is needed to convert between them.
+type source []string
+
+func newSource(text string) source {
+	return strings.Split(text, "\n")
+}
+
+// The source of the file at path, from the open documents or from disk.
+// Nil if it cannot be read, which counts every rune as one code unit.
+func (s *server) source(path string) source {
+	for _, doc := range s.documents {
+		if doc.path == path {
+			return doc.src
+		}
+	}
+	text, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return newSource(string(text))
+}
+
+func (src source) toPosition(pos ast.Pos) position {
+	p := position{Line: pos.Line() - 1, Character: pos.Col() - 1}
+	if p.Line < 0 || p.Character < 0 {
+		return position{}
+	}
+	if p.Line < len(src) {
+		runes := []rune(src[p.Line])
+		if p.Character <= len(runes) {
+			p.Character = utf16Len(string(runes[:p.Character]))
+		}
+	}
+	return p
+}
+
+// The range of name, starting at pos
+func (src source) nameRange(pos ast.Pos, name string) lspRange {
+	start := src.toPosition(pos)
+	end := start
+	end.Character += utf16Len(name)
+	return lspRange{Start: start, End: end}
+}
+
+// The length of s in UTF-16 code units
+func utf16Len(s string) int {
+	return len(utf16.Encode([]rune(s)))
+}
+
+// The identifier under the cursor, if any
+func (doc *document) referenceAt(p position) (typecheck.Reference, bool) {
+	var found typecheck.Reference
+	ok := false
+	for _, ref := range doc.ctx.References() {
+		pos := ref.Node.Pos()
+		if pos.FileName() != doc.path {
+			continue
+		}
+		r := doc.src.nameRange(pos, ref.Node.Payload)
+		if r.Start.Line == p.Line && r.Start.Character <= p.Character && p.Character < r.End.Character {
+			found, ok = ref, true
+		}
+	}
+	return found, ok
+}
+
+func (s *server) hover(params textDocumentPositionParams) any {
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return nil
+	}
+	ref, ok := doc.referenceAt(params.Position)
+	if !ok {
+		return nil
+	}
+	return hover{
+		Contents: markupContent{
+			Kind:  "markdown",
+			Value: "```raja\n" + typecheck.Signature(ref.Node.Payload, ref.Typed) + "\n```",
+		},
+		Range: doc.src.nameRange(ref.Node.Pos(), ref.Node.Payload),
+	}
+}
+
+// Every implementation of a function, or where a variable got its value
+func (s *server) definition(params textDocumentPositionParams) any {
+	locations := []location{}
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return locations
+	}
+	ref, ok := doc.referenceAt(params.Position)
+	if !ok {
+		return locations
+	}
+	for _, pos := range typecheck.Definitions(ref.Typed) {
+		// The base lib and other standard libraries are not files the client can open
+		if !filepath.IsAbs(pos.FileName()) {
+			continue
+		}
+		p := s.source(pos.FileName()).toPosition(pos)
+		locations = append(locations, location{
+			URI:   pathToURI(pos.FileName()),
+			Range: lspRange{Start: p, End: p},
+		})
+	}
+	return locations
+}
+
+// Every name in scope, including the base lib, and the names used in the document
+func (s *server) completion(params textDocumentPositionParams) any {
+	items := []completionItem{}
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return items
+	}
+	names := map[string]typecheck.TypedAstNode{}
+	for name, typed := range doc.ctx.Globals() {
+		// Builtins are wrapped by the base lib
+		if !strings.HasPrefix(name, "__") {
+			names[name] = typed
+		}
+	}
+	for _, ref := range doc.ctx.References() {
+		if ref.Node.Pos().FileName() == doc.path {
+			names[ref.Node.Payload] = ref.Typed
+		}
+	}
+	for name, typed := range names {
+		kind := completionVariable
+		if typecheck.IsFunction(typed) {
+			kind = completionFunction
+		} else if typecheck.IsModule(typed) {
+			kind = completionModule
+		}
+		items = append(items, completionItem{
+			Label:  name,
+			Kind:   kind,
+			Detail: typecheck.Signature(name, typed),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Label < items[j].Label
+	})
+	return items
+}
+
+// Top-level assignments and aliases
+func (s *server) documentSymbols(params documentSymbolParams) any {
+	symbols := []documentSymbol{}
+	doc, ok := s.documents[params.TextDocument.URI]
+	if !ok {
+		return symbols
+	}
+	for _, node := range doc.nodes {
+		switch n := node.(type) {
+		case ast.AssignmentNode:
+			left, ok := n.Left.(ast.IdentifierNode)
+			if !ok {
+				continue
+			}
+			symbol := documentSymbol{
+				Name:           left.Payload,
+				Kind:           symbolVariable,
+				Range:          doc.src.nameRange(left.Pos(), left.Payload),
+				SelectionRange: doc.src.nameRange(left.Pos(), left.Payload),
+			}
+			if fn, ok := n.Right.(ast.FnNode); ok {
+				symbol.Kind = symbolFunction
+				symbol.Detail = signature(fn)
+			}
+			symbols = append(symbols, symbol)
+		case ast.AliasNode:
+			// The position of an alias is the alias keyword, followed by the name
+			r := doc.src.nameRange(n.Pos(), "alias "+n.Name)
+			selection := r
+			selection.Start.Character += len("alias ")
+			symbols = append(symbols, documentSymbol{
+				Name:           n.Name,
+				Detail:         strings.TrimPrefix(n.String(), "alias "),
+				Kind:           symbolEnum,
+				Range:          r,
+				SelectionRange: selection,
+			})
+		}
+	}
+	return symbols
+}
+
+// (a:Int, b) -> Str
+func signature(fn ast.FnNode) string {
+	args := make([]string, len(fn.Args))
+	for i, a := range fn.Args {
+		args[i] = a.String()
+	}
+	s := "(" + strings.Join(args, ", ") + ")"
+	if fn.Return != nil {
+		s += " -> " + fn.Return.String()
+	}
+	return s
+}
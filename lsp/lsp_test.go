@@ -0,0 +1,295 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Scripts a session: every message is written up front, and the responses are read once the server exits.
+type client struct {
+	t      *testing.T
+	input  bytes.Buffer
+	nextID int
+}
+
+func (c *client) send(msg map[string]any) {
+	msg["jsonrpc"] = "2.0"
+	if err := writeMessage(&c.input, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+// Returns the id of the request
+func (c *client) request(method string, params any) int {
+	c.nextID++
+	c.send(map[string]any{"id": c.nextID, "method": method, "params": params})
+	return c.nextID
+}
+
+func (c *client) notify(method string, params any) {
+	c.send(map[string]any{"method": method, "params": params})
+}
+
+type session struct {
+	results       map[int]json.RawMessage
+	errors        map[int]responseError
+	notifications []message
+}
+
+func (c *client) run() session {
+	c.request("shutdown", nil)
+	c.notify("exit", nil)
+	var output bytes.Buffer
+	if err := Serve(&c.input, &output); err != nil {
+		c.t.Fatalf("Serve failed: %s", err)
+	}
+	s := session{
+		results: map[int]json.RawMessage{},
+		errors:  map[int]responseError{},
+	}
+	r := bufio.NewReader(&output)
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		var msg struct {
+			ID     *int            `json:"id"`
+			Method string          `json:"method"`
+			Params json.RawMessage `json:"params"`
+			Result json.RawMessage `json:"result"`
+			Error  *responseError  `json:"error"`
+		}
+		if err := json.Unmarshal(body, &msg); err != nil {
+			c.t.Fatalf("Invalid message from server: %s", body)
+		}
+		switch {
+		case msg.ID == nil:
+			s.notifications = append(s.notifications, message{Method: msg.Method, Params: msg.Params})
+		case msg.Error != nil:
+			s.errors[*msg.ID] = *msg.Error
+		default:
+			s.results[*msg.ID] = msg.Result
+		}
+	}
+	return s
+}
+
+func (s session) result(t *testing.T, id int, v any) {
+	raw, ok := s.results[id]
+	if !ok {
+		t.Fatalf("No result for request %d", id)
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		t.Fatalf("Could not read result %s: %s", raw, err)
+	}
+}
+
+const program = `combine = (a:Int, b:Int) -> Int => a + b
+combine = (a:Str, b:Str) => a ++ b
+x = combine(1, 2)
+alias Shape = Shape::Circle | Shape::Square
+y = x ++ "a"
+`
+
+func openProgram(t *testing.T) (*client, string) {
+	c := &client{t: t}
+	uri := pathToURI(filepath.Join(t.TempDir(), "main.raja"))
+	c.request("initialize", map[string]any{})
+	c.notify("initialized", map[string]any{})
+	c.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "languageId": "raja", "version": 1, "text": program},
+	})
+	return c, uri
+}
+
+func at(uri string, line, character int) map[string]any {
+	return map[string]any{
+		"textDocument": map[string]any{"uri": uri},
+		"position":     map[string]any{"line": line, "character": character},
+	}
+}
+
+func TestInitialize(t *testing.T) {
+	c := &client{t: t}
+	id := c.request("initialize", map[string]any{})
+	s := c.run()
+	var result struct {
+		Capabilities map[string]any `json:"capabilities"`
+	}
+	s.result(t, id, &result)
+	for _, capability := range []string{"hoverProvider", "definitionProvider", "completionProvider", "documentSymbolProvider"} {
+		if _, ok := result.Capabilities[capability]; !ok {
+			t.Errorf("Expected %s in capabilities: %v", capability, result.Capabilities)
+		}
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	c, uri := openProgram(t)
+	c.notify("textDocument/didChange", map[string]any{
+		"textDocument":   map[string]any{"uri": uri, "version": 2},
+		"contentChanges": []map[string]any{{"text": "x = 1\n"}},
+	})
+	s := c.run()
+	if len(s.notifications) != 2 {
+		t.Fatalf("Expected diagnostics for open and change, got %d notifications", len(s.notifications))
+	}
+
+	var opened publishDiagnosticsParams
+	json.Unmarshal(s.notifications[0].Params, &opened)
+	if len(opened.Diagnostics) != 1 {
+		t.Fatalf("Expected 1 diagnostic, got %+v", opened.Diagnostics)
+	}
+	d := opened.Diagnostics[0]
+	if d.Range.Start.Line != 4 || d.Severity != severityError {
+		t.Errorf("Expected an error on line 4, got %+v", d)
+	}
+	if strings.Contains(d.Message, "\x1b") {
+		t.Errorf("Expected the message without terminal colors, got %q", d.Message)
+	}
+
+	var changed publishDiagnosticsParams
+	json.Unmarshal(s.notifications[1].Params, &changed)
+	if changed.URI != uri || len(changed.Diagnostics) != 0 {
+		t.Errorf("Expected the fixed document to have no diagnostics, got %+v", changed)
+	}
+}
+
+func TestParseErrorDiagnostic(t *testing.T) {
+	c := &client{t: t}
+	uri := pathToURI(filepath.Join(t.TempDir(), "main.raja"))
+	c.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "text": "x = (1 +\n"},
+	})
+	s := c.run()
+	var published publishDiagnosticsParams
+	json.Unmarshal(s.notifications[0].Params, &published)
+	if len(published.Diagnostics) != 1 {
+		t.Errorf("Expected the parse error as a diagnostic, got %+v", published.Diagnostics)
+	}
+}
+
+func TestHover(t *testing.T) {
+	c, uri := openProgram(t)
+	onX := c.request("textDocument/hover", at(uri, 2, 0))
+	onAdd := c.request("textDocument/hover", at(uri, 2, 5))
+	onNothing := c.request("textDocument/hover", at(uri, 3, 20))
+	s := c.run()
+
+	var h hover
+	s.result(t, onX, &h)
+	if !strings.Contains(h.Contents.Value, "x: Int") {
+		t.Errorf("Expected hover to show x: Int, got %q", h.Contents.Value)
+	}
+	s.result(t, onAdd, &h)
+	if !strings.Contains(h.Contents.Value, "combine(a:Int, b:Int) -> Int\ncombine(a:Str, b:Str) -> Str") {
+		t.Errorf("Expected hover to show both implementations of combine, got %q", h.Contents.Value)
+	}
+	if string(s.results[onNothing]) != "null" {
+		t.Errorf("Expected no hover outside identifiers, got %s", s.results[onNothing])
+	}
+}
+
+// Characters are counted in UTF-16 code units, where the emoji takes two
+func TestHoverUTF16(t *testing.T) {
+	c := &client{t: t}
+	uri := pathToURI(filepath.Join(t.TempDir(), "main.raja"))
+	c.notify("textDocument/didOpen", map[string]any{
+		"textDocument": map[string]any{"uri": uri, "text": "x = 1\ny = [\"😀\", x]\n"},
+	})
+	onX := c.request("textDocument/hover", at(uri, 1, 11))
+	beforeX := c.request("textDocument/hover", at(uri, 1, 10))
+	s := c.run()
+
+	var h hover
+	s.result(t, onX, &h)
+	if !strings.Contains(h.Contents.Value, "x: Int") {
+		t.Errorf("Expected hover to show x: Int, got %q", h.Contents.Value)
+	}
+	if h.Range != (lspRange{Start: position{Line: 1, Character: 11}, End: position{Line: 1, Character: 12}}) {
+		t.Errorf("Expected the range of x in UTF-16 code units, got %+v", h.Range)
+	}
+	if string(s.results[beforeX]) != "null" {
+		t.Errorf("Expected no hover before x, got %s", s.results[beforeX])
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	c, uri := openProgram(t)
+	id := c.request("textDocument/definition", at(uri, 2, 4))
+	s := c.run()
+	var locations []location
+	s.result(t, id, &locations)
+	if len(locations) != 2 {
+		t.Fatalf("Expected both implementations of combine, got %+v", locations)
+	}
+	for i, l := range locations {
+		if l.URI != uri || l.Range.Start.Line != i {
+			t.Errorf("Expected implementation %d on line %d, got %+v", i, i, l)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	c, uri := openProgram(t)
+	id := c.request("textDocument/completion", at(uri, 4, 0))
+	s := c.run()
+	var items []completionItem
+	s.result(t, id, &items)
+	labels := map[string]completionItem{}
+	for _, item := range items {
+		labels[item.Label] = item
+	}
+	for _, name := range []string{"combine", "x", "Shape", "map", "println"} {
+		if _, ok := labels[name]; !ok {
+			t.Errorf("Expected %s to be completed", name)
+		}
+	}
+	if labels["combine"].Kind != completionFunction || labels["x"].Kind != completionVariable {
+		t.Errorf("Wrong kinds: %+v, %+v", labels["combine"], labels["x"])
+	}
+	if _, ok := labels["__print"]; ok {
+		t.Errorf("Builtins should not be completed")
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	c, uri := openProgram(t)
+	id := c.request("textDocument/documentSymbol", map[string]any{"textDocument": map[string]any{"uri": uri}})
+	s := c.run()
+	var symbols []documentSymbol
+	s.result(t, id, &symbols)
+	expected := []documentSymbol{
+		{Name: "combine", Kind: symbolFunction, Detail: "(a:Int, b:Int) -> Int"},
+		{Name: "combine", Kind: symbolFunction, Detail: "(a:Str, b:Str)"},
+		{Name: "x", Kind: symbolVariable},
+		{Name: "Shape", Kind: symbolEnum, Detail: "Shape = Shape::Circle | Shape::Square"},
+		{Name: "y", Kind: symbolVariable},
+	}
+	if len(symbols) != len(expected) {
+		t.Fatalf("Expected %d symbols, got %+v", len(expected), symbols)
+	}
+	for i, e := range expected {
+		got := symbols[i]
+		if got.Name != e.Name || got.Kind != e.Kind || got.Detail != e.Detail {
+			t.Errorf("Expected %+v, got %+v", e, got)
+		}
+	}
+	if symbols[3].SelectionRange.Start != (position{Line: 3, Character: 6}) {
+		t.Errorf("Expected Shape to be selected after the alias keyword, got %+v", symbols[3].SelectionRange)
+	}
+}
+
+func TestUnknownRequest(t *testing.T) {
+	c := &client{t: t}
+	id := c.request("textDocument/rename", map[string]any{})
+	s := c.run()
+	if s.errors[id].Code != codeMethodNotFound {
+		t.Errorf("Expected method not found, got %+v", s.errors[id])
+	}
+}
@@ -3,10 +3,15 @@ package main
 import (
 	"dghaehre/raja/codegen"
 	"dghaehre/raja/eval"
+	"dghaehre/raja/format"
+	"dghaehre/raja/lsp"
 	"dghaehre/raja/typecheck"
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
 
 	color "github.com/dghaehre/termcolor"
 )
@@ -15,16 +20,37 @@ func usage() string {
 	header := fmt.Sprintf("%s, the programming language\n\n", color.Str(color.Blue, "Raja"))
 	usage := fmt.Sprintf(`%s:
     raja [OPTIONS] [FILE]
+    raja lsp
+    raja fmt [-w] [--check] FILES...
+    raja test [-run REGEXP] [PATHS...]
 
 If no FILE is given, a repl is opened.
+raja lsp starts a language server, speaking LSP over stdin and stdout.
+raja fmt prints the given files formatted. With -w the files are overwritten,
+and with --check the files that are not formatted are listed.
+raja test runs every top-level function named test_* in the *_test.raja files
+found in PATHS, which defaults to the current directory. With -run only the
+tests with a name matching REGEXP are run.
 
 %s:
     --check       Check given file for type errors and similar.
                   It will not run the file.
 
+    --strict      Make --check fail on warnings, like non-exhaustive matches.
+
     --build       Check given file for type errors and similar, and then build binary.
                   It will not run the file.
 
+    -o PATH       Where --build puts the binary.
+                  Defaults to the name of the file without .raja
+
+    --emit-go FILE
+                  Keep the Go source generated by --build in FILE
+
+    --goos OS, --goarch ARCH
+                  Target of --build, for cross-compiling.
+                  Defaults to GOOS and GOARCH from the environment.
+
     -h, --help    Show this message
     `, color.Str(color.Yellow, "USAGE"), color.Str(color.Yellow, "OPTIONS"))
 
@@ -41,12 +67,19 @@ func runFile(filePath string) {
 	c := eval.NewContext()
 	c.LoadBuiltins()
 	_, err = c.Eval(file, filePath)
+	if exit, ok := err.(*eval.ExitError); ok {
+		if exit.Panic {
+			fmt.Println(exit)
+		}
+		os.Exit(exit.Code)
+	}
 	if err != nil {
 		fmt.Println(err)
+		os.Exit(1)
 	}
 }
 
-func checkFile(filePath string) {
+func checkFile(filePath string, strict bool) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		fmt.Printf("Could not open %s: %s\n", filePath, err)
@@ -56,15 +89,19 @@ func checkFile(filePath string) {
 	c := typecheck.NewTypecheckContext()
 	c.LoadBuiltins()
 	c.LoadLibs()
+	c.Strict = strict
 	_, err = c.Typecheck(file, filePath)
 	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
+	}
+	if warnings := c.Warnings(); warnings != nil {
+		fmt.Printf("%s\n\n", warnings)
 	}
 	color.Println(color.Green, "If it compiles it works")
 }
 
-func buildFile(filePath string) {
+func buildFile(filePath string, opts codegen.BuildOptions) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		fmt.Printf("Could not open %s: %s\n", filePath, err)
@@ -74,41 +111,196 @@ func buildFile(filePath string) {
 	c := typecheck.NewTypecheckContext()
 	c.LoadBuiltins()
 	c.LoadLibs()
-	typed, err := c.Typecheck(file, filePath)
+	typed, err := c.TypecheckProgram(file, filePath)
 	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
+	}
+	if opts.Output == "" {
+		opts.Output = strings.TrimSuffix(filepath.Base(filePath), ".raja")
 	}
-	output := "test-bin"
-	err = codegen.Build(typed, "/home/dghaehre/projects/personal/raja/" + output)
+	err = codegen.Build(typed, c.Kinds(), opts)
 	if err != nil {
 		fmt.Println(err)
-		return
+		os.Exit(1)
 	}
 	head := color.Str(color.Green, "Ready to ship!\n\n")
-	filename := color.Str(color.Blue, output + "\n")
+	filename := color.Str(color.Blue, opts.Output+"\n")
 	fmt.Println(head + filename)
 }
 
+// raja fmt [-w] [--check] files...
+func fmtFiles(args []string) {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "Write the formatted source back to the file")
+	check := flags.Bool("check", false, "List files that are not formatted")
+	flags.Usage = func() {
+		fmt.Println(usage())
+	}
+	flags.Parse(args)
+	failed := false
+	for _, filePath := range flags.Args() {
+		source, err := os.ReadFile(filePath)
+		if err != nil {
+			fmt.Printf("Could not open %s: %s\n", filePath, err)
+			os.Exit(1)
+		}
+		formatted, err := format.Format(string(source), filePath)
+		if err != nil {
+			fmt.Println(err)
+			failed = true
+			continue
+		}
+		switch {
+		case *check:
+			if formatted != string(source) {
+				fmt.Println(filePath)
+				failed = true
+			}
+		case *write:
+			if formatted == string(source) {
+				continue
+			}
+			if err := os.WriteFile(filePath, []byte(formatted), 0o644); err != nil {
+				fmt.Printf("Could not write %s: %s\n", filePath, err)
+				os.Exit(1)
+			}
+		default:
+			fmt.Print(formatted)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// The *_test.raja files in the given paths, where directories are searched recursively
+func findTestFiles(paths []string) ([]string, error) {
+	files := []string{}
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(filePath string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if filePath == path && !d.IsDir() {
+				files = append(files, filePath)
+			} else if !d.IsDir() && strings.HasSuffix(filePath, "_test.raja") {
+				files = append(files, filePath)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// raja test [-run regexp] [paths...]
+func testFiles(args []string) {
+	flags := flag.NewFlagSet("test", flag.ExitOnError)
+	run := flags.String("run", "", "Only run tests with a name matching the regexp")
+	flags.Usage = func() {
+		fmt.Println(usage())
+	}
+	flags.Parse(args)
+	filter, err := regexp.Compile(*run)
+	if err != nil {
+		fmt.Printf("Invalid -run: %s\n", err)
+		os.Exit(1)
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := findTestFiles(paths)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	passed, failed := 0, 0
+	for _, filePath := range files {
+		source, err := os.ReadFile(filePath)
+		if err != nil {
+			fmt.Printf("Could not open %s: %s\n", filePath, err)
+			os.Exit(1)
+		}
+		tests, err := eval.FindTests(string(source), filePath)
+		if err != nil {
+			fmt.Println(err)
+			failed++
+			continue
+		}
+		var file *eval.TestFile
+		for _, test := range tests {
+			if !filter.MatchString(test.Name) {
+				continue
+			}
+			if file == nil {
+				file = eval.LoadTestFile(string(source), filePath)
+			}
+			err := file.Run(test)
+			if failure, ok := err.(*eval.TestFailure); ok {
+				fmt.Printf("%s %s %s\n", color.Str(color.Red, "FAIL"), test.Name, failure.Failed)
+				fmt.Printf("%s\n\n", failure)
+				failed++
+				continue
+			}
+			fmt.Printf("%s %s\n", color.Str(color.Green, "PASS"), test.Name)
+			passed++
+		}
+	}
+
+	fmt.Printf("\n%d passed, %d failed\n", passed, failed)
+	if failed > 0 {
+		os.Exit(1)
+	}
+}
+
 func main() {
 	check := flag.Bool("check", false, "Typecheck")
+	strict := flag.Bool("strict", false, "Fail --check on warnings")
 	build := flag.Bool("build", false, "Build binary")
+	output := flag.String("o", "", "Path of the built binary")
+	emitGo := flag.String("emit-go", "", "Write the generated Go source to file")
+	goos := flag.String("goos", os.Getenv("GOOS"), "Target operating system")
+	goarch := flag.String("goarch", os.Getenv("GOARCH"), "Target architecture")
 	flag.Usage = func() {
 		fmt.Println(usage())
 	}
 	flag.Parse()
 	args := flag.Args()
 	if len(args) == 0 {
-		// TODO: repl
-		fmt.Println("TODO: repl")
+		runRepl()
+		return
+	}
+	if args[0] == "lsp" {
+		if err := lsp.Serve(os.Stdin, os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+	if args[0] == "fmt" {
+		fmtFiles(args[1:])
+		return
+	}
+	if args[0] == "test" {
+		testFiles(args[1:])
 		return
 	}
 	if *check {
-		checkFile(args[0])
+		checkFile(args[0], *strict)
 		return
 	}
 	if *build {
-		buildFile(args[0])
+		buildFile(args[0], codegen.BuildOptions{
+			Output: *output,
+			EmitGo: *emitGo,
+			GOOS:   *goos,
+			GOARCH: *goarch,
+		})
 		return
 	}
 	runFile(args[0])
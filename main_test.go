@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"dghaehre/raja/eval"
+)
+
+func TestIsIncomplete(t *testing.T) {
+	for source, expected := range map[string]bool{
+		"x = 1\n":                 false,
+		"f = (a) => {\n":          true,
+		"f = (a) => {\na\n}\n":    false,
+		"[1,\n2\n":                true,
+		"\"{\"\n":                 false,
+		"match x {\n1 -> [\n":     true,
+		"match x {\n1 -> []\n}\n": false,
+	} {
+		if isIncomplete(source) != expected {
+			t.Errorf("Expected isIncomplete(%q) to be %t", source, expected)
+		}
+	}
+}
+
+func TestEvalReplInputRecovers(t *testing.T) {
+	c := eval.NewContext()
+	c.LoadBuiltins()
+	v, err := evalReplInput(&c, "1 + 2")
+	if err != nil || v.String() != "3" {
+		t.Fatalf("Expected 3, got %v, %v", v, err)
+	}
+	// A nil Context panics when evaluating, which is reported as an error
+	v, err = evalReplInput(nil, "1")
+	if err == nil || !strings.Contains(err.Error(), "Internal error") {
+		t.Errorf("Expected an internal error, got %v, %v", v, err)
+	}
+}
+
+func TestRepl(t *testing.T) {
+	in := strings.NewReader(`x = 2
+f = (a) => {
+	a * x
+}
+f(3)
+println("printed")
+undefined_name
+x + 1
+`)
+	var out strings.Builder
+	repl(in, &out)
+	output := out.String()
+	for _, expected := range []string{
+		// The definition of f is read over three lines
+		"> . . ",
+		"6\n",
+		"printed\n",
+		"undefined_name is undefined",
+		// x is still bound after an error
+		"3\n",
+	} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected the repl output to contain %q, got:\n%s", expected, output)
+		}
+	}
+}
+
+func TestReplExit(t *testing.T) {
+	var out strings.Builder
+	repl(strings.NewReader("exit(0)\n1 + 1\n"), &out)
+	if strings.Contains(out.String(), "2") {
+		t.Errorf("Expected exit to end the session, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bufio"
+	"dghaehre/raja/ast"
+	"dghaehre/raja/eval"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	color "github.com/dghaehre/termcolor"
+)
+
+const (
+	replPrompt         = "> "
+	replContinuePrompt = ". "
+)
+
+// Returns true if the source has more opening than closing brackets,
+// meaning that we should keep reading lines before evaluating.
+func isIncomplete(source string) bool {
+	tokenizer := ast.NewTokenizer(source, "repl")
+	depth := 0
+	for _, tok := range tokenizer.Tokenize() {
+		switch tok.Kind {
+		case ast.LeftParen, ast.LeftBracket, ast.LeftBrace:
+			depth++
+		case ast.RightParen, ast.RightBracket, ast.RightBrace:
+			depth--
+		}
+	}
+	return depth > 0
+}
+
+// Evaluates the given source in the context, and recovers from any panics
+// so that one bad line does not kill the whole repl session.
+func evalReplInput(c *eval.Context, source string) (v eval.Value, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%s: %v", color.Str(color.Red, "Internal error"), r)
+		}
+	}()
+	return c.Eval(strings.NewReader(source), "repl")
+}
+
+func repl(in io.Reader, out io.Writer) {
+	// Output of the program goes to out, along with the results
+	c := eval.NewContext(eval.WithStdout(out), eval.WithStderr(out))
+	c.LoadBuiltins()
+
+	fmt.Fprintf(out, "%s repl. Exit with ctrl-d\n", color.Str(color.Blue, "Raja"))
+	scanner := bufio.NewScanner(in)
+	input := ""
+	fmt.Fprint(out, replPrompt)
+	for scanner.Scan() {
+		input += scanner.Text() + "\n"
+		if isIncomplete(input) {
+			fmt.Fprint(out, replContinuePrompt)
+			continue
+		}
+
+		if strings.TrimSpace(input) != "" {
+			v, err := evalReplInput(&c, input)
+			if exit, ok := err.(*eval.ExitError); ok && !exit.Panic {
+				// exit ends the session, like ctrl-d
+				break
+			}
+			if err != nil {
+				fmt.Fprintln(out, err)
+			} else if v != nil {
+				fmt.Fprintln(out, v.String())
+			}
+		}
+		input = ""
+		fmt.Fprint(out, replPrompt)
+	}
+	fmt.Fprintln(out)
+}
+
+func runRepl() {
+	repl(os.Stdin, os.Stdout)
+}
@@ -4,50 +4,62 @@ import (
 	"dghaehre/raja/ast"
 )
 
-var maybeAlias TypedAstNode = typedAliasNode{
-	name: "Maybe",
-	targets: []TypedAstNode{
-		typedEnumNode{
-			parent: "Maybe",
-			name:   "Some",
-			args: []TypedAstNode{
-				typedAnyNode{},
+// Maybe(t), as defined in base
+func maybeOf(t TypedAstNode) TypedAstNode {
+	return typedAliasNode{
+		name: "Maybe",
+		targets: []TypedAstNode{
+			typedEnumNode{
+				parent: "Maybe",
+				name:   "Some",
+				args: []TypedAstNode{
+					t,
+				},
+			},
+			typedEnumNode{
+				parent: "Maybe",
+				name:   "None",
+				args:   []TypedAstNode{},
 			},
 		},
-		typedEnumNode{
-			parent: "Maybe",
-			name:   "None",
-			args:   []TypedAstNode{},
-		},
-	},
+		args: typedArgs{t},
+	}
 }
 
-var resultAlias TypedAstNode = typedAliasNode{
-	name: "Result",
-	targets: []TypedAstNode{
-		typedEnumNode{
-			parent: "Result",
-			name:   "Ok",
-			args: []TypedAstNode{
-				typedAnyNode{},
+// Result(ok, err), as defined in base
+func resultOf(ok TypedAstNode, err TypedAstNode) TypedAstNode {
+	return typedAliasNode{
+		name: "Result",
+		targets: []TypedAstNode{
+			typedEnumNode{
+				parent: "Result",
+				name:   "Ok",
+				args: []TypedAstNode{
+					ok,
+				},
 			},
-		},
-		typedEnumNode{
-			parent: "Result",
-			name:   "Err",
-			args: []TypedAstNode{
-				typedAnyNode{},
+			typedEnumNode{
+				parent: "Result",
+				name:   "Err",
+				args: []TypedAstNode{
+					err,
+				},
 			},
 		},
-	},
+		args: typedArgs{ok, err},
+	}
 }
 
-var iteratorAlias TypedAstNode = typedAliasNode{
-	name: "Iterator",
-	targets: []TypedAstNode{
-		typedStringNode{},
-		typedListNode{},
-	},
+// Iterator(t), as defined in base
+func iteratorOf(t TypedAstNode) TypedAstNode {
+	return typedAliasNode{
+		name: "Iterator",
+		targets: []TypedAstNode{
+			typedListNode{elem: t},
+			typedStringNode{},
+		},
+		args: typedArgs{t},
+	}
 }
 
 var numAlias TypedAstNode = typedAliasNode{
@@ -72,25 +84,124 @@ var floatAlias TypedAstNode = typedAliasNode{
 	},
 }
 
+// What __length accepts
+var lengthAlias TypedAstNode = typedAliasNode{
+	targets: []TypedAstNode{
+		typedStringNode{},
+		typedListNode{},
+		typedMapNode{},
+	},
+}
+
 func (c *TypecheckContext) LoadBuiltins() {
+	a := typedTypeVar{name: "a"}
+	k := typedTypeVar{name: "k"}
+	v := typedTypeVar{name: "v"}
+	mapOfKV := typedMapNode{key: k, value: v}
+
 	c.LoadFunc("__print", typedIntNode{}, typedArg{name: "value"})
+	c.LoadFunc("__eprint", typedIntNode{}, typedArg{name: "value"})
 
 	c.LoadFunc("__string", typedStringNode{}, typedArg{name: "value"})
-	c.LoadFunc("__int", resultAlias, typedArg{name: "value"})
-	c.LoadFunc("__args", typedListNode{})
-	c.LoadFunc("__exit", typedAnyNode{}, typedArg{name: "value", alias: typedIntNode{}})
-	c.LoadFunc("__read_file", resultAlias, typedArg{name: "filename", alias: typedStringNode{}})
-	c.LoadFunc("__length", typedIntNode{}, typedArg{name: "iter", alias: iteratorAlias})
-	c.LoadFunc("__index", maybeAlias, typedArg{name: "iter", alias: iteratorAlias}, typedArg{name: "index", alias: typedIntNode{}}, typedArg{name: "unsafe?", alias: typedBoolNode{}})
+	c.LoadFunc("__int", resultOf(typedIntNode{}, typedStringNode{}), typedArg{name: "value"})
+	c.LoadFunc("__args", typedListNode{elem: typedStringNode{}})
+	c.LoadFunc("__exit", typedNeverNode{}, typedArg{name: "value", alias: typedIntNode{}})
+	c.LoadFunc("__fail", typedNeverNode{}, typedArg{name: "message", alias: typedStringNode{}})
+	c.LoadFunc("__panic", typedNeverNode{}, typedArg{name: "message", alias: typedStringNode{}})
+	c.LoadFunc("__try", resultOf(typedAnyNode{}, typedStringNode{}), typedArg{name: "f", alias: typedAnyFnNode{}})
+	c.LoadFunc("__read_file", resultOf(typedStringNode{}, typedStringNode{}), typedArg{name: "filename", alias: typedStringNode{}})
+	c.LoadFunc("__read_line", maybeOf(typedStringNode{}))
+	c.LoadFunc("__read_all_stdin", resultOf(typedStringNode{}, typedStringNode{}))
+	c.LoadFunc("__lines", typedListNode{elem: typedAnyNode{}}, typedArg{name: "f", alias: typedAnyFnNode{}})
+
+	path := typedArg{name: "path", alias: typedStringNode{}}
+	content := typedArg{name: "content", alias: typedStringNode{}}
+	c.LoadFunc("__write_file", resultOf(typedStringNode{}, typedStringNode{}), path, content)
+	c.LoadFunc("__append_file", resultOf(typedStringNode{}, typedStringNode{}), path, content)
+	c.LoadFunc("__list_dir", resultOf(typedListNode{elem: typedStringNode{}}, typedStringNode{}), path)
+	c.LoadFunc("__exists", resultOf(typedBoolNode{}, typedStringNode{}), path)
+	c.LoadFunc("__remove", resultOf(typedStringNode{}, typedStringNode{}), path)
+	c.LoadFunc("__mkdir_all", resultOf(typedStringNode{}, typedStringNode{}), path)
+	c.LoadFunc("__file_info", resultOf(typedMapNode{key: typedStringNode{}, value: typedAnyNode{}}, typedStringNode{}), path)
+
+	str := typedArg{name: "str", alias: typedStringNode{}}
+	strs := typedListNode{elem: typedStringNode{}}
+	sep := typedArg{name: "sep", alias: typedStringNode{}}
+	cutset := typedArg{name: "cutset", alias: typedStringNode{}}
+	width := typedArg{name: "width", alias: typedIntNode{}}
+	pad := typedArg{name: "pad", alias: typedStringNode{}}
+	c.LoadFunc("__str_split", strs, str, sep)
+	c.LoadFunc("__str_join", typedStringNode{}, typedArg{name: "strs", alias: strs}, sep)
+	c.LoadFunc("__str_replace", typedStringNode{}, str, typedArg{name: "old", alias: typedStringNode{}}, typedArg{name: "new", alias: typedStringNode{}})
+	c.LoadFunc("__str_contains", typedBoolNode{}, str, typedArg{name: "substr", alias: typedStringNode{}})
+	c.LoadFunc("__str_index_of", maybeOf(typedIntNode{}), str, typedArg{name: "substr", alias: typedStringNode{}})
+	c.LoadFunc("__str_to_upper", typedStringNode{}, str)
+	c.LoadFunc("__str_to_lower", typedStringNode{}, str)
+	c.LoadFunc("__str_trim", typedStringNode{}, str, cutset)
+	c.LoadFunc("__str_trim_left", typedStringNode{}, str, cutset)
+	c.LoadFunc("__str_trim_right", typedStringNode{}, str, cutset)
+	c.LoadFunc("__str_trim_prefix", typedStringNode{}, str, typedArg{name: "prefix", alias: typedStringNode{}})
+	c.LoadFunc("__str_trim_suffix", typedStringNode{}, str, typedArg{name: "suffix", alias: typedStringNode{}})
+	c.LoadFunc("__str_repeat", typedStringNode{}, str, typedArg{name: "count", alias: typedIntNode{}})
+	c.LoadFunc("__str_pad_left", typedStringNode{}, str, width, pad)
+	c.LoadFunc("__str_pad_right", typedStringNode{}, str, width, pad)
+	c.LoadFunc("__str_lines", strs, str)
+	c.LoadFunc("__str_chars", strs, str)
+	c.LoadFunc("__str_starts_with", typedBoolNode{}, str, typedArg{name: "prefix", alias: typedStringNode{}})
+	c.LoadFunc("__str_ends_with", typedBoolNode{}, str, typedArg{name: "suffix", alias: typedStringNode{}})
+	c.LoadFunc("__ord", typedIntNode{}, typedArg{name: "char", alias: typedCharNode{}})
+	c.LoadFunc("__chr", typedCharNode{}, typedArg{name: "code", alias: typedIntNode{}})
+	c.LoadFunc("__char", typedCharNode{}, str)
+	c.LoadFunc("__bytes", typedListNode{elem: typedIntNode{}}, str)
+	c.LoadFunc("__byte_length", typedIntNode{}, str)
+
+	// Returning the type they are given, so that abs(Int) is an Int
+	n := typedTypeVar{name: "n"}
+	num := typedArg{name: "x", alias: numAlias}
+	ints := []TypedAstNode{typedArg{name: "a", alias: typedIntNode{}}, typedArg{name: "b", alias: typedIntNode{}}}
+	c.LoadFunc("__float", resultOf(typedFloatNode{}, typedStringNode{}), str)
+	c.LoadFunc("__to_float", typedFloatNode{}, num)
+	for _, name := range []string{"__trunc", "__round", "__floor", "__ceil"} {
+		c.LoadFunc(name, typedIntNode{}, num)
+	}
+	c.LoadFunc("__abs", n, typedArg{name: "x", alias: n})
+	c.LoadFunc("__min", n, typedArg{name: "a", alias: n}, typedArg{name: "b", alias: n})
+	c.LoadFunc("__max", n, typedArg{name: "a", alias: n}, typedArg{name: "b", alias: n})
+	c.LoadFunc("__int_pow", typedIntNode{}, ints...)
+	c.LoadFunc("__pow", typedFloatNode{}, num, typedArg{name: "y", alias: numAlias})
+	c.LoadFunc("__atan2", typedFloatNode{}, typedArg{name: "y", alias: numAlias}, num)
+	for _, name := range []string{"__sqrt", "__exp", "__log", "__log2", "__log10", "__sin", "__cos", "__tan", "__asin", "__acos", "__atan"} {
+		c.LoadFunc(name, typedFloatNode{}, num)
+	}
+	for _, name := range []string{"__div", "__mod", "__gcd", "__lcm"} {
+		c.LoadFunc(name, typedIntNode{}, ints...)
+	}
+
+	c.LoadFunc("__length", typedIntNode{}, typedArg{name: "iter", alias: lengthAlias})
+	c.LoadFunc("__index", maybeOf(a), typedArg{name: "iter", alias: iteratorOf(a)}, typedArg{name: "index", alias: typedIntNode{}}, typedArg{name: "unsafe?", alias: typedBoolNode{}})
+
+	c.LoadFunc("__map_get", maybeOf(v), typedArg{name: "map", alias: mapOfKV}, typedArg{name: "key", alias: k})
+	c.LoadFunc("__map_has", typedBoolNode{}, typedArg{name: "map", alias: mapOfKV}, typedArg{name: "key", alias: k})
+	c.LoadFunc("__map_insert", mapOfKV, typedArg{name: "map", alias: mapOfKV}, typedArg{name: "key", alias: k}, typedArg{name: "value", alias: v})
+	c.LoadFunc("__map_remove", mapOfKV, typedArg{name: "map", alias: mapOfKV}, typedArg{name: "key", alias: k})
+	c.LoadFunc("__map_keys", typedListNode{elem: k}, typedArg{name: "map", alias: mapOfKV})
+	c.LoadFunc("__map_values", typedListNode{elem: v}, typedArg{name: "map", alias: mapOfKV})
+	c.LoadFunc("__map_entries", typedListNode{elem: typedListNode{elem: getTypeFromMatchBodies([]TypedAstNode{k, v})}}, typedArg{name: "map", alias: mapOfKV})
+
+	// Gives the new value of the mut_ variable updated
+	c.LoadFunc("update", a, typedArg{name: "variable"}, typedArg{name: "value", alias: a})
 
 	// c.LoadFunc("__index", typedArg{name: "iter", alias: typedAliasNode{}})
 	//
 	// // Types/Alias
 	c.LoadAlias("Bool", typedBoolNode{})
 	c.LoadAlias("Int", typedIntNode{})
+	c.LoadAlias("BigInt", typedIntNode{})
 	c.LoadAlias("Float", typedFloatNode{})
 	c.LoadAlias("Str", typedStringNode{})
+	c.LoadAlias("Char", typedCharNode{})
 	c.LoadAlias("List", typedListNode{})
+	c.LoadAlias("Map", typedMapNode{})
 	c.LoadAlias("Fn", typedAnyFnNode{})
 	c.LoadAlias("Enum", typedEnumNode{})
 	//
@@ -98,6 +209,7 @@ func (c *TypecheckContext) LoadBuiltins() {
 	// if err != nil {
 	// 	panic(err)
 	// }
+	c.prelude = c.typecheckScope.snapshot()
 }
 
 func (c *TypecheckContext) LoadFunc(name string, returnType TypedAstNode, args ...TypedAstNode) {
@@ -0,0 +1,207 @@
+package typecheck
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"dghaehre/raja/ast"
+)
+
+// What tools like the language server can ask the typechecker about a program.
+
+// A problem found in a program
+type Diagnostic struct {
+	ast.Pos
+	Message string
+	Warning bool
+}
+
+// The diagnostics in err, as returned by Typecheck or Warnings.
+// Errors without a position, like failing to read the program, give none.
+func Diagnostics(err error) []Diagnostic {
+	found := diagnostics(err)
+	for i := range found {
+		found[i].Message = colorCodes.ReplaceAllString(found[i].Message, "")
+	}
+	return found
+}
+
+// Reasons are colored for the terminal
+var colorCodes = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func diagnostics(err error) []Diagnostic {
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case multipleErrors:
+		found := []Diagnostic{}
+		for _, err := range e.errors {
+			found = append(found, diagnostics(err)...)
+		}
+		for _, err := range e.warnings {
+			for _, d := range diagnostics(err) {
+				d.Warning = true
+				found = append(found, d)
+			}
+		}
+		return found
+	case *multipleErrors:
+		return diagnostics(*e)
+	case typecheckError:
+		return []Diagnostic{{Pos: e.Pos, Message: e.reason}}
+	case *typecheckError:
+		return []Diagnostic{{Pos: e.Pos, Message: e.reason}}
+	case typecheckWarning:
+		return []Diagnostic{{Pos: e.Pos, Message: e.reason, Warning: true}}
+	case *typecheckWarning:
+		return []Diagnostic{{Pos: e.Pos, Message: e.reason, Warning: true}}
+	case paramMismatchError:
+		return []Diagnostic{{Pos: e.Pos, Message: e.reason()}}
+	case *paramMismatchError:
+		return []Diagnostic{{Pos: e.Pos, Message: e.reason()}}
+	}
+	// Like parse errors
+	if positioned, ok := err.(interface{ Position() ast.Pos }); ok {
+		return []Diagnostic{{Pos: positioned.Position(), Message: err.Error()}}
+	}
+	return nil
+}
+
+// An identifier in the program, with the type it had where it was used or assigned
+type Reference struct {
+	Node  ast.IdentifierNode
+	Typed TypedAstNode
+}
+
+// Every identifier typechecked so far, including the ones in the base lib and imported modules
+func (c *TypecheckContext) References() []Reference {
+	return c.references
+}
+
+// The names in the top-level scope, including builtins and the base lib when loaded
+func (c *TypecheckContext) Globals() map[string]TypedAstNode {
+	globals := make(map[string]TypedAstNode, len(c.vars))
+	for name, typed := range c.vars {
+		globals[name] = typed
+	}
+	return globals
+}
+
+// The kind of value an expression always evaluates to, when the typechecker knows it.
+// Code generation uses it to work on Go values instead of runtime values.
+// Ints are left out, as they become big ints when they overflow.
+type Kind int
+
+const (
+	UnknownKind Kind = iota
+	BoolKind
+	FloatKind
+	StrKind
+)
+
+type kindKey struct {
+	ast.Pos
+	node reflect.Type
+}
+
+// The kind of every expression typechecked so far, including the ones in the base lib and imported modules
+type Kinds map[kindKey]Kind
+
+func (c *TypecheckContext) Kinds() Kinds {
+	return c.kinds
+}
+
+func (k Kinds) Of(node ast.AstNode) Kind {
+	return k[kindKey{node.Pos(), reflect.TypeOf(node)}]
+}
+
+// An expression that is typechecked more than once, like the body of a function
+// checked for each call, only has a kind if it is the same every time.
+func (k Kinds) record(node ast.AstNode, typed TypedAstNode) {
+	key := kindKey{node.Pos(), reflect.TypeOf(node)}
+	kind := kindOf(typed)
+	if seen, ok := k[key]; ok && seen != kind {
+		kind = UnknownKind
+	}
+	k[key] = kind
+}
+
+func kindOf(typed TypedAstNode) Kind {
+	switch n := typed.(type) {
+	case typedBoolNode:
+		return BoolKind
+	case typedFloatNode:
+		return FloatKind
+	case typedStringNode:
+		return StrKind
+	case typedAliasNode:
+		// Like Float, the alias of a single type
+		if len(n.targets) == 1 {
+			return kindOf(n.targets[0])
+		}
+	}
+	return UnknownKind
+}
+
+func IsFunction(typed TypedAstNode) bool {
+	return isOneOfType(typed, typedFnNodes{}, typedFnNode{}, typedAnyFnNode{})
+}
+
+func IsModule(typed TypedAstNode) bool {
+	_, ok := typed.(typedModuleNode)
+	return ok
+}
+
+// Where typed was defined: every implementation of a function, or the expression a variable was assigned.
+// Builtins are not defined anywhere.
+func Definitions(typed TypedAstNode) []ast.Pos {
+	positions := []ast.Pos{}
+	switch n := typed.(type) {
+	case typedFnNodes:
+		for _, fn := range n.values {
+			if fn.Node() != nil {
+				positions = append(positions, fn.Node().Pos())
+			}
+		}
+	default:
+		if typed.Node() != nil {
+			positions = append(positions, typed.Node().Pos())
+		}
+	}
+	return positions
+}
+
+// How name is shown to the user, with its type.
+// A function is shown with every implementation and what it returns:
+//
+//	get(a:Iterator(t), b:Int) -> Maybe(t)
+func Signature(name string, typed TypedAstNode) string {
+	switch n := typed.(type) {
+	case typedFnNodes:
+		lines := make([]string, len(n.values))
+		for i, fn := range n.values {
+			lines[i] = fnSignature(name, fn)
+		}
+		return strings.Join(lines, "\n")
+	case typedFnNode:
+		return fnSignature(name, n)
+	case typedModuleNode:
+		names := make([]string, 0, len(n.scope.vars))
+		for name := range n.scope.vars {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return fmt.Sprintf("%s: module %s\n%s", name, n.name, strings.Join(names, ", "))
+	}
+	return fmt.Sprintf("%s: %s", name, typed)
+}
+
+func fnSignature(name string, fn typedFnNode) string {
+	if fn.body == nil {
+		return name + fn.args.String()
+	}
+	return fmt.Sprintf("%s%s -> %s", name, fn.args, fn.body)
+}
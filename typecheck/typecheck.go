@@ -6,9 +6,12 @@ import (
 	"reflect"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 
 	"dghaehre/raja/ast"
 	"dghaehre/raja/lib"
+	"dghaehre/raja/util"
 
 	color "github.com/dghaehre/termcolor"
 )
@@ -32,6 +35,10 @@ type paramMismatchError struct {
 
 func (e paramMismatchError) Error() string {
 	head := color.Str(color.Red, "Parameter mismatch in function call")
+	return fmt.Sprintf("%s\n%s", head, e.reason())
+}
+
+func (e paramMismatchError) reason() string {
 	reason := ""
 	if len(e.fns) == 1 {
 		fnMatch := e.fns[0]
@@ -43,16 +50,29 @@ func (e paramMismatchError) Error() string {
 		reason += fmt.Sprintf("%s\n", fn)
 	}
 	reason += fmt.Sprintf("\nBut was provided: %s at %s", e.argsProvided, e.Pos)
-	return fmt.Sprintf("%s\n%s", head, reason)
+	return reason
+}
+
+// Reported for code that is probably wrong, but does not fail the typecheck unless it is strict
+type typecheckWarning struct {
+	reason string
+	ast.Pos
+}
+
+func (e typecheckWarning) Error() string {
+	head := color.Str(color.Yellow, "Warning")
+	return fmt.Sprintf("%s: at %s:\n%s", head, e.Pos, e.reason)
 }
 
 type multipleErrors struct {
-	errors []error
+	errors   []error
+	warnings []error
 }
 
 func (me multipleErrors) Error() string {
+	all := append(append([]error{}, me.errors...), me.warnings...)
 	s := ""
-	for i, v := range me.errors {
+	for i, v := range all {
 		if i > 0 {
 			s += "\n\n"
 		}
@@ -63,6 +83,10 @@ func (me multipleErrors) Error() string {
 		s += "\n\n" + color.Str(color.Red, "Errors: ")
 		s += strconv.Itoa(len(me.errors))
 	}
+	if len(me.warnings) > 1 {
+		s += "\n\n" + color.Str(color.Yellow, "Warnings: ")
+		s += strconv.Itoa(len(me.warnings))
+	}
 	return s
 }
 
@@ -74,6 +98,18 @@ type typecheckScope struct {
 
 	// used to keep track of recursion
 	currentFn string
+
+	// the annotated return type of currentFn, if any
+	currentFnReturn TypedAstNode
+}
+
+// A copy of the variables of sc, without its parent
+func (sc typecheckScope) snapshot() typecheckScope {
+	vars := make(map[string]TypedAstNode, len(sc.vars))
+	for name, typed := range sc.vars {
+		vars[name] = typed
+	}
+	return typecheckScope{vars: vars}
 }
 
 func (sc *typecheckScope) putCurrentFn(name string) {
@@ -90,6 +126,18 @@ func (sc *typecheckScope) isRecursion(name string) bool {
 	return sc.parent.isRecursion(name)
 }
 
+// The type of a recursive call: the annotated return type if there is one.
+// Otherwise we cannot know yet, as we are still inferring the body.
+func (sc *typecheckScope) recursionReturn(name string) TypedAstNode {
+	if sc.currentFn == name {
+		if sc.currentFnReturn != nil {
+			return sc.currentFnReturn
+		}
+		return typedAnyNode{}
+	}
+	return sc.parent.recursionReturn(name)
+}
+
 // TODO:
 // - changing a mutable variable
 func (sc *typecheckScope) put(name string, typed TypedAstNode, pos ast.Pos) error {
@@ -137,6 +185,24 @@ func (sc *typecheckScope) get(name string, pos ast.Pos) (TypedAstNode, error) {
 type TypecheckContext struct {
 	typecheckScope
 	multipleErrors
+
+	// Builtins and the base lib, which is all that modules see of the global scope
+	prelude typecheckScope
+
+	// Imported modules, by lib.Module.Key, so that each module is only typechecked once
+	modules map[string]typedModuleNode
+
+	// Modules currently being imported, used to detect cyclic imports
+	importing []lib.Module
+
+	// If set, warnings fail the typecheck like errors do
+	Strict bool
+
+	// Every identifier looked up so far, see References
+	references []Reference
+
+	// The kind of every expression typechecked so far, see Kinds
+	kinds Kinds
 }
 
 func NewTypecheckContext() TypecheckContext {
@@ -146,6 +212,8 @@ func NewTypecheckContext() TypecheckContext {
 			vars:      map[string]TypedAstNode{},
 			currentFn: "",
 		},
+		modules: map[string]typedModuleNode{},
+		kinds:   Kinds{},
 	}
 }
 
@@ -154,10 +222,89 @@ type TypedAstNode interface {
 	pos() ast.Pos
 
 	Eq(TypedAstNode) bool
-	// payload We might not need this one either
+
+	// The ast node this node was typechecked from. Nil for builtin types.
+	Node() ast.AstNode
+}
+
+// Embedded in every typed node, to keep track of the ast node it was typechecked from
+type origin struct {
+	node ast.AstNode
+}
+
+func (o origin) Node() ast.AstNode {
+	return o.node
 }
 
+// Returns a copy of typed, with node as its origin
+func withNode(typed TypedAstNode, node ast.AstNode) TypedAstNode {
+	o := origin{node: node}
+	switch n := typed.(type) {
+	case typedArg:
+		n.origin = o
+		return n
+	case untypedArg:
+		n.origin = o
+		return n
+	case typedEnumNode:
+		n.origin = o
+		return n
+	case typedAliasNode:
+		n.origin = o
+		return n
+	case typedAnyNode:
+		n.origin = o
+		return n
+	case typedTypeVar:
+		n.origin = o
+		return n
+	case typedNeverNode:
+		n.origin = o
+		return n
+	case typedAnyFnNode:
+		n.origin = o
+		return n
+	case typedIntNode:
+		n.origin = o
+		return n
+	case typedFloatNode:
+		n.origin = o
+		return n
+	case typedBoolNode:
+		n.origin = o
+		return n
+	case typedStringNode:
+		n.origin = o
+		return n
+	case typedCharNode:
+		n.origin = o
+		return n
+	case typedListNode:
+		n.origin = o
+		return n
+	case typedMapNode:
+		n.origin = o
+		return n
+	case typedFnNode:
+		n.origin = o
+		return n
+	case typedFnNodes:
+		n.origin = o
+		return n
+	case typedModuleNode:
+		n.origin = o
+		return n
+	default:
+		panic(fmt.Sprintf("withNode: unexpected typed node %T", typed))
+	}
+}
+
+// A typechecked program: the typed node of every top-level expression.
+// Each typed node carries the ast node it was typechecked from, see TypedAstNode.Node.
+type TypedProgram []TypedAstNode
+
 type typedArg struct {
+	origin
 	name  string
 	alias TypedAstNode
 }
@@ -181,6 +328,7 @@ func (a typedArg) Eq(b TypedAstNode) bool {
 }
 
 type untypedArg struct {
+	origin
 	name string
 }
 
@@ -211,6 +359,7 @@ func (args typedArgs) String() string {
 }
 
 type typedEnumNode struct {
+	origin
 	parent string
 	name   string
 	args   typedArgs
@@ -236,10 +385,21 @@ func (n typedEnumNode) pos() ast.Pos {
 
 func (a typedEnumNode) Eq(b TypedAstNode) bool {
 	switch b := b.(type) {
-	case typedAnyNode:
+	case typedAnyNode, typedTypeVar, typedNeverNode:
 		return true
 	case typedEnumNode:
-		return b.parent == a.parent && b.name == a.name
+		if b.parent != a.parent || b.name != a.name {
+			return false
+		}
+		if len(a.args) != len(b.args) {
+			return true
+		}
+		for i := range a.args {
+			if !a.args[i].Eq(b.args[i]) {
+				return false
+			}
+		}
+		return true
 	case typedAliasNode:
 		return b.Eq(a)
 	}
@@ -247,11 +407,20 @@ func (a typedEnumNode) Eq(b TypedAstNode) bool {
 }
 
 type typedAliasNode struct {
+	origin
 	name    string
 	targets []TypedAstNode
+
+	// Type parameters, which are type variables in targets: alias Maybe(a) = ...
+	params []string
+	// Set when the alias is given its type parameters: Maybe(Int)
+	args typedArgs
 }
 
 func (n typedAliasNode) String() string {
+	if n.name != "" && len(n.args) > 0 {
+		return n.name + n.args.String()
+	}
 	if n.name != "" {
 		return n.name
 	}
@@ -270,6 +439,16 @@ func (n typedAliasNode) pos() ast.Pos {
 }
 
 func (a typedAliasNode) Eq(b TypedAstNode) bool {
+	// Maybe(Int) and Maybe(Str) overlap on Maybe::None, so compare the parameters instead
+	if other, ok := b.(typedAliasNode); ok && a.name != "" && a.name == other.name &&
+		len(a.args) > 0 && len(a.args) == len(other.args) {
+		for i := range a.args {
+			if !a.args[i].Eq(other.args[i]) {
+				return false
+			}
+		}
+		return true
+	}
 	for _, t := range a.targets {
 		if t.Eq(b) {
 			return true
@@ -283,7 +462,9 @@ func (a typedAliasNode) Eq(b TypedAstNode) bool {
 //     and because our typechecking is note checking everything
 //   - when we encounter an error, we can return with a typedAnyNode
 //     and we will not cause anymore errors down the chain
-type typedAnyNode struct{}
+type typedAnyNode struct {
+	origin
+}
 
 func (n typedAnyNode) String() string {
 	return "Any"
@@ -297,9 +478,54 @@ func (a typedAnyNode) Eq(b TypedAstNode) bool {
 	return true
 }
 
+// A type parameter, like a in (l:List(a)) => ...
+//
+// It matches anything, and is replaced with the type it is given when a function is called.
+type typedTypeVar struct {
+	origin
+	name string
+}
+
+func (n typedTypeVar) String() string {
+	return n.name
+}
+
+func (n typedTypeVar) pos() ast.Pos {
+	return ast.Pos{}
+}
+
+func (a typedTypeVar) Eq(b TypedAstNode) bool {
+	return true
+}
+
+// The type of an expression that never returns, like exit(1).
+// It is left out of the type of a match, so that a branch that panics does not make it Any.
+type typedNeverNode struct {
+	origin
+}
+
+func (n typedNeverNode) String() string {
+	return "Never"
+}
+
+func (n typedNeverNode) pos() ast.Pos {
+	return ast.Pos{}
+}
+
+func (a typedNeverNode) Eq(b TypedAstNode) bool {
+	switch b.(type) {
+	case typedAnyNode, typedTypeVar, typedNeverNode:
+		return true
+	default:
+		return false
+	}
+}
+
 // Usecase for AnyFn:
 // when user creates map = (a, f:Fn) => ...
-type typedAnyFnNode struct{}
+type typedAnyFnNode struct {
+	origin
+}
 
 func (n typedAnyFnNode) String() string {
 	return "Fn"
@@ -311,7 +537,7 @@ func (n typedAnyFnNode) pos() ast.Pos {
 
 func (a typedAnyFnNode) Eq(b TypedAstNode) bool {
 	switch b := b.(type) {
-	case typedAnyNode, typedFnNode, typedAnyFnNode, typedFnNodes:
+	case typedAnyNode, typedTypeVar, typedNeverNode, typedFnNode, typedAnyFnNode, typedFnNodes:
 		return true
 	case typedAliasNode:
 		return b.Eq(a)
@@ -321,6 +547,7 @@ func (a typedAnyFnNode) Eq(b TypedAstNode) bool {
 }
 
 type typedIntNode struct {
+	origin
 	tok *ast.Token
 }
 
@@ -337,7 +564,7 @@ func (n typedIntNode) pos() ast.Pos {
 
 func (a typedIntNode) Eq(b TypedAstNode) bool {
 	switch b.(type) {
-	case typedAnyNode, typedIntNode:
+	case typedAnyNode, typedTypeVar, typedNeverNode, typedIntNode:
 		return true
 	case typedAliasNode:
 		return b.Eq(a)
@@ -347,6 +574,7 @@ func (a typedIntNode) Eq(b TypedAstNode) bool {
 }
 
 type typedFloatNode struct {
+	origin
 	tok *ast.Token
 }
 
@@ -363,7 +591,7 @@ func (n typedFloatNode) pos() ast.Pos {
 
 func (a typedFloatNode) Eq(b TypedAstNode) bool {
 	switch b.(type) {
-	case typedAnyNode, typedFloatNode:
+	case typedAnyNode, typedTypeVar, typedNeverNode, typedFloatNode:
 		return true
 	case typedAliasNode:
 		return b.Eq(a)
@@ -373,6 +601,7 @@ func (a typedFloatNode) Eq(b TypedAstNode) bool {
 }
 
 type typedBoolNode struct {
+	origin
 	tok *ast.Token
 }
 
@@ -389,7 +618,7 @@ func (n typedBoolNode) pos() ast.Pos {
 
 func (a typedBoolNode) Eq(b TypedAstNode) bool {
 	switch b.(type) {
-	case typedAnyNode, typedBoolNode:
+	case typedAnyNode, typedTypeVar, typedNeverNode, typedBoolNode:
 		return true
 	case typedAliasNode:
 		return b.Eq(a)
@@ -399,6 +628,7 @@ func (a typedBoolNode) Eq(b TypedAstNode) bool {
 }
 
 type typedStringNode struct {
+	origin
 	tok *ast.Token
 }
 
@@ -415,7 +645,7 @@ func (s typedStringNode) pos() ast.Pos {
 
 func (a typedStringNode) Eq(b TypedAstNode) bool {
 	switch b := b.(type) {
-	case typedAnyNode, typedStringNode:
+	case typedAnyNode, typedTypeVar, typedNeverNode, typedStringNode:
 		return true
 	case typedAliasNode:
 		return b.Eq(a)
@@ -424,12 +654,44 @@ func (a typedStringNode) Eq(b TypedAstNode) bool {
 	}
 }
 
-type typedListNode struct {
+type typedCharNode struct {
+	origin
 	tok *ast.Token
 }
 
+func (n typedCharNode) String() string {
+	return "Char"
+}
+
+func (n typedCharNode) pos() ast.Pos {
+	if n.tok != nil {
+		return n.tok.Pos
+	}
+	return ast.Pos{}
+}
+
+func (a typedCharNode) Eq(b TypedAstNode) bool {
+	switch b.(type) {
+	case typedAnyNode, typedTypeVar, typedNeverNode, typedCharNode:
+		return true
+	case typedAliasNode:
+		return b.Eq(a)
+	default:
+		return false
+	}
+}
+
+type typedListNode struct {
+	origin
+	tok  *ast.Token
+	elem TypedAstNode // nil when the type of the elements is unknown
+}
+
 func (s typedListNode) String() string {
-	return "List"
+	if s.elem == nil {
+		return "List"
+	}
+	return fmt.Sprintf("List(%s)", s.elem)
 }
 
 func (s typedListNode) pos() ast.Pos {
@@ -440,9 +702,52 @@ func (s typedListNode) pos() ast.Pos {
 }
 
 func (a typedListNode) Eq(b TypedAstNode) bool {
-	switch b.(type) {
-	case typedAnyNode, typedListNode:
+	switch b := b.(type) {
+	case typedAnyNode, typedTypeVar, typedNeverNode:
+		return true
+	case typedListNode:
+		if a.elem == nil || b.elem == nil {
+			return true
+		}
+		return a.elem.Eq(b.elem)
+	case typedAliasNode:
+		return b.Eq(a)
+	default:
+		return false
+	}
+}
+
+type typedMapNode struct {
+	origin
+	tok *ast.Token
+	// nil when the types of the keys and values are unknown
+	key   TypedAstNode
+	value TypedAstNode
+}
+
+func (s typedMapNode) String() string {
+	if s.key == nil || s.value == nil {
+		return "Map"
+	}
+	return fmt.Sprintf("Map(%s, %s)", s.key, s.value)
+}
+
+func (s typedMapNode) pos() ast.Pos {
+	if s.tok != nil {
+		return s.tok.Pos
+	}
+	return ast.Pos{}
+}
+
+func (a typedMapNode) Eq(b TypedAstNode) bool {
+	switch b := b.(type) {
+	case typedAnyNode, typedTypeVar, typedNeverNode:
 		return true
+	case typedMapNode:
+		if a.key == nil || a.value == nil || b.key == nil || b.value == nil {
+			return true
+		}
+		return a.key.Eq(b.key) && a.value.Eq(b.value)
 	case typedAliasNode:
 		return b.Eq(a)
 	default:
@@ -451,6 +756,7 @@ func (a typedListNode) Eq(b TypedAstNode) bool {
 }
 
 type typedFnNode struct {
+	origin
 	tok  *ast.Token
 	args typedArgs
 	body TypedAstNode
@@ -469,7 +775,7 @@ func (n typedFnNode) pos() ast.Pos {
 
 func (a typedFnNode) Eq(b TypedAstNode) bool {
 	switch b.(type) {
-	case typedAnyNode, typedFnNode:
+	case typedAnyNode, typedTypeVar, typedNeverNode, typedFnNode:
 		return true
 	case typedAliasNode:
 		return b.Eq(a)
@@ -479,6 +785,7 @@ func (a typedFnNode) Eq(b TypedAstNode) bool {
 }
 
 type typedFnNodes struct {
+	origin
 	values []typedFnNode
 }
 
@@ -500,6 +807,35 @@ func (a typedFnNodes) Eq(b TypedAstNode) bool {
 	panic("typedFnnode.Eq() should never be used")
 }
 
+type typedModuleNode struct {
+	origin
+	name  string
+	scope *typecheckScope
+	tok   *ast.Token
+}
+
+func (n typedModuleNode) String() string {
+	return fmt.Sprintf("Module(%s)", n.name)
+}
+
+func (n typedModuleNode) pos() ast.Pos {
+	if n.tok != nil {
+		return n.tok.Pos
+	}
+	return ast.Pos{}
+}
+
+func (a typedModuleNode) Eq(b TypedAstNode) bool {
+	switch b := b.(type) {
+	case typedAnyNode:
+		return true
+	case typedModuleNode:
+		return a.name == b.name
+	default:
+		return false
+	}
+}
+
 func isOneOfType(a TypedAstNode, bs ...TypedAstNode) bool {
 	t := reflect.TypeOf(a)
 	for _, v := range bs {
@@ -510,7 +846,239 @@ func isOneOfType(a TypedAstNode, bs ...TypedAstNode) bool {
 	return false
 }
 
-func (c *TypecheckContext) toMaybeTypedArgs(args []ast.Arg, sc typecheckScope) []TypedAstNode {
+// Type variables are single lowercase letters, like a in List(a).
+// Mirrors isTypeVariable in eval.
+func isTypeVariable(name string) bool {
+	r, size := utf8.DecodeRuneInString(name)
+	return size == len(name) && unicode.IsLower(r)
+}
+
+// Looks up the alias of a parameter, and gives it its type parameters
+func (c *TypecheckContext) resolveType(t ast.Type, sc typecheckScope, pos ast.Pos) (TypedAstNode, error) {
+	if isTypeVariable(t.Name) {
+		if len(t.Params) > 0 {
+			return nil, &typecheckError{
+				reason: fmt.Sprintf("Type variable %s cannot take type parameters", t.Name),
+				Pos:    pos,
+			}
+		}
+		return typedTypeVar{name: t.Name}, nil
+	}
+	alias, err := sc.get(t.Name, pos)
+	if err != nil {
+		if r, _ := utf8.DecodeRuneInString(t.Name); unicode.IsLower(r) {
+			return nil, &typecheckError{
+				reason: fmt.Sprintf("Unknown type %s. Type variables are single lowercase letters, like a in List(a)", t.Name),
+				Pos:    pos,
+			}
+		}
+		return nil, err
+	}
+	if len(t.Params) == 0 {
+		// The type parameters of an alias used without them can be anything
+		if a, ok := alias.(typedAliasNode); ok && len(a.params) > 0 && len(a.args) == 0 {
+			return substitute(a, nil, true), nil
+		}
+		return alias, nil
+	}
+	params := make([]TypedAstNode, len(t.Params))
+	for i, param := range t.Params {
+		params[i], err = c.resolveType(param, sc, pos)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return instantiate(alias, params, pos)
+}
+
+// How many type parameters the alias takes. The builtin List and Map take 1 and 2.
+func typeParamCount(a typedAliasNode) int {
+	if len(a.params) == 0 && len(a.targets) == 1 {
+		switch a.targets[0].(type) {
+		case typedListNode:
+			return 1
+		case typedMapNode:
+			return 2
+		}
+	}
+	return len(a.params)
+}
+
+// Gives an alias its type parameters: Maybe(Int) or List(Str)
+func instantiate(alias TypedAstNode, params []TypedAstNode, pos ast.Pos) (TypedAstNode, error) {
+	a, ok := alias.(typedAliasNode)
+	if !ok {
+		return nil, &typecheckError{
+			reason: fmt.Sprintf("%s is not a type, and cannot be given type parameters", alias),
+			Pos:    pos,
+		}
+	}
+	expected := typeParamCount(a)
+	if expected == 0 {
+		return nil, &typecheckError{
+			reason: fmt.Sprintf("%s does not take type parameters", a),
+			Pos:    pos,
+		}
+	}
+	if len(params) != expected {
+		return nil, &typecheckError{
+			reason: fmt.Sprintf("%s takes %d type parameter(s), got %d", a, expected, len(params)),
+			Pos:    pos,
+		}
+	}
+
+	if len(a.params) == 0 {
+		switch a.targets[0].(type) {
+		case typedListNode:
+			return typedListNode{elem: params[0]}, nil
+		case typedMapNode:
+			return typedMapNode{key: params[0], value: params[1]}, nil
+		}
+	}
+	bindings := map[string]TypedAstNode{}
+	for i, name := range a.params {
+		bindings[name] = params[i]
+	}
+	targets := make([]TypedAstNode, len(a.targets))
+	for i, target := range a.targets {
+		targets[i] = substitute(target, bindings, false)
+	}
+	return typedAliasNode{
+		name:    a.name,
+		targets: targets,
+		args:    params,
+	}, nil
+}
+
+// Replaces the type variables in typed with the types they are bound to.
+// If erase is set, unbound type variables are replaced with Any.
+func substitute(typed TypedAstNode, bindings map[string]TypedAstNode, erase bool) TypedAstNode {
+	substituteAll := func(types []TypedAstNode) []TypedAstNode {
+		if types == nil {
+			return nil
+		}
+		substituted := make([]TypedAstNode, len(types))
+		for i, t := range types {
+			substituted[i] = substitute(t, bindings, erase)
+		}
+		return substituted
+	}
+	switch n := typed.(type) {
+	case typedTypeVar:
+		if bound, ok := bindings[n.name]; ok {
+			return bound
+		}
+		if erase {
+			return typedAnyNode{}
+		}
+		return n
+	case typedListNode:
+		if n.elem != nil {
+			n.elem = substitute(n.elem, bindings, erase)
+			if _, isAny := n.elem.(typedAnyNode); isAny {
+				n.elem = nil
+			}
+		}
+		return n
+	case typedMapNode:
+		if n.key != nil && n.value != nil {
+			n.key = substitute(n.key, bindings, erase)
+			n.value = substitute(n.value, bindings, erase)
+			_, anyKey := n.key.(typedAnyNode)
+			_, anyValue := n.value.(typedAnyNode)
+			if anyKey && anyValue {
+				n.key, n.value = nil, nil
+			}
+		}
+		return n
+	case typedEnumNode:
+		n.args = substituteAll(n.args)
+		return n
+	case typedAliasNode:
+		n.targets = substituteAll(n.targets)
+		n.args = substituteAll(n.args)
+		// Result(Any, Any) is just Result
+		if erase && len(util.Filter(n.args, isAny)) == len(n.args) {
+			n.args = nil
+		}
+		return n
+	case typedArg:
+		if n.alias != nil {
+			n.alias = substitute(n.alias, bindings, erase)
+		}
+		return n
+	default:
+		return typed
+	}
+}
+
+// Binds the type variables in param to the types in provided, at any depth.
+// A type variable that is bound to different types is bound to both.
+func unify(param TypedAstNode, provided TypedAstNode, bindings map[string]TypedAstNode) {
+	if param == nil || provided == nil {
+		return
+	}
+	switch p := param.(type) {
+	case typedTypeVar:
+		switch provided.(type) {
+		case typedAnyNode, typedNeverNode:
+			return
+		}
+		bound, ok := bindings[p.name]
+		if !ok {
+			bindings[p.name] = provided
+		} else if !bound.Eq(provided) {
+			bindings[p.name] = getTypeFromMatchBodies([]TypedAstNode{bound, provided})
+		}
+		return
+	case typedArg:
+		unify(p.alias, provided, bindings)
+		return
+	}
+
+	if alias, ok := provided.(typedAliasNode); ok {
+		if p, ok := param.(typedAliasNode); ok && p.name != "" && p.name == alias.name &&
+			len(p.args) == len(alias.args) {
+			for i := range p.args {
+				unify(p.args[i], alias.args[i], bindings)
+			}
+			return
+		}
+		// A union, like the type of a match: unify with each of its types
+		for _, target := range alias.targets {
+			if param.Eq(target) {
+				unify(param, target, bindings)
+			}
+		}
+		return
+	}
+
+	switch p := param.(type) {
+	case typedListNode:
+		if l, ok := provided.(typedListNode); ok && p.elem != nil {
+			unify(p.elem, l.elem, bindings)
+		}
+	case typedMapNode:
+		if m, ok := provided.(typedMapNode); ok && p.key != nil && p.value != nil {
+			unify(p.key, m.key, bindings)
+			unify(p.value, m.value, bindings)
+		}
+	case typedEnumNode:
+		if e, ok := provided.(typedEnumNode); ok && len(p.args) == len(e.args) {
+			for i := range p.args {
+				unify(p.args[i], e.args[i], bindings)
+			}
+		}
+	case typedAliasNode:
+		for _, target := range p.targets {
+			if target.Eq(provided) {
+				unify(target, provided, bindings)
+			}
+		}
+	}
+}
+
+func (c *TypecheckContext) toMaybeTypedArgs(args []ast.Arg, sc typecheckScope, pos ast.Pos) []TypedAstNode {
 	typed := make(typedArgs, 0)
 	for _, arg := range args {
 		if arg.Alias == "" {
@@ -519,7 +1087,7 @@ func (c *TypecheckContext) toMaybeTypedArgs(args []ast.Arg, sc typecheckScope) [
 			})
 			continue
 		}
-		a, err := sc.get(arg.Alias, ast.Pos{})
+		a, err := c.resolveType(arg.Type(), sc, pos)
 		if err != nil {
 			c.errors = append(c.errors, err)
 			a = typedAnyNode{}
@@ -532,9 +1100,14 @@ func (c *TypecheckContext) toMaybeTypedArgs(args []ast.Arg, sc typecheckScope) [
 	return typed
 }
 
+func isAny(typed TypedAstNode) bool {
+	_, ok := typed.(typedAnyNode)
+	return ok
+}
+
 func isNum(typed TypedAstNode) bool {
 	switch n := typed.(type) {
-	case typedIntNode, typedFloatNode, typedAnyNode:
+	case typedIntNode, typedFloatNode, typedAnyNode, typedTypeVar, typedNeverNode:
 		return true
 	case typedAliasNode:
 		return n.Eq(typedIntNode{}) || n.Eq(typedFloatNode{})
@@ -597,6 +1170,10 @@ func getNumTypeFromBinOp(left TypedAstNode, right TypedAstNode) TypedAstNode {
 			if isAliasWithName(right, "Int") || isAliasWithName(right, "Float") {
 				return floatAlias
 			}
+			switch right.(type) {
+			case typedIntNode, typedFloatNode:
+				return floatAlias
+			}
 		}
 	case typedFloatNode:
 		if isAliasWithName(right, "Int") || isAliasWithName(right, "Float") {
@@ -614,32 +1191,60 @@ func getNumTypeFromBinOp(left TypedAstNode, right TypedAstNode) TypedAstNode {
 	return numAlias
 }
 
+// Branches that never return, like a panic, are left out
 func getTypeFromMatchBodies(types []TypedAstNode) TypedAstNode {
 	typedNodes := []TypedAstNode{}
+	seen := map[string]bool{}
 	for _, t := range types {
-		_, isAny := t.(typedAnyNode)
-		if isAny {
+		switch t.(type) {
+		case typedAnyNode:
 			return typedAnyNode{}
+		case typedNeverNode:
+			continue
 		}
-		if !isOneOfType(t, typedNodes...) {
+		if !seen[t.String()] {
+			seen[t.String()] = true
 			typedNodes = append(typedNodes, t)
 		}
 	}
+	switch len(typedNodes) {
+	case 0:
+		return typedNeverNode{}
+	case 1:
+		return typedNodes[0]
+	}
 	return typedAliasNode{
 		targets: typedNodes,
 	}
 }
 
+// The type of the elements of a list, or nil if they can be anything
+func getElemType(types []TypedAstNode) TypedAstNode {
+	if len(types) == 0 {
+		return nil
+	}
+	elem := getTypeFromMatchBodies(types)
+	if _, isAny := elem.(typedAnyNode); isAny {
+		return nil
+	}
+	return elem
+}
+
 // Given a list of all List, return List
 // otherwise return Str
 func getIteratorType(typed ...TypedAstNode) TypedAstNode {
+	elems := []TypedAstNode{}
 	for _, t := range typed {
-		_, ok := t.(typedListNode)
+		l, ok := t.(typedListNode)
 		if !ok {
 			return typedStringNode{}
 		}
+		if l.elem == nil {
+			return typedListNode{}
+		}
+		elems = append(elems, l.elem)
 	}
-	return typedListNode{}
+	return typedListNode{elem: getElemType(elems)}
 }
 
 func isString(ast TypedAstNode) bool {
@@ -650,6 +1255,16 @@ func isString(ast TypedAstNode) bool {
 	return false
 }
 
+func isChar(a TypedAstNode) bool {
+	switch n := a.(type) {
+	case typedCharNode:
+		return true
+	case typedAliasNode:
+		return n.Eq(typedCharNode{})
+	}
+	return false
+}
+
 func isList(a TypedAstNode) bool {
 	switch a.(type) {
 	case typedListNode:
@@ -659,16 +1274,18 @@ func isList(a TypedAstNode) bool {
 }
 
 func isBool(a TypedAstNode) bool {
-	switch a.(type) {
-	case typedBoolNode:
+	switch n := a.(type) {
+	case typedBoolNode, typedAnyNode, typedTypeVar, typedNeverNode:
 		return true
+	case typedAliasNode:
+		return n.Eq(typedBoolNode{})
 	}
 	return false
 }
 
 func isIterator(ast TypedAstNode) bool {
 	switch n := ast.(type) {
-	case typedListNode, typedStringNode, typedAnyNode:
+	case typedListNode, typedStringNode, typedAnyNode, typedTypeVar, typedNeverNode:
 		return true
 	case typedAliasNode:
 		return n.Eq(typedStringNode{}) || n.Eq(typedListNode{})
@@ -686,13 +1303,46 @@ func anyUnknowns(l ...TypedAstNode) bool {
 	return false
 }
 
+// A call on a module, mod.fn(a), looks up fn in the module instead of the current scope.
+// Returns the function called, and the call node without the module as an argument.
+func (c *TypecheckContext) typecheckCallee(callNode ast.FnCallNode, sc typecheckScope) (TypedAstNode, ast.FnCallNode, error) {
+	ident, isIdentifier := callNode.Fn.(ast.IdentifierNode)
+	if !isIdentifier || !callNode.Dot || len(callNode.Args) == 0 {
+		fn, err := c.typecheckExpr(callNode.Fn, sc)
+		return fn, callNode, err
+	}
+
+	var first TypedAstNode
+	switch arg := callNode.Args[0].(type) {
+	case ast.IdentifierNode:
+		first, _ = sc.get(arg.Payload, arg.Pos())
+	case ast.ImportNode:
+		first, _ = c.typecheckExpr(arg, sc)
+	}
+	module, isModule := first.(typedModuleNode)
+	if !isModule {
+		fn, err := c.typecheckExpr(callNode.Fn, sc)
+		return fn, callNode, err
+	}
+
+	callNode.Args = callNode.Args[1:]
+	fn, ok := module.scope.vars[ident.Payload]
+	if !ok {
+		return nil, callNode, &typecheckError{
+			reason: fmt.Sprintf("%s is not defined in module %s", ident.Payload, module.name),
+			Pos:    callNode.Pos(),
+		}
+	}
+	return fn, callNode, nil
+}
+
 func (c *TypecheckContext) typecheckFnCallNode(callNode ast.FnCallNode, sc typecheckScope) (TypedAstNode, error) {
-	fn, err := c.typecheckExpr(callNode.Fn, sc)
+	fn, callNode, err := c.typecheckCallee(callNode, sc)
 	if err != nil {
 		i, isIdentifier := callNode.Fn.(ast.IdentifierNode)
 		if isIdentifier {
 			if sc.isRecursion(i.Payload) {
-				return typedAnyNode{}, nil
+				return sc.recursionReturn(i.Payload), nil
 			}
 		}
 		return nil, err
@@ -757,11 +1407,37 @@ func (c *TypecheckContext) typecheckFnCallNode(callNode ast.FnCallNode, sc typec
 			return typedAnyNode{}, nil
 		}
 
-		if len(fullMatch) > 1 {
-			// TODO: maybe create a warning here that we are matching more than one?
+		returned := returnOf(fullMatch[0], argsProvided)
+		for _, arg := range argsProvided {
+			if !isAny(arg) {
+				continue
+			}
+			// Which of the matching functions is called is only known when running,
+			// so the type is only known if they all return the same
+			for _, fn := range fullMatch[1:] {
+				if returnOf(fn, argsProvided).String() != returned.String() {
+					return typedAnyNode{}, nil
+				}
+			}
+			break
 		}
-		return fullMatch[0].body, nil
-	case typedAnyNode, typedAliasNode, typedAnyFnNode:
+		return returned, nil
+	case typedAliasNode:
+		// Calling a parameter of type Fn
+		if typeParamCount(n) == 0 {
+			return typedAnyNode{}, nil
+		}
+		// A type given its type parameters, like List(Int) in an alias
+		params := make([]TypedAstNode, 0)
+		for _, v := range callNode.Args {
+			param, err := c.typecheckExpr(v, sc)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, param)
+		}
+		return instantiate(n, params, callNode.Pos())
+	case typedAnyNode, typedAnyFnNode:
 		// ^ Some of these might need some improvement
 		return typedAnyNode{}, nil
 	default:
@@ -773,42 +1449,188 @@ func (c *TypecheckContext) typecheckFnCallNode(callNode ast.FnCallNode, sc typec
 	}
 }
 
-// Returns typeAstNode of branch.Body
-func (c *TypecheckContext) typecheckMatchBranch(branch ast.MatchBranch, sc typecheckScope) (TypedAstNode, error) {
-	bodyScope := typecheckScope{
-		parent: &sc,
-		vars:   map[string]TypedAstNode{},
+// What fn returns when called with args
+func returnOf(fn typedFnNode, args []TypedAstNode) TypedAstNode {
+	// The type parameters of the function are given by the arguments
+	bindings := map[string]TypedAstNode{}
+	for j, arg := range fn.args {
+		unify(arg, args[j], bindings)
+	}
+	return substitute(fn.body, bindings, true)
+}
+
+// The types of the arguments of the enum matched by the pattern, when typed is, or might be, that enum
+func getEnumArgTypes(typed TypedAstNode, pattern ast.EnumNode) []TypedAstNode {
+	switch t := typed.(type) {
+	case typedEnumNode:
+		if t.parent == pattern.Parent && t.name == pattern.Name && len(t.args) == len(pattern.Args) {
+			return t.args
+		}
+	case typedAliasNode:
+		for _, target := range t.targets {
+			if args := getEnumArgTypes(target, pattern); args != nil {
+				return args
+			}
+		}
 	}
-	// Target might be an EnumNode, which needs to be handled
-	// differently when its in a match target as it might put variables into scope
-	switch t := branch.Target.(type) {
+	return nil
+}
+
+// Puts the identifiers bound by a match pattern into bodyScope, at any depth.
+// typed is the type of the value matched, which gives the types of the identifiers.
+// Mirrors matchPattern in eval.
+func (c *TypecheckContext) typecheckPattern(node ast.AstNode, bodyScope typecheckScope, typed TypedAstNode) error {
+	switch n := node.(type) {
 	case ast.IdentifierNode:
-		bodyScope.put(t.Payload, typedAnyNode{}, t.Pos())
+		if typed == nil {
+			typed = typedAnyNode{}
+		}
+		return bodyScope.put(n.Payload, typed, n.Pos())
+	case ast.UnderscoreNode:
+		return nil
+	case ast.EnumNode:
+		argTypes := getEnumArgTypes(typed, n)
+		for i, arg := range n.Args {
+			var argType TypedAstNode
+			if argTypes != nil {
+				argType = argTypes[i]
+			}
+			if err := c.typecheckPattern(arg, bodyScope, argType); err != nil {
+				return err
+			}
+		}
+		return nil
 	case ast.ListNode:
-		for _, v := range t.Elems {
-			identifier, isIdentifier := v.(ast.IdentifierNode)
-			if isIdentifier {
-				err := bodyScope.put(identifier.Payload, typedAnyNode{}, identifier.Pos())
-				if err != nil {
-					return nil, err
+		var elemType TypedAstNode
+		if l, ok := typed.(typedListNode); ok {
+			elemType = l.elem
+		}
+		for i, elem := range n.Elems {
+			rest, isRest := elem.(ast.RestNode)
+			if !isRest {
+				if err := c.typecheckPattern(elem, bodyScope, elemType); err != nil {
+					return err
 				}
+				continue
 			}
-		}
-	case ast.EnumNode:
-		for _, v := range t.Args {
-			identifier, isIdentifier := v.(ast.IdentifierNode)
-			if isIdentifier {
-				err := bodyScope.put(identifier.Payload, typedAnyNode{}, identifier.Pos())
-				if err != nil {
-					return nil, err
+			if i != len(n.Elems)-1 {
+				return &typecheckError{
+					reason: fmt.Sprintf("%s can only be used as the last element of a list pattern", rest),
+					Pos:    rest.Pos(),
+				}
+			}
+			if rest.Name != "" {
+				if err := bodyScope.put(rest.Name, typedListNode{tok: rest.Tok, elem: elemType}, rest.Pos()); err != nil {
+					return err
 				}
 			}
 		}
+		return nil
 	default:
-		_, err := c.typecheckExpr(branch.Target, bodyScope)
+		_, err := c.typecheckExpr(node, bodyScope)
+		return err
+	}
+}
+
+// A branch that matches everything: an identifier or _ without a guard
+func isCatchAll(branch ast.MatchBranch) bool {
+	if branch.Guard != nil {
+		return false
+	}
+	switch branch.Target.(type) {
+	case ast.IdentifierNode, ast.UnderscoreNode:
+		return true
+	}
+	return false
+}
+
+// Whether the branch matches every value of the given enum
+func coversEnum(branch ast.MatchBranch, enum typedEnumNode) bool {
+	if branch.Guard != nil {
+		return false
+	}
+	target, ok := branch.Target.(ast.EnumNode)
+	if !ok || target.Parent != enum.parent || target.Name != enum.name {
+		return false
+	}
+	for _, arg := range target.Args {
+		switch arg.(type) {
+		case ast.IdentifierNode, ast.UnderscoreNode:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// Adds warnings for branches after a catch-all, and, when the condition is of an alias
+// of enums (like Result), for enums of the alias that are not matched by any branch.
+func (c *TypecheckContext) checkExhaustive(n ast.MatchNode, cond TypedAstNode) {
+	for i, branch := range n.Branches {
+		if !isCatchAll(branch) {
+			continue
+		}
+		for _, unreachable := range n.Branches[i+1:] {
+			c.warnings = append(c.warnings, &typecheckWarning{
+				reason: fmt.Sprintf("Unreachable branch %s, as %s matches everything",
+					color.Str(color.Yellow, unreachable.Target.String()), branch.Target),
+				Pos: unreachable.Target.Pos(),
+			})
+		}
+		return
+	}
+
+	alias, ok := cond.(typedAliasNode)
+	if !ok || len(alias.targets) == 0 {
+		return
+	}
+	missing := []string{}
+	for _, target := range alias.targets {
+		enum, ok := target.(typedEnumNode)
+		if !ok {
+			return
+		}
+		covered := false
+		for _, branch := range n.Branches {
+			if coversEnum(branch, enum) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			missing = append(missing, enum.String())
+		}
+	}
+	if len(missing) > 0 {
+		c.warnings = append(c.warnings, &typecheckWarning{
+			reason: fmt.Sprintf("Match on %s is not exhaustive. Missing: %s",
+				alias, color.Str(color.Yellow, strings.Join(missing, ", "))),
+			Pos: n.Pos(),
+		})
+	}
+}
+
+// Returns typeAstNode of branch.Body
+func (c *TypecheckContext) typecheckMatchBranch(branch ast.MatchBranch, sc typecheckScope, cond TypedAstNode) (TypedAstNode, error) {
+	bodyScope := typecheckScope{
+		parent: &sc,
+		vars:   map[string]TypedAstNode{},
+	}
+	if err := c.typecheckPattern(branch.Target, bodyScope, cond); err != nil {
+		return nil, err
+	}
+	if branch.Guard != nil {
+		guard, err := c.typecheckExpr(branch.Guard, bodyScope)
 		if err != nil {
 			return nil, err
 		}
+		if !guard.Eq(typedBoolNode{}) {
+			c.errors = append(c.errors, &typecheckError{
+				reason: fmt.Sprintf("Match guard must be a bool. %s was used",
+					color.Str(color.Yellow, guard.String())),
+				Pos: branch.Guard.Pos(),
+			})
+		}
 	}
 	return c.typecheckExpr(branch.Body, bodyScope)
 }
@@ -823,6 +1645,12 @@ func (c *TypecheckContext) typecheckBinaryNode(n ast.BinaryNode, sc typecheckSco
 		return nil, err
 	}
 
+	// A comparison gives a bool whatever it compares, or fails when it runs
+	switch n.Op {
+	case ast.Eq, ast.Neq, ast.Greater, ast.Less, ast.Geq, ast.Leq:
+		return typedBoolNode{tok: n.Tok}, nil
+	}
+
 	// NOTE: this is just to make sure we dont acidentally say something is wrong when it isnt.
 	// Should be removed eventually.
 	if anyUnknowns(leftComputed, rightComputed) {
@@ -833,13 +1661,14 @@ func (c *TypecheckContext) typecheckBinaryNode(n ast.BinaryNode, sc typecheckSco
 	case ast.And, ast.Or:
 		if !isBool(leftComputed) || !isBool(rightComputed) {
 			c.errors = append(c.errors, &typecheckError{
-				reason: fmt.Sprintf("%s operator only works with bool. %s and %s was used", n, leftComputed, rightComputed),
-				Pos:    n.Pos(),
+				reason: fmt.Sprintf("%s operator only works with bool. %s and %s was used",
+					n.Tok, color.Str(color.Yellow, leftComputed.String()), color.Str(color.Yellow, rightComputed.String())),
+				Pos: n.Pos(),
 			})
 			return typedAnyNode{}, nil
 		}
 		return typedBoolNode{tok: n.Tok}, nil
-	case ast.Plus, ast.Divide, ast.Modulus, ast.Times:
+	case ast.Plus, ast.Minus, ast.Divide, ast.Modulus, ast.Times:
 		if !isNum(leftComputed) || !isNum(rightComputed) {
 			c.errors = append(c.errors, &typecheckError{
 				reason: fmt.Sprintf("%s operator only works with ints and floats. %s and %s was used",
@@ -851,6 +1680,9 @@ func (c *TypecheckContext) typecheckBinaryNode(n ast.BinaryNode, sc typecheckSco
 		}
 		return getNumTypeFromBinOp(leftComputed, rightComputed), nil
 	case ast.PlusOther:
+		if leftComputed.Eq(typedStringNode{}) && isChar(rightComputed) {
+			return typedStringNode{tok: n.Tok}, nil
+		}
 		if !isIterator(leftComputed) || !isIterator(rightComputed) {
 			c.errors = append(c.errors, &typecheckError{
 				reason: fmt.Sprintf("++ operator only works with iterators (list and string). %s and %s was used",
@@ -865,10 +1697,113 @@ func (c *TypecheckContext) typecheckBinaryNode(n ast.BinaryNode, sc typecheckSco
 	}
 }
 
+func (c *TypecheckContext) typecheckUnaryNode(n ast.UnaryNode, sc typecheckScope) (TypedAstNode, error) {
+	operand, err := c.typecheckExpr(n.Operand, sc)
+	if err != nil {
+		return nil, err
+	}
+
+	switch n.Op {
+	case ast.Not:
+		if !isBool(operand) {
+			c.errors = append(c.errors, &typecheckError{
+				reason: fmt.Sprintf("! operator only works with bool. %s was used", color.Str(color.Yellow, operand.String())),
+				Pos:    n.Pos(),
+			})
+			return typedAnyNode{}, nil
+		}
+		return typedBoolNode{tok: n.Tok}, nil
+	case ast.Minus:
+		if !isNum(operand) {
+			c.errors = append(c.errors, &typecheckError{
+				reason: fmt.Sprintf("- operator only works with ints and floats. %s was used", color.Str(color.Yellow, operand.String())),
+				Pos:    n.Pos(),
+			})
+			return typedAnyNode{}, nil
+		}
+		return operand, nil
+	default:
+		return typedAnyNode{}, nil
+	}
+}
+
+func (c *TypecheckContext) typecheckImportNode(n ast.ImportNode, sc typecheckScope) (TypedAstNode, error) {
+	mod, err := lib.ResolveModule(n.Name, n.Path, n.Pos().FileName())
+	if err != nil {
+		return nil, &typecheckError{
+			reason: err.Error(),
+			Pos:    n.Pos(),
+		}
+	}
+
+	module, cached := c.modules[mod.Key]
+	if !cached {
+		for i, importing := range c.importing {
+			if importing.Key == mod.Key {
+				chain := []string{}
+				for _, m := range c.importing[i:] {
+					chain = append(chain, m.FileName)
+				}
+				chain = append(chain, mod.FileName)
+				return nil, &typecheckError{
+					reason: fmt.Sprintf("Cyclic import: %s", strings.Join(chain, " -> ")),
+					Pos:    n.Pos(),
+				}
+			}
+		}
+
+		c.importing = append(c.importing, mod)
+		defer func() {
+			c.importing = c.importing[:len(c.importing)-1]
+		}()
+
+		tokenizer := ast.NewTokenizer(mod.Source, mod.FileName)
+		parser := ast.NewParser(tokenizer.Tokenize())
+		nodes, err := parser.Parse()
+		if err != nil {
+			return nil, err
+		}
+
+		module = typedModuleNode{
+			name: mod.FileName,
+			scope: &typecheckScope{
+				parent: &c.prelude,
+				vars:   map[string]TypedAstNode{},
+			},
+			tok: n.Tok,
+		}
+		for _, node := range nodes {
+			if _, err := c.typecheckExpr(node, *module.scope); err != nil {
+				c.errors = append(c.errors, err)
+			}
+		}
+		c.modules[mod.Key] = module
+	}
+
+	if n.Name != "" {
+		if err := sc.put(n.Name, module, n.Pos()); err != nil {
+			return nil, err
+		}
+	}
+	return module, nil
+}
+
 // typecheckExpr is the only function that does not 'insert' typecheckError into TypecheckContext.
 // This means that we can insert typeccheckError at the boundaries like `typecheckNodes` which is at the "beginnig" for parsing
 // a root node, and like typecheckBinaryNode which is at "the end".
+//
+// The returned typed node carries the given node as its origin.
 func (c *TypecheckContext) typecheckExpr(node ast.AstNode, sc typecheckScope) (TypedAstNode, error) {
+	typed, err := c.typecheckNode(node, sc)
+	if err != nil || typed == nil {
+		return typed, err
+	}
+	typed = withNode(typed, node)
+	c.kinds.record(node, typed)
+	return typed, nil
+}
+
+func (c *TypecheckContext) typecheckNode(node ast.AstNode, sc typecheckScope) (TypedAstNode, error) {
 	switch n := node.(type) {
 	case ast.UnderscoreNode:
 		return typedAnyNode{}, nil
@@ -890,8 +1825,14 @@ func (c *TypecheckContext) typecheckExpr(node ast.AstNode, sc typecheckScope) (T
 		}, nil
 	case ast.BinaryNode:
 		return c.typecheckBinaryNode(n, sc)
+	case ast.UnaryNode:
+		return c.typecheckUnaryNode(n, sc)
 	case ast.IdentifierNode:
-		return sc.get(n.Payload, n.Pos())
+		typed, err := sc.get(n.Payload, n.Pos())
+		if err == nil {
+			c.references = append(c.references, Reference{Node: n, Typed: typed})
+		}
+		return typed, err
 	case ast.AssignmentNode:
 		_, isFn := n.Right.(ast.FnNode)
 		if isFn {
@@ -907,6 +1848,9 @@ func (c *TypecheckContext) typecheckExpr(node ast.AstNode, sc typecheckScope) (T
 		switch left := n.Left.(type) {
 		case ast.IdentifierNode:
 			err := sc.put(left.Payload, assignedNode, n.Pos())
+			if err == nil {
+				c.references = append(c.references, Reference{Node: left, Typed: sc.vars[left.Payload]})
+			}
 			return assignedNode, err
 		default:
 			return nil, &typecheckError{
@@ -933,6 +1877,11 @@ func (c *TypecheckContext) typecheckExpr(node ast.AstNode, sc typecheckScope) (T
 			parent: &sc,
 			vars:   map[string]TypedAstNode{},
 		}
+		for _, param := range n.Params {
+			if err := aliasScope.put(param, typedTypeVar{name: param}, n.Pos()); err != nil {
+				return nil, err
+			}
+		}
 		targets := make([]TypedAstNode, len(n.Targets))
 		for i, expr := range n.Targets {
 			typed, err := c.typecheckExpr(expr, aliasScope)
@@ -944,6 +1893,7 @@ func (c *TypecheckContext) typecheckExpr(node ast.AstNode, sc typecheckScope) (T
 		typedAlias := typedAliasNode{
 			name:    n.Name,
 			targets: targets,
+			params:  n.Params,
 		}
 		err := sc.put(n.Name, typedAlias, n.Pos())
 		if err != nil {
@@ -951,11 +1901,20 @@ func (c *TypecheckContext) typecheckExpr(node ast.AstNode, sc typecheckScope) (T
 		}
 		return typedAlias, nil
 	case ast.FnNode:
+		args := c.toMaybeTypedArgs(n.Args, sc, n.Pos())
+		var ret TypedAstNode
+		if n.Return != nil {
+			var err error
+			ret, err = c.resolveType(*n.Return, sc, n.Pos())
+			if err != nil {
+				return nil, err
+			}
+		}
+		sc.currentFnReturn = ret
 		fnScope := typecheckScope{
 			parent: &sc,
 			vars:   map[string]TypedAstNode{},
 		}
-		args := c.toMaybeTypedArgs(n.Args, sc)
 		for _, a := range args {
 			switch arg := a.(type) {
 			case typedArg:
@@ -979,6 +1938,20 @@ func (c *TypecheckContext) typecheckExpr(node ast.AstNode, sc typecheckScope) (T
 			c.errors = append(c.errors, err)
 			body = typedAnyNode{}
 		}
+		if ret != nil {
+			if !ret.Eq(body) {
+				name := "Function"
+				if sc.currentFn != "" {
+					name = "Function " + sc.currentFn
+				}
+				c.errors = append(c.errors, &typecheckError{
+					reason: fmt.Sprintf("%s is annotated to return %s, but returns %s", name, ret, body),
+					Pos:    n.Pos(),
+				})
+			}
+			// Callers trust the annotation over what we could infer
+			body = ret
+		}
 		return typedFnNode{
 			args: args,
 			tok:  n.Tok,
@@ -986,6 +1959,63 @@ func (c *TypecheckContext) typecheckExpr(node ast.AstNode, sc typecheckScope) (T
 		}, nil
 	case ast.FnCallNode:
 		return c.typecheckFnCallNode(n, sc)
+	case ast.MemberNode:
+		typed, err := c.typecheckExpr(n.Module, sc)
+		if err != nil {
+			return nil, err
+		}
+		switch module := typed.(type) {
+		case typedModuleNode:
+			member, ok := module.scope.vars[n.Name]
+			if !ok {
+				return nil, &typecheckError{
+					reason: fmt.Sprintf("%s is not defined in module %s", n.Name, module.name),
+					Pos:    n.Pos(),
+				}
+			}
+			return member, nil
+		case typedAnyNode:
+			return typedAnyNode{}, nil
+		}
+		return nil, &typecheckError{
+			reason: fmt.Sprintf("Cannot get %s from %s, which is not a module", n.Name, n.Module),
+			Pos:    n.Pos(),
+		}
+	case ast.ListNode:
+		elems := make([]TypedAstNode, len(n.Elems))
+		for i, elem := range n.Elems {
+			typed, err := c.typecheckExpr(elem, sc)
+			if err != nil {
+				return nil, err
+			}
+			elems[i] = typed
+		}
+		return typedListNode{
+			tok:  n.Tok,
+			elem: getElemType(elems),
+		}, nil
+	case ast.MapNode:
+		keys := make([]TypedAstNode, len(n.Keys))
+		values := make([]TypedAstNode, len(n.Values))
+		for i, key := range n.Keys {
+			typed, err := c.typecheckExpr(key, sc)
+			if err != nil {
+				return nil, err
+			}
+			keys[i] = typed
+			if values[i], err = c.typecheckExpr(n.Values[i], sc); err != nil {
+				return nil, err
+			}
+		}
+		key, value := getElemType(keys), getElemType(values)
+		if key == nil || value == nil {
+			return typedMapNode{tok: n.Tok}, nil
+		}
+		return typedMapNode{
+			tok:   n.Tok,
+			key:   key,
+			value: value,
+		}, nil
 	case ast.EnumNode:
 		args := []TypedAstNode{}
 		for _, v := range n.Args {
@@ -1002,15 +2032,16 @@ func (c *TypecheckContext) typecheckExpr(node ast.AstNode, sc typecheckScope) (T
 			args:   args,
 		}, nil
 	case ast.MatchNode:
-		_, err := c.typecheckExpr(n.Cond, sc)
+		cond, err := c.typecheckExpr(n.Cond, sc)
 		if err != nil {
 			c.errors = append(c.errors, err)
 			return typedAnyFnNode{}, nil
 		}
+		c.checkExhaustive(n, cond)
 
 		bodies := make([]TypedAstNode, 0)
 		for _, branch := range n.Branches {
-			body, err := c.typecheckMatchBranch(branch, sc)
+			body, err := c.typecheckMatchBranch(branch, sc, cond)
 			if err != nil {
 				c.errors = append(c.errors, err)
 				bodies = append(bodies, typedAnyNode{})
@@ -1019,6 +2050,13 @@ func (c *TypecheckContext) typecheckExpr(node ast.AstNode, sc typecheckScope) (T
 			}
 		}
 		return getTypeFromMatchBodies(bodies), nil
+	case ast.ImportNode:
+		return c.typecheckImportNode(n, sc)
+	case ast.RestNode:
+		return nil, &typecheckError{
+			reason: fmt.Sprintf("%s can only be used in a list pattern in a match branch", n),
+			Pos:    n.Pos(),
+		}
 	default:
 		// TODO: remove default when we have handled everything
 		// This is just a pillow
@@ -1026,20 +2064,28 @@ func (c *TypecheckContext) typecheckExpr(node ast.AstNode, sc typecheckScope) (T
 	}
 }
 
-func (c *TypecheckContext) typecheckNodes(nodes []ast.AstNode) (TypedAstNode, error) {
-	var returnValue TypedAstNode = nil
+func (c *TypecheckContext) typecheckNodes(nodes []ast.AstNode) (TypedProgram, error) {
+	program := TypedProgram{}
 	for _, expr := range nodes {
 		v, err := c.typecheckExpr(expr, c.typecheckScope)
 		if err != nil {
 			c.errors = append(c.errors, err)
 		} else {
-			returnValue = v
+			program = append(program, v)
 		}
 	}
-	if len(c.errors) > 0 {
+	if len(c.errors) > 0 || (c.Strict && len(c.warnings) > 0) {
 		return nil, c.multipleErrors
 	}
-	return returnValue, nil
+	return program, nil
+}
+
+// Warnings found so far. They are also part of the returned error when the typecheck fails.
+func (c *TypecheckContext) Warnings() error {
+	if len(c.warnings) == 0 {
+		return nil
+	}
+	return multipleErrors{warnings: c.warnings}
 }
 
 func (c *TypecheckContext) LoadLibs() error {
@@ -1048,10 +2094,26 @@ func (c *TypecheckContext) LoadLibs() error {
 		return fmt.Errorf("Could not load lib/base.raja")
 	}
 	_, err := c.Typecheck(strings.NewReader(base), "base")
+	// Warnings in the base lib are not for the user
+	c.warnings = nil
+	c.prelude = c.typecheckScope.snapshot()
 	return err
 }
 
+// Typechecks the program, and returns the type of the last expression
 func (c *TypecheckContext) Typecheck(reader io.Reader, filename string) (TypedAstNode, error) {
+	program, err := c.TypecheckProgram(reader, filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(program) == 0 {
+		return nil, nil
+	}
+	return program[len(program)-1], nil
+}
+
+// Typechecks the program, and returns every top-level expression
+func (c *TypecheckContext) TypecheckProgram(reader io.Reader, filename string) (TypedProgram, error) {
 	program, err := io.ReadAll(reader)
 	if err != nil {
 		return nil, err
@@ -1063,9 +2125,5 @@ func (c *TypecheckContext) Typecheck(reader io.Reader, filename string) (TypedAs
 	if err != nil {
 		return nil, err
 	}
-	v, typecheckErr := c.typecheckNodes(nodes)
-	if typecheckErr != nil {
-		return nil, typecheckErr
-	}
-	return v, nil
+	return c.typecheckNodes(nodes)
 }
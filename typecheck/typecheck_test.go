@@ -4,6 +4,8 @@ import (
 	"dghaehre/raja/lib"
 	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
@@ -60,6 +62,9 @@ func TestBaseLib(t *testing.T) {
 		err = errors.Join(err, errors.New("Did not expect base.raja to find type errors"))
 		t.Error(err)
 	}
+	if warnings := ctx.Warnings(); warnings != nil {
+		t.Errorf("Did not expect base.raja to have warnings:\n%s", warnings)
+	}
 }
 
 func TestSimpleAdditionTypecheck(t *testing.T) {
@@ -139,6 +144,37 @@ rec_func(0)
 	expectTypecheckToReturn(t, p, typedAnyNode{})
 }
 
+func TestReturnTypeTypecheck(t *testing.T) {
+	// The annotation is used for the recursive call
+	p := `
+rec_func = (a:Int) -> Int => match a {
+  10 -> 10
+  _  -> rec_func(a + 1)
+}
+rec_func(0)
+`
+	expectTypecheckToReturn(t, p, typedIntNode{})
+
+	pTypeVar := maybe + `
+first = (l:List(a)) -> a => l.head().unwrap()
+["a"].first()
+`
+	expectTypecheckToReturn(t, pTypeVar, typedStringNode{})
+}
+
+func TestReturnTypeTypecheckError(t *testing.T) {
+	p := `
+f = (a:Int) -> Str => a + 1
+`
+	expectTypecheckToError(t, p, []error{typecheckError{}})
+
+	pCall := `
+f = (a:Int) -> Int => a
+f(1) ++ "a"
+`
+	expectTypecheckToError(t, pCall, []error{typecheckError{}})
+}
+
 func TestMatchTypecheck(t *testing.T) {
 	p := `
 one = 1.2
@@ -148,14 +184,14 @@ match one {
   a -> a
 }
 `
-	// We might should work for this as a return type instead of Any:
-	// stringOrInt := typedAliasNode{
-	// 	targets: []typedAstNode{
-	// 		typedStringNode{},
-	// 		typedIntNode{},
-	// 	}}
+	// a is bound to the type of one
+	stringOrFloat := typedAliasNode{
+		targets: []TypedAstNode{
+			typedStringNode{},
+			typedFloatNode{},
+		}}
 
-	expectTypecheckToReturn(t, p, typedAnyNode{})
+	expectTypecheckToReturn(t, p, stringOrFloat)
 
 	pMaybe := `
 alias Maybe =
@@ -169,7 +205,111 @@ match m {
   _              -> 1
 }
 	`
-	expectTypecheckToReturn(t, pMaybe, typedAnyNode{})
+	expectTypecheckToReturn(t, pMaybe, typedIntNode{})
+}
+
+func TestMatchGuardTypecheck(t *testing.T) {
+	p := `
+match 1 {
+	n if n == 1 -> "one"
+	_ -> "other"
+}`
+	expectTypecheckToReturn(t, p, typedStringNode{})
+}
+
+func TestMatchGuardTypecheckError(t *testing.T) {
+	p := `
+match 1 {
+	n if 1 + 1 -> "one"
+	_ -> "other"
+}`
+	expectTypecheckToError(t, p, []error{typecheckError{}})
+}
+
+func TestNestedPatternTypecheck(t *testing.T) {
+	p := `
+match [1, [2, 3]] {
+	[a, [b, ..rest]] -> rest
+	_ -> []
+}`
+	expectTypecheckToReturn(t, p, typedListNode{})
+
+	pUndefined := `
+match [1, [2, 3]] {
+	[a, [b, ..rest]] -> c
+	_ -> []
+}`
+	expectTypecheckToError(t, pUndefined, []error{typecheckError{}})
+}
+
+func expectTypecheckWarnings(t *testing.T, program string, count int) {
+	ctx := NewTypecheckContext()
+	ctx.LoadBuiltins()
+	_, err := ctx.Typecheck(strings.NewReader(program), "test")
+	if err != nil {
+		t.Errorf("Did not expect warnings to fail the typecheck: \n%s", err.Error())
+	}
+	warnings := 0
+	if me, ok := ctx.Warnings().(multipleErrors); ok {
+		warnings = len(me.warnings)
+	}
+	if warnings != count {
+		t.Errorf("Expected %d warnings, got %d\n\n%s", count, warnings, ctx.Warnings())
+	}
+}
+
+const sortOrder = `
+alias SortOrder =
+		SortOrder::Asc
+	| SortOrder::Desc
+`
+
+func TestExhaustiveMatchTypecheck(t *testing.T) {
+	p := sortOrder + `
+f = (so:SortOrder) => match so {
+	SortOrder::Asc -> 1
+	SortOrder::Desc -> 2
+}
+g = (so:SortOrder) => match so {
+	SortOrder::Asc -> 1
+	_ -> 2
+}`
+	expectTypecheckWarnings(t, p, 0)
+}
+
+func TestNonExhaustiveMatchTypecheck(t *testing.T) {
+	p := sortOrder + `
+f = (so:SortOrder) => match so {
+	SortOrder::Asc -> 1
+}
+g = (so:SortOrder) => match so {
+	SortOrder::Asc -> 1
+	SortOrder::Desc if false -> 2
+}`
+	expectTypecheckWarnings(t, p, 2)
+}
+
+func TestUnreachableBranchTypecheck(t *testing.T) {
+	p := `
+match 1 {
+	x -> x
+	1 -> 2
+	_ -> 3
+}`
+	expectTypecheckWarnings(t, p, 2)
+}
+
+func TestStrictWarningsFailTypecheck(t *testing.T) {
+	ctx := NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.Strict = true
+	p := sortOrder + `
+f = (so:SortOrder) => match so {
+	SortOrder::Asc -> 1
+}`
+	if _, err := ctx.Typecheck(strings.NewReader(p), "test"); err == nil {
+		t.Errorf("Expected warnings to fail a strict typecheck")
+	}
 }
 
 func TestIntAndFloatsTypecheck(t *testing.T) {
@@ -217,6 +357,32 @@ add_one(1, 2)`
 	expectTypecheckToError(t, p, []error{paramMismatchError{}})
 }
 
+func TestOverloadWithUnknownArgTypecheck(t *testing.T) {
+	// Either can be called with a, so what first returns is not known
+	p := `
+first = (s:Str) -> Str => s
+first = (l:List(Int)) -> List => l
+total = (l:List(Int)) => __length(l)
+f = (a) => first(a).total()
+f([1, 2])`
+	expectTypecheckToReturn(t, p, typedIntNode{})
+
+	// With a known argument, it is
+	p = `
+first = (l:List(Int)) -> List => l
+first = (s:Str) -> Str => s
+total = (l:List(Int)) => __length(l)
+first("a").total()`
+	expectTypecheckToError(t, p, []error{paramMismatchError{}})
+}
+
+func TestUpdateTypecheck(t *testing.T) {
+	p := `
+mut_x = 1
+mut_x.update(2)`
+	expectTypecheckToReturn(t, p, typedIntNode{})
+}
+
 func TestAliasTypecheck(t *testing.T) {
 	p := `
 alias Bool = true | false
@@ -258,7 +424,7 @@ func TestReadFileTypecheck(t *testing.T) {
 read_file = (a:Str) => __read_file(a)
 read_file("hello.txt")
 `
-	expectTypecheckToReturn(t, p, typedAliasNode{name: "Result"})
+	expectTypecheckToReturn(t, p, resultOf(typedStringNode{}, typedStringNode{}))
 }
 
 func TestFoldIndexTypecheck(t *testing.T) {
@@ -292,3 +458,397 @@ to_ok(1).unwrap()
 	expectTypecheckToReturn(t, p, typedAnyNode{})
 
 }
+
+func TestMapTypecheck(t *testing.T) {
+	p := `
+insert = (m:Map, key, value) => __map_insert(m, key, value)
+{"a": 1}.insert("b", 2)`
+	expectTypecheckToReturn(t, p, typedMapNode{})
+}
+
+func TestMapTypecheckError(t *testing.T) {
+	p := `
+insert = (m:Map, key, value) => __map_insert(m, key, value)
+insert("not a map", "b", 2)`
+	expectTypecheckToError(t, p, []error{paramMismatchError{}})
+}
+
+func TestImportTypecheck(t *testing.T) {
+	dir := t.TempDir()
+	module := `
+add_one = (a:Int) => a + 1
+`
+	if err := os.WriteFile(filepath.Join(dir, "numbers.raja"), []byte(module), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	ctx := NewTypecheckContext()
+	ctx.LoadBuiltins()
+	p := `
+numbers = import "numbers.raja"
+numbers.add_one(1)
+`
+	val, err := ctx.Typecheck(strings.NewReader(p), filepath.Join(dir, "main.raja"))
+	if err != nil {
+		t.Fatalf("Did not expect program to typecheck with error: \n%s", err.Error())
+	}
+	if val.String() != "Int" {
+		t.Errorf("Expected Int, got %s", val)
+	}
+
+	ctx = NewTypecheckContext()
+	ctx.LoadBuiltins()
+	p = `
+import numbers
+numbers.add_one("not an int")
+numbers.does_not_exist(1)
+`
+	_, err = ctx.Typecheck(strings.NewReader(p), filepath.Join(dir, "main.raja"))
+	multiErrors, ok := err.(multipleErrors)
+	if !ok || len(multiErrors.errors) != 2 {
+		t.Errorf("Expected 2 errors, got: %v", err)
+	}
+}
+
+func TestImportScopeTypecheck(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "counter.raja"), []byte(`count = (l:List) => l.length()`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "peek.raja"), []byte(`peek = () => secret`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	p := `
+import counter
+id = (a) => a
+id(counter)
+counter.count([])
+`
+	// A module given as an argument is not what the call is looked up in
+	val, err := ctx.Typecheck(strings.NewReader(p), filepath.Join(dir, "main.raja"))
+	if err != nil {
+		t.Fatalf("Did not expect program to typecheck with error: \n%s", err.Error())
+	}
+	if val.String() != "Int" {
+		t.Errorf("Expected Int, got %s", val)
+	}
+
+	ctx = NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	p = `
+secret = 1
+import peek
+`
+	_, err = ctx.Typecheck(strings.NewReader(p), filepath.Join(dir, "main.raja"))
+	if err == nil || !strings.Contains(err.Error(), "secret is not defined") {
+		t.Errorf("Expected secret to be undefined in the module, got: %v", err)
+	}
+}
+
+func TestCyclicImportTypecheck(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.raja"), []byte(`import b`), 0o644)
+	os.WriteFile(filepath.Join(dir, "b.raja"), []byte(`import a`), 0o644)
+	ctx := NewTypecheckContext()
+	ctx.LoadBuiltins()
+	_, err := ctx.Typecheck(strings.NewReader(`import a`), filepath.Join(dir, "main.raja"))
+	if err == nil || !strings.Contains(err.Error(), "Cyclic import") {
+		t.Errorf("Expected a cyclic import error, got: %v", err)
+	}
+}
+
+const maybe = `
+alias Maybe(a) =
+		Maybe::Some(a)
+	| Maybe::None
+
+head = (l:List(a)) => __index(l, 0, false)
+
+unwrap = (m:Maybe(a)) => match m {
+	Maybe::Some(x) -> x
+	Maybe::None -> __exit(1)
+}
+`
+
+func TestParametricTypecheck(t *testing.T) {
+	expectTypecheckToReturn(t, maybe+`[1, 2].head().unwrap()`, typedIntNode{})
+	expectTypecheckToReturn(t, maybe+`["a"].head()`, maybeOf(typedStringNode{}))
+	expectTypecheckToReturn(t, maybe+`[[1], [2]].head().unwrap()`, typedListNode{elem: typedIntNode{}})
+	expectTypecheckToReturn(t, `{"a": 1}.__map_keys()`, typedListNode{elem: typedStringNode{}})
+
+	p := `
+alias Num = Int | Float
+sum = (l:List(Num)) => 0
+[1, 2.5].sum()
+`
+	expectTypecheckToReturn(t, p, typedIntNode{})
+}
+
+func TestParametricTypecheckError(t *testing.T) {
+	p := `
+alias Num = Int | Float
+sum = (l:List(Num)) => 0
+["a"].sum()
+`
+	expectTypecheckToError(t, p, []error{paramMismatchError{}})
+
+	pMaybe := maybe + `
+only_strings = (m:Maybe(Str)) => m
+[1].head().only_strings()
+`
+	expectTypecheckToError(t, pMaybe, []error{paramMismatchError{}})
+
+	pParams := `
+f = (l:List(Int, Str)) => l
+`
+	expectTypecheckToError(t, pParams, []error{typecheckError{}})
+
+	pGrid := `
+alias Grid = List(List(Int))
+f = (g:Grid) => "grid"
+f([1, "a"])
+`
+	expectTypecheckToError(t, pGrid, []error{paramMismatchError{}})
+
+	// Only single letters are type variables, so a typo like int is not taken as one
+	pUnknown := `
+f = (i:int) => i
+`
+	expectTypecheckToError(t, pUnknown, []error{typecheckError{}})
+}
+
+func TestTryTypecheck(t *testing.T) {
+	ctx := NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	p := `
+	r = try(() => panic("oh no"))
+	match r {
+		Result::Ok(_)  -> "ok"
+		Result::Err(e) -> e
+	}
+	`
+	val, err := ctx.Typecheck(strings.NewReader(p), "test")
+	if err != nil {
+		t.Fatalf("Did not expect program to typecheck with error: \n%s", err.Error())
+	}
+	if val.String() != (typedStringNode{}).String() {
+		t.Errorf("Expected Str, got %s", val)
+	}
+}
+
+func TestReadStdinTypecheck(t *testing.T) {
+	ctx := NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	p := `
+	first = match read_line() {
+		Maybe::Some(line) -> line
+		Maybe::None       -> ""
+	}
+	rest = read_all_stdin().unwrap()
+	first ++ rest
+	`
+	val, err := ctx.Typecheck(strings.NewReader(p), "test")
+	if err != nil {
+		t.Fatalf("Did not expect program to typecheck with error: \n%s", err.Error())
+	}
+	if val.String() != (typedStringNode{}).String() {
+		t.Errorf("Expected Str, got %s", val)
+	}
+}
+
+func TestFsModuleTypecheck(t *testing.T) {
+	ctx := NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	p := `
+	import fs
+	match fs.list_dir(".") {
+		Result::Ok(names) -> names
+		Result::Err(e)    -> [e]
+	}
+	`
+	val, err := ctx.Typecheck(strings.NewReader(p), "test")
+	if err != nil {
+		t.Fatalf("Did not expect program to typecheck with error: \n%s", err.Error())
+	}
+	if val.String() != (typedListNode{elem: typedStringNode{}}).String() {
+		t.Errorf("Expected List(Str), got %s", val)
+	}
+
+	ctx = NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	_, err = ctx.Typecheck(strings.NewReader(`
+	import fs
+	fs.write_file("out.txt", 42)
+	`), "test")
+	if err == nil {
+		t.Errorf("Expected a type error writing an Int")
+	}
+}
+
+func TestStringsModuleTypecheck(t *testing.T) {
+	ctx := NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	p := `
+	import strings
+	strings.split(strings.pad_left("a,b", 5), ",")
+	`
+	val, err := ctx.Typecheck(strings.NewReader(p), "test")
+	if err != nil {
+		t.Fatalf("Did not expect program to typecheck with error: \n%s", err.Error())
+	}
+	if val.String() != (typedListNode{elem: typedStringNode{}}).String() {
+		t.Errorf("Expected List(Str), got %s", val)
+	}
+
+	ctx = NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	_, err = ctx.Typecheck(strings.NewReader(`
+	import strings
+	strings.repeat("a", "b")
+	`), "test")
+	if err == nil {
+		t.Errorf("Expected a type error repeating a Str times")
+	}
+}
+
+func TestCharTypecheck(t *testing.T) {
+	ctx := NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	p := `
+	c = chr(97)
+	"ab" ++ c
+	`
+	val, err := ctx.Typecheck(strings.NewReader(p), "test")
+	if err != nil {
+		t.Fatalf("Did not expect program to typecheck with error: \n%s", err.Error())
+	}
+	if val.String() != (typedStringNode{}).String() {
+		t.Errorf("Expected Str, got %s", val)
+	}
+
+	ctx = NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	_, err = ctx.Typecheck(strings.NewReader(`
+	c = chr(97)
+	c ++ "b"
+	`), "test")
+	if err == nil {
+		t.Errorf("Expected a type error for Char ++ Str")
+	}
+}
+
+func TestBigIntTypecheck(t *testing.T) {
+	ctx := NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	p := `
+	import math
+	double = (x:BigInt) -> BigInt => x * 2
+	big = 9223372036854775807 + 1
+	sum([double(big), 1.5]).to_float() + math.pow(2, 64)
+	`
+	val, err := ctx.Typecheck(strings.NewReader(p), "test")
+	if err != nil {
+		t.Fatalf("Did not expect program to typecheck with error: \n%s", err.Error())
+	}
+	if val.String() != (typedFloatNode{}).String() {
+		t.Errorf("Expected Float, got %s", val)
+	}
+}
+
+func TestOperatorsTypecheck(t *testing.T) {
+	for p, expected := range map[string]string{
+		"true && false":                     "Bool",
+		"!true || \"a\".has_prefix?(\"a\")": "Bool",
+		"-1":                                "Int",
+		"-2.5 * 2":                          "Float",
+		"-(1 + 2)":                          "Int",
+		"1 < 2.5 && [1] == [1]":             "Bool",
+	} {
+		ctx := NewTypecheckContext()
+		ctx.LoadBuiltins()
+		ctx.LoadLibs()
+		val, err := ctx.Typecheck(strings.NewReader(p), "test")
+		if err != nil {
+			t.Errorf("Did not expect %s to typecheck with error: \n%s", p, err.Error())
+			continue
+		}
+		if val.String() != expected {
+			t.Errorf("Expected %s to be %s, got %s", p, expected, val)
+		}
+	}
+
+	for _, p := range []string{`1 && true`, `!"a"`, `-"a"`} {
+		ctx := NewTypecheckContext()
+		ctx.LoadBuiltins()
+		ctx.LoadLibs()
+		if _, err := ctx.Typecheck(strings.NewReader(p), "test"); err == nil {
+			t.Errorf("Expected a type error for %s", p)
+		}
+	}
+}
+
+func TestMathModuleTypecheck(t *testing.T) {
+	for p, expected := range map[string]string{
+		"math.abs(1)":          "Int",
+		"math.abs(1.5)":        "Float",
+		"math.pow(2, 3)":       "Int",
+		"math.pow(2.0, 3)":     "Float",
+		"math.min(1, 2)":       "Int",
+		"math.sqrt(2) + 1":     "Float",
+		"math.round(2.5) + 1":  "Int",
+		"math.div(7, 2) * 2":   "Int",
+		"to_float(1) + 1":      "Float",
+		"to_int(1.5) + 1":      "Int",
+		"float(\"1.5\")":       "Result(Float, Str)",
+		"math.gcd(12, 18) - 1": "Int",
+		"math.pi * 2":          "Float",
+		"math.log(math.e)":     "Float",
+	} {
+		ctx := NewTypecheckContext()
+		ctx.LoadBuiltins()
+		ctx.LoadLibs()
+		val, err := ctx.Typecheck(strings.NewReader("import math\n"+p), "test")
+		if err != nil {
+			t.Errorf("Did not expect %s to typecheck with error: \n%s", p, err.Error())
+			continue
+		}
+		if val.String() != expected {
+			t.Errorf("Expected %s to be %s, got %s", p, expected, val)
+		}
+	}
+
+	ctx := NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	_, err := ctx.Typecheck(strings.NewReader(`
+	import math
+	math.sqrt("2")
+	`), "test")
+	if err == nil {
+		t.Errorf("Expected a type error taking the square root of a Str")
+	}
+
+	ctx = NewTypecheckContext()
+	ctx.LoadBuiltins()
+	ctx.LoadLibs()
+	_, err = ctx.Typecheck(strings.NewReader(`
+	import math
+	math.tau
+	`), "test")
+	if err == nil {
+		t.Errorf("Expected a type error for a binding that is not in the module")
+	}
+}